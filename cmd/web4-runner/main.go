@@ -0,0 +1,353 @@
+// Command web4-runner runs Web4 jobs.
+//
+// Usage:
+//
+//	web4-runner [run]    run the configured tasks as one batch
+//	web4-runner pipeline run the configured task trees
+//	web4-runner dynamic  run task trees extended by follow-up rules
+//	web4-runner serve    serve the task API while running the batch
+//	web4-runner worker   claim and run tasks from the shared queue
+//	web4-runner plan     print what a mode would run, without running it
+//	web4-runner graph    render the task trees, or a run's tasks, as a graph
+//	web4-runner token    create, list, rotate and revoke API tokens
+//	web4-runner secret   manage the encrypted secrets file
+//
+// The configuration is read from CONFIG_JSON. API_ADDR exposes the task API
+// for run, pipeline, dynamic and worker; METRICS_ADDR serves /metrics on its own
+// port, by default :2112 for pipeline and dynamic. serve listens on -addr,
+// or API_ADDR, with /metrics on the same port.
+//
+// plan expands the tasks of -mode, by default the one the config is written
+// for, checks each of them and prints the execution plan, or with -json the
+// plan as JSON. It exits with status 1 if any task would fail its checks.
+// It makes no network, chain or storage calls.
+//
+// graph renders the task trees of -mode as -format dot, mermaid or json.
+// With -tasks it renders a run instead, from a file of task records as
+// served by GET /tasks ("-" reads stdin), with each task's status and
+// duration.
+//
+// token manages the API tokens in the config's auth.tokens_file:
+//
+//	web4-runner token create -name ci -scopes read,submit [-namespaces a,b] [-ttl 720h]
+//	web4-runner token list
+//	web4-runner token rotate [-ttl 720h] ID
+//	web4-runner token revoke ID
+//
+// create and rotate print the new token once; only its hash is stored.
+// Scopes are read, submit and admin, each including the ones before it;
+// blockchain and exec tasks take admin. Without auth, exec tasks cannot be
+// submitted through the API.
+// A token bound to namespaces sees and submits only their tasks.
+// A running server picks up changes on its next request.
+//
+// Task specs refer to secrets as ${secret:name}, resolved when the task runs
+// from the providers under secrets in the config. secret manages the
+// encrypted file of the first file provider, with the key from its key_env:
+//
+//	web4-runner secret keygen
+//	web4-runner secret set NAME < value
+//	web4-runner secret list
+//	web4-runner secret delete NAME
+//
+// keygen prints a new key and needs no config. set reads the value from
+// stdin, dropping one trailing newline.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/runner"
+)
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
+	sub := ""
+	if (cmd == "token" || cmd == "secret") && len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	addr := fs.String("addr", envOr("API_ADDR", ":8080"), "listen address for serve")
+	mode := fs.String("mode", "", "run mode for plan: run, pipeline or dynamic")
+	asJSON := fs.Bool("json", false, "print the plan as JSON")
+	format := fs.String("format", runner.GraphDOT, "graph format: dot, mermaid or json")
+	tasksFile := fs.String("tasks", "", "graph a run from this task list instead of the config")
+	name := fs.String("name", "", "token create: what the token is for")
+	scopes := fs.String("scopes", "read", "token create: comma-separated scopes: read, submit, admin")
+	namespaces := fs.String("namespaces", "", "token create: comma-separated namespaces, default all")
+	ttl := fs.Duration("ttl", 0, "token create and rotate: lifetime, 0 for none")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if cmd == "secret" && sub == "keygen" && fs.NArg() == 0 {
+		key, err := runner.NewSecretKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+	cfg, err := runner.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch cmd {
+	case "plan":
+		os.Exit(plan(cfg, *mode, *asJSON))
+	case "graph":
+		if err := graph(cfg, *mode, *format, *tasksFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "token":
+		if err := token(cfg, sub, fs.Args(), *name, *scopes, *namespaces, *ttl); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "secret":
+		if err := secret(cfg, sub, fs.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := runner.Setup(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	switch cmd {
+	case "run":
+		startServers("")
+		runner.RunBatch(ctx, cfg)
+	case "pipeline":
+		startServers(":2112")
+		runner.RunPipeline(ctx, cfg)
+	case "dynamic":
+		startServers(":2112")
+		if err := runner.RunDynamic(ctx, cfg); err != nil {
+			log.Fatal(err)
+		}
+	case "worker":
+		startServers("")
+		if err := runner.Work(ctx, cfg); err != nil {
+			log.Fatal(err)
+		}
+	case "serve":
+		if err := runner.Serve(ctx, cfg, *addr); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	// Tasks submitted through the API get a grace period to finish.
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	if err := runner.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Canceled tasks submitted through the API: %v", err)
+	}
+}
+
+// plan prints the plan for mode and returns the exit status.
+func plan(cfg runner.Config, mode string, asJSON bool) int {
+	p, err := runner.MakePlan(cfg, mode)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(p)
+	} else {
+		err = p.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	if p.Err() != nil {
+		return 1
+	}
+	return 0
+}
+
+// graph writes the graph of the config's task trees, or of the run whose
+// task records are in tasksFile.
+func graph(cfg runner.Config, mode, format, tasksFile string) error {
+	if tasksFile == "" {
+		p, err := runner.MakePlan(cfg, mode)
+		if err != nil {
+			return err
+		}
+		return runner.PlanGraph(p).Write(os.Stdout, format)
+	}
+	in := os.Stdin
+	if tasksFile != "-" {
+		f, err := os.Open(tasksFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var recs []runner.TaskRecord
+	if err := json.NewDecoder(in).Decode(&recs); err != nil {
+		return fmt.Errorf("%s: %w", tasksFile, err)
+	}
+	return runner.RunGraph(recs).Write(os.Stdout, format)
+}
+
+// token runs a token subcommand against the config's token store.
+func token(cfg runner.Config, sub string, args []string, name, scopes, namespaces string, ttl time.Duration) error {
+	if cfg.Auth == nil || cfg.Auth.TokensFile == "" {
+		return fmt.Errorf("token: auth.tokens_file is not configured")
+	}
+	store, err := runner.OpenTokenStore(cfg.Auth.TokensFile)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sub == "create" && len(args) == 0:
+		tok, t, err := store.Create(name, strings.Split(scopes, ","), list(namespaces), ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created token %s (%s)\n%s\n", t.ID, t.Name, tok)
+	case sub == "list" && len(args) == 0:
+		tokens, err := store.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tNAMESPACES\tCREATED\tEXPIRES\tSTATUS")
+		now := time.Now()
+		for _, t := range tokens {
+			ns := strings.Join(t.Namespaces, ",")
+			if ns == "" {
+				ns = "*"
+			}
+			expires := "never"
+			if !t.Expires.IsZero() {
+				expires = t.Expires.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, strings.Join(t.Scopes, ","), ns,
+				t.Created.Local().Format(time.DateTime), expires, t.Status(now))
+		}
+		return tw.Flush()
+	case sub == "rotate" && len(args) == 1:
+		tok, t, err := store.Rotate(args[0], ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rotated token %s (%s); the old token no longer works\n%s\n", t.ID, t.Name, tok)
+	case sub == "revoke" && len(args) == 1:
+		if err := store.Revoke(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked token %s\n", args[0])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// secret runs a secret subcommand against the config's encrypted secrets
+// file.
+func secret(cfg runner.Config, sub string, args []string) error {
+	file, err := runner.SecretFileFor(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sub == "set" && len(args) == 1:
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+		if err := file.Set(args[0], value); err != nil {
+			return err
+		}
+		fmt.Printf("stored secret %s\n", args[0])
+	case sub == "list" && len(args) == 0:
+		names, err := file.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case sub == "delete" && len(args) == 1:
+		if err := file.Delete(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("deleted secret %s\n", args[0])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// list splits a comma-separated flag value, giving nil for an empty one.
+func list(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// startServers starts the API and metrics listeners the environment asks
+// for; metricsDefault is used when METRICS_ADDR is not set.
+func startServers(metricsDefault string) {
+	if addr := os.Getenv("API_ADDR"); addr != "" {
+		go listen("Task API", addr, runner.Handler())
+	}
+	if addr := envOr("METRICS_ADDR", metricsDefault); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", runner.MetricsHandler())
+		go listen("Metrics", addr, mux)
+	}
+}
+
+func listen(name, addr string, h http.Handler) {
+	log.Printf("%s listening on %s", name, addr)
+	log.Fatal(http.ListenAndServe(addr, h))
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: web4-runner [run|pipeline|dynamic|serve|worker] [-addr host:port]")
+	fmt.Fprintln(os.Stderr, "       web4-runner plan [-mode run|pipeline|dynamic] [-json]")
+	fmt.Fprintln(os.Stderr, "       web4-runner graph [-mode run|pipeline|dynamic] [-format dot|mermaid|json] [-tasks file]")
+	fmt.Fprintln(os.Stderr, "       web4-runner token create -name name [-scopes read,submit,admin] [-namespaces a,b] [-ttl 720h]")
+	fmt.Fprintln(os.Stderr, "       web4-runner token list | rotate [-ttl 720h] ID | revoke ID")
+	fmt.Fprintln(os.Stderr, "       web4-runner secret keygen | set NAME < value | list | delete NAME")
+}
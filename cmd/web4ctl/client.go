@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client calls one runner's task API.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(server, token string) *client {
+	return &client{server: strings.TrimRight(server, "/"), token: token, http: &http.Client{}}
+}
+
+// do sends a request and returns the response if its status is 2xx, or an
+// error carrying the API's message.
+func (c *client) do(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// call sends in as JSON, when set, and decodes the answer into out, when
+// set.
+func (c *client) call(method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(b), "application/json"
+	}
+	resp, err := c.do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// sseEvent is one server-sent event of a task stream.
+type sseEvent struct {
+	kind string
+	data string
+}
+
+// stream reads the server-sent events at path until the stream ends or fn
+// returns false.
+func (c *client) stream(path string, fn func(sseEvent) bool) error {
+	c.http.Timeout = 0
+	resp, err := c.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 4<<20)
+	var ev sseEvent
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			ev.kind = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data += strings.TrimPrefix(line, "data: ")
+		case line == "":
+			if ev.kind != "" && !fn(ev) {
+				return nil
+			}
+			ev = sseEvent{}
+		}
+	}
+	return sc.Err()
+}
+
+// duration is how long a task ran, or "" while it has not finished.
+func duration(started, finished time.Time) string {
+	if started.IsZero() || finished.IsZero() {
+		return ""
+	}
+	return finished.Sub(started).Round(time.Millisecond).String()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ctlConfig is the web4ctl config file: named runner endpoints and the one
+// in use.
+type ctlConfig struct {
+	CurrentContext string                `yaml:"current-context,omitempty"`
+	Contexts       map[string]ctlContext `yaml:"contexts,omitempty"`
+}
+
+// ctlContext is one runner API endpoint and how to authenticate to it.
+type ctlContext struct {
+	Server    string `yaml:"server"`
+	Token     string `yaml:"token,omitempty"`
+	TokenEnv  string `yaml:"token-env,omitempty"` // env var holding the token, so it stays out of the file
+	Namespace string `yaml:"namespace,omitempty"` // namespace to submit to and list, default the server's
+}
+
+func (c ctlContext) token() string {
+	if c.TokenEnv != "" {
+		return os.Getenv(c.TokenEnv)
+	}
+	return c.Token
+}
+
+// configPath is $WEB4CTL_CONFIG, or web4ctl/config.yaml in the user's
+// config directory.
+func configPath() (string, error) {
+	if p := os.Getenv("WEB4CTL_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "web4ctl", "config.yaml"), nil
+}
+
+// loadConfig reads the config file; a missing file is an empty config.
+func loadConfig() (*ctlConfig, string, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg := &ctlConfig{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, path, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, path, nil
+}
+
+func (c *ctlConfig) save(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// endpoint resolves the server and token: flags first, then WEB4CTL_SERVER
+// and WEB4CTL_TOKEN, then the chosen or current context.
+func (c *ctlConfig) endpoint(context, server, token string) (string, string, error) {
+	if context == "" {
+		context = c.CurrentContext
+	}
+	var ctx ctlContext
+	if context != "" {
+		var ok bool
+		if ctx, ok = c.Contexts[context]; !ok {
+			return "", "", fmt.Errorf("context %q not found", context)
+		}
+	}
+	server = firstNonEmpty(server, os.Getenv("WEB4CTL_SERVER"), ctx.Server, "http://localhost:8080")
+	token = firstNonEmpty(token, os.Getenv("WEB4CTL_TOKEN"), ctx.token())
+	return server, token, nil
+}
+
+// namespace resolves the namespace the same way: the flag, then
+// WEB4CTL_NAMESPACE, then the context's.
+func (c *ctlConfig) namespace(context, namespace string) string {
+	if context == "" {
+		context = c.CurrentContext
+	}
+	return firstNonEmpty(namespace, os.Getenv("WEB4CTL_NAMESPACE"), c.Contexts[context].Namespace)
+}
+
+func (c *ctlConfig) names() []string {
+	var names []string
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,595 @@
+// Command web4ctl drives a web4-runner through its task API.
+//
+// Usage:
+//
+//	web4ctl [flags] submit [-f file]             submit tasks from a file, or stdin
+//	web4ctl [flags] list [-status s] [-type t]   list tasks
+//	web4ctl [flags] get ID                       show a task
+//	web4ctl [flags] logs ID [-follow]            print a task's log, or stream it
+//	web4ctl [flags] cancel ID                    cancel a running or queued task
+//	web4ctl [flags] retry ID                     run a failed task again
+//	web4ctl [flags] dlq [list]                   list tasks that failed for good
+//	web4ctl [flags] dlq requeue [-all] [ID...]   retry dead letters
+//	web4ctl [flags] graph [-format f] [-f file]  render the run, or a config's pipeline
+//	web4ctl [flags] costs [-by key]              AI and gas costs by namespace, pipeline, task or day
+//	web4ctl config get-contexts|use-context NAME|set-context NAME [-server URL] [-token T] [-token-env VAR] [-namespace NS]
+//
+// Flags, which subcommands accept too for -o:
+//
+//	-context name     config context to use, default the current one
+//	-server url       runner API, overriding the context
+//	-token token      bearer token, overriding the context
+//	-n namespace      namespace to submit to and list, overriding the context
+//	-o format         table, json or yaml
+//
+// Contexts are kept in $WEB4CTL_CONFIG, by default web4ctl/config.yaml in
+// the user's config directory. WEB4CTL_SERVER and WEB4CTL_TOKEN override
+// the context but not the flags, as WEB4CTL_NAMESPACE does for -n. Task
+// files may be a task spec, a list of
+// them or a config with "tasks", in JSON or YAML.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/runner"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// errUsage reports bad arguments; run exits with status 2 for it.
+var errUsage = errors.New("usage")
+
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	output         string
+	client         *client
+	cfg            *ctlConfig
+	cfgPath        string
+	namespace      string
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("web4ctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	contextName := fs.String("context", "", "config context to use")
+	server := fs.String("server", "", "runner API URL")
+	token := fs.String("token", "", "bearer token")
+	namespace := fs.String("n", "", "namespace to submit to and list")
+	fs.StringVar(&c.output, "o", outTable, "output format: table, json or yaml")
+	fs.Usage = c.usage
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		c.usage()
+		return 2
+	}
+
+	var err error
+	if c.cfg, c.cfgPath, err = loadConfig(); err != nil {
+		fmt.Fprintln(stderr, "web4ctl:", err)
+		return 1
+	}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	if cmd != "config" {
+		srv, tok, err := c.cfg.endpoint(*contextName, *server, *token)
+		if err != nil {
+			fmt.Fprintln(stderr, "web4ctl:", err)
+			return 1
+		}
+		c.client = newClient(srv, tok)
+		c.namespace = c.cfg.namespace(*contextName, *namespace)
+	}
+
+	switch cmd {
+	case "submit":
+		err = c.submit(rest)
+	case "list":
+		err = c.list(rest)
+	case "get":
+		err = c.get(rest)
+	case "logs":
+		err = c.logs(rest)
+	case "cancel":
+		err = c.cancel(rest)
+	case "retry":
+		err = c.retry(rest)
+	case "dlq":
+		err = c.dlq(rest)
+	case "graph":
+		err = c.graph(rest)
+	case "costs":
+		err = c.costs(rest)
+	case "config":
+		err = c.config(rest)
+	default:
+		err = errUsage
+	}
+	switch {
+	case errors.Is(err, errUsage):
+		c.usage()
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, "web4ctl:", err)
+		return 1
+	}
+	return 0
+}
+
+func (c *cli) usage() {
+	fmt.Fprint(c.stderr, `usage: web4ctl [-context name] [-server url] [-token token] [-n namespace] [-o table|json|yaml] command
+commands: submit, list, get, logs, cancel, retry, dlq, graph, costs, config
+`)
+}
+
+// flags is a subcommand's flag set, which takes -o as well.
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.output, "o", c.output, "output format: table, json or yaml")
+	return fs
+}
+
+// parseArgs parses a subcommand's args, taking flags after its positional
+// arguments too, as in "logs 42 -follow". fs.Args() then holds the
+// positional ones; everything after "--" is positional.
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	var pos []string
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			pos = append(pos, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		pos, args = append(pos, rest[0]), rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, pos...))
+}
+
+// taskID parses the single ID argument of a subcommand.
+func (c *cli) taskID(name string, args []string) (int, *flag.FlagSet, error) {
+	fs := c.flags(name)
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 1 {
+		return 0, nil, errUsage
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid task id %q", fs.Arg(0))
+	}
+	return id, fs, nil
+}
+
+// ---------------- TASKS ----------------
+
+func (c *cli) submit(args []string) error {
+	fs := c.flags("submit")
+	file := fs.String("f", "-", "task file, - for stdin")
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	specs, err := c.readSpecs(*file)
+	if err != nil {
+		return err
+	}
+	var subs []runner.Submitted
+	for _, spec := range specs {
+		if spec.Namespace == "" {
+			spec.Namespace = c.namespace
+		}
+		var sub runner.Submitted
+		if err := c.client.call(http.MethodPost, "/tasks", spec, &sub); err != nil {
+			return err
+		}
+		subs = append(subs, sub)
+	}
+	return c.renderSubmitted(subs)
+}
+
+// readSpecs reads a task spec, a list of them or a config's tasks from a
+// JSON or YAML file.
+func (c *cli) readSpecs(file string) ([]runner.TaskSpec, error) {
+	doc, err := c.readDoc(file)
+	if err != nil {
+		return nil, err
+	}
+	var specs []runner.TaskSpec
+	switch d := doc.(type) {
+	case []interface{}:
+		err = json.Unmarshal(mustJSON(d), &specs)
+	case map[string]interface{}:
+		if tasks, ok := d["tasks"]; ok {
+			err = json.Unmarshal(mustJSON(tasks), &specs)
+			break
+		}
+		var spec runner.TaskSpec
+		err = json.Unmarshal(mustJSON(d), &spec)
+		specs = append(specs, spec)
+	default:
+		err = errors.New("want a task, a list of tasks or a config with tasks")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return specs, nil
+}
+
+// readDoc parses a JSON or YAML file, or stdin for "-".
+func (c *cli) readDoc(file string) (interface{}, error) {
+	in := c.stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return doc, nil
+}
+
+func mustJSON(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func (c *cli) renderSubmitted(subs []runner.Submitted) error {
+	return render(c.stdout, c.output, subs, func() table {
+		t := table{header: []string{"ID", "WHERE"}}
+		for _, s := range subs {
+			where := "runner"
+			if s.Shared {
+				where = "shared queue"
+			}
+			t.rows = append(t.rows, []string{strconv.Itoa(s.ID), where})
+		}
+		return t
+	})
+}
+
+func (c *cli) list(args []string) error {
+	fs := c.flags("list")
+	status := fs.String("status", "", "only tasks with this status")
+	taskType := fs.String("type", "", "only tasks of this type")
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	q := url.Values{}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	if *taskType != "" {
+		q.Set("type", *taskType)
+	}
+	if c.namespace != "" {
+		q.Set("namespace", c.namespace)
+	}
+	var recs []runner.TaskRecord
+	if err := c.client.call(http.MethodGet, "/tasks?"+q.Encode(), nil, &recs); err != nil {
+		return err
+	}
+	return c.renderTasks(recs, recs)
+}
+
+func (c *cli) get(args []string) error {
+	id, _, err := c.taskID("get", args)
+	if err != nil {
+		return err
+	}
+	var rec runner.TaskRecord
+	if err := c.client.call(http.MethodGet, fmt.Sprintf("/tasks/%d", id), nil, &rec); err != nil {
+		return err
+	}
+	return c.renderTasks(rec, []runner.TaskRecord{rec})
+}
+
+func (c *cli) renderTasks(v interface{}, recs []runner.TaskRecord) error {
+	return render(c.stdout, c.output, v, func() table {
+		t := table{header: []string{"ID", "NAMESPACE", "TYPE", "STATUS", "ATTEMPTS", "DURATION", "ERROR"}}
+		for _, r := range recs {
+			t.rows = append(t.rows, []string{strconv.Itoa(r.ID), cell(r.Namespace), r.Type, r.Status, strconv.Itoa(r.Attempts), duration(r.Started, r.Finished), cell(r.Error)})
+		}
+		return t
+	})
+}
+
+func (c *cli) logs(args []string) error {
+	fs := c.flags("logs")
+	follow := fs.Bool("follow", false, "stream the log until the task finishes")
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid task id %q", fs.Arg(0))
+	}
+	if !*follow {
+		var rec runner.TaskRecord
+		if err := c.client.call(http.MethodGet, fmt.Sprintf("/tasks/%d", id), nil, &rec); err != nil {
+			return err
+		}
+		for _, line := range rec.Log {
+			fmt.Fprintln(c.stdout, line)
+		}
+		return nil
+	}
+
+	partial := false
+	return c.client.stream(fmt.Sprintf("/tasks/%d/stream", id), func(ev sseEvent) bool {
+		switch ev.kind {
+		case "log", "partial":
+			var se runner.StreamEvent
+			if json.Unmarshal([]byte(ev.data), &se) != nil {
+				return true
+			}
+			if ev.kind == "partial" {
+				fmt.Fprint(c.stdout, se.Data)
+				partial = true
+				return true
+			}
+			if partial {
+				fmt.Fprintln(c.stdout)
+				partial = false
+			}
+			fmt.Fprintln(c.stdout, se.Data)
+		case "done":
+			var rec runner.TaskRecord
+			json.Unmarshal([]byte(ev.data), &rec)
+			if partial {
+				fmt.Fprintln(c.stdout)
+			}
+			fmt.Fprintf(c.stderr, "task %d %s\n", rec.ID, rec.Status)
+			return false
+		}
+		return true
+	})
+}
+
+func (c *cli) cancel(args []string) error {
+	id, _, err := c.taskID("cancel", args)
+	if err != nil {
+		return err
+	}
+	if err := c.client.call(http.MethodPost, fmt.Sprintf("/tasks/%d/cancel", id), nil, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "task %d canceled\n", id)
+	return nil
+}
+
+func (c *cli) retry(args []string) error {
+	id, _, err := c.taskID("retry", args)
+	if err != nil {
+		return err
+	}
+	var sub runner.Submitted
+	if err := c.client.call(http.MethodPost, fmt.Sprintf("/tasks/%d/retry", id), nil, &sub); err != nil {
+		return err
+	}
+	return c.renderSubmitted([]runner.Submitted{sub})
+}
+
+// ---------------- DEAD LETTERS ----------------
+
+func (c *cli) dlq(args []string) error {
+	sub := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "list":
+		fs := c.flags("dlq list")
+		if err := parseArgs(fs, args); err != nil || fs.NArg() != 0 {
+			return errUsage
+		}
+		dead, err := c.deadLetters()
+		if err != nil {
+			return err
+		}
+		return render(c.stdout, c.output, dead, func() table {
+			t := table{header: []string{"ID", "NAMESPACE", "TYPE", "ATTEMPTS", "FAILED", "ERROR"}}
+			for _, d := range dead {
+				failed := ""
+				if !d.Failed.IsZero() {
+					failed = d.Failed.Local().Format("2006-01-02 15:04:05")
+				}
+				t.rows = append(t.rows, []string{strconv.Itoa(d.ID), d.Namespace, d.Type, strconv.Itoa(d.Attempts), failed, cell(d.Error)})
+			}
+			return t
+		})
+	case "requeue":
+		fs := c.flags("dlq requeue")
+		all := fs.Bool("all", false, "requeue every dead letter")
+		if err := parseArgs(fs, args); err != nil || (*all == (fs.NArg() > 0)) {
+			return errUsage
+		}
+		var ids []int
+		if *all {
+			dead, err := c.deadLetters()
+			if err != nil {
+				return err
+			}
+			for _, d := range dead {
+				ids = append(ids, d.ID)
+			}
+		}
+		for _, arg := range fs.Args() {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid task id %q", arg)
+			}
+			ids = append(ids, id)
+		}
+		var subs []runner.Submitted
+		for _, id := range ids {
+			var sub runner.Submitted
+			if err := c.client.call(http.MethodPost, fmt.Sprintf("/tasks/%d/retry", id), nil, &sub); err != nil {
+				return err
+			}
+			subs = append(subs, sub)
+		}
+		return c.renderSubmitted(subs)
+	default:
+		return errUsage
+	}
+}
+
+func (c *cli) deadLetters() ([]runner.DeadLetter, error) {
+	var dead []runner.DeadLetter
+	path := "/dlq"
+	if c.namespace != "" {
+		path += "?namespace=" + url.QueryEscape(c.namespace)
+	}
+	err := c.client.call(http.MethodGet, path, nil, &dead)
+	return dead, err
+}
+
+// ---------------- GRAPH ----------------
+
+func (c *cli) graph(args []string) error {
+	fs := c.flags("graph")
+	format := fs.String("format", runner.GraphDOT, "dot, mermaid or json")
+	file := fs.String("f", "", "graph this config's pipeline instead of the run, - for stdin")
+	mode := fs.String("mode", "", "run mode of the config: run, pipeline or dynamic")
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	q := url.Values{"format": {*format}}
+	method, contentType := http.MethodGet, ""
+	var body io.Reader
+	if *file != "" {
+		doc, err := c.readDoc(*file)
+		if err != nil {
+			return err
+		}
+		q.Set("mode", *mode)
+		method, contentType, body = http.MethodPost, "application/json", strings.NewReader(string(mustJSON(doc)))
+	}
+	resp, err := c.client.do(method, "/graph?"+q.Encode(), contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(c.stdout, resp.Body)
+	return err
+}
+
+// ---------------- COSTS ----------------
+
+func (c *cli) costs(args []string) error {
+	fs := c.flags("costs")
+	by := fs.String("by", "namespace", "roll up by namespace, pipeline, task or day")
+	if err := parseArgs(fs, args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	q := url.Values{"by": {*by}}
+	if c.namespace != "" {
+		q.Set("namespace", c.namespace)
+	}
+	var totals []runner.CostTotal
+	if err := c.client.call(http.MethodGet, "/costs?"+q.Encode(), nil, &totals); err != nil {
+		return err
+	}
+	return render(c.stdout, c.output, totals, func() table {
+		t := table{header: []string{strings.ToUpper(*by), "PROMPT TOKENS", "COMPLETION TOKENS", "GAS (WEI)", "COST (USD)"}}
+		for _, ct := range totals {
+			t.rows = append(t.rows, []string{ct.Key, strconv.Itoa(ct.PromptTokens), strconv.Itoa(ct.CompletionTokens), ct.GasWei, fmt.Sprintf("%.4f", ct.CostUSD)})
+		}
+		return t
+	})
+}
+
+// ---------------- CONTEXTS ----------------
+
+func (c *cli) config(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "get-contexts":
+		names := c.cfg.names()
+		return render(c.stdout, c.output, c.cfg.Contexts, func() table {
+			t := table{header: []string{"CURRENT", "NAME", "SERVER", "NAMESPACE"}}
+			for _, name := range names {
+				cur := ""
+				if name == c.cfg.CurrentContext {
+					cur = "*"
+				}
+				t.rows = append(t.rows, []string{cur, name, c.cfg.Contexts[name].Server, cell(c.cfg.Contexts[name].Namespace)})
+			}
+			return t
+		})
+	case "use-context":
+		if len(args) != 2 {
+			return errUsage
+		}
+		if _, ok := c.cfg.Contexts[args[1]]; !ok {
+			return fmt.Errorf("context %q not found", args[1])
+		}
+		c.cfg.CurrentContext = args[1]
+		if err := c.cfg.save(c.cfgPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "switched to context %q\n", args[1])
+		return nil
+	case "set-context":
+		if len(args) < 2 {
+			return errUsage
+		}
+		name := args[1]
+		fs := c.flags("config set-context")
+		ctx := c.cfg.Contexts[name]
+		fs.StringVar(&ctx.Server, "server", ctx.Server, "runner API URL")
+		fs.StringVar(&ctx.Token, "token", ctx.Token, "bearer token, stored in the config file")
+		fs.StringVar(&ctx.TokenEnv, "token-env", ctx.TokenEnv, "env var holding the bearer token")
+		fs.StringVar(&ctx.Namespace, "namespace", ctx.Namespace, "namespace to submit to and list")
+		if err := parseArgs(fs, args[2:]); err != nil || fs.NArg() != 0 {
+			return errUsage
+		}
+		if ctx.Server == "" {
+			return fmt.Errorf("context %q: -server missing", name)
+		}
+		if c.cfg.Contexts == nil {
+			c.cfg.Contexts = map[string]ctlContext{}
+		}
+		c.cfg.Contexts[name] = ctx
+		if c.cfg.CurrentContext == "" {
+			c.cfg.CurrentContext = name
+		}
+		if err := c.cfg.save(c.cfgPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "context %q set\n", name)
+		return nil
+	default:
+		return errUsage
+	}
+}
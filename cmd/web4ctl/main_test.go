@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/runner"
+)
+
+// ctl runs web4ctl against srv and returns its output.
+func ctl(t *testing.T, srv *httptest.Server, stdin string, args ...string) string {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	args = append([]string{"-server", srv.URL}, args...)
+	if code := run(args, strings.NewReader(stdin), &out, &errOut); code != 0 {
+		t.Fatalf("web4ctl %s: exit %d: %s", strings.Join(args, " "), code, errOut.String())
+	}
+	return out.String()
+}
+
+func TestSubmitListAndRequeue(t *testing.T) {
+	t.Setenv("WEB4CTL_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	srv := httptest.NewServer(runner.Handler())
+	defer srv.Close()
+
+	out := ctl(t, srv, "tasks:\n  - type: teleport\n    payload: mars\n", "-o", "json", "submit")
+	var subs []runner.Submitted
+	if err := json.Unmarshal([]byte(out), &subs); err != nil || len(subs) != 1 {
+		t.Fatalf("submit = %q, %v", out, err)
+	}
+
+	var dead []runner.DeadLetter
+	for deadline := time.Now().Add(5 * time.Second); len(dead) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("task never reached the dead letters")
+		}
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal([]byte(ctl(t, srv, "", "dlq", "-o", "json")), &dead)
+	}
+	if dead[0].ID != subs[0].ID || !strings.Contains(dead[0].Error, "unknown task type") {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	if out := ctl(t, srv, "", "list", "-type", "teleport"); !strings.Contains(out, "teleport") || !strings.HasPrefix(out, "ID") {
+		t.Errorf("list = %q", out)
+	}
+	if out := ctl(t, srv, "", "-o", "yaml", "dlq", "requeue", strconv.Itoa(subs[0].ID)); !strings.Contains(out, "id: ") {
+		t.Errorf("requeue = %q", out)
+	}
+}
+
+func TestFlagsAfterArguments(t *testing.T) {
+	t.Setenv("WEB4CTL_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	srv := httptest.NewServer(runner.Handler())
+	defer srv.Close()
+
+	var subs []runner.Submitted
+	json.Unmarshal([]byte(ctl(t, srv, `{"type": "teleport"}`, "submit", "-o", "json")), &subs)
+	if len(subs) != 1 {
+		t.Fatalf("submit = %+v", subs)
+	}
+	id := strconv.Itoa(subs[0].ID)
+	if out := ctl(t, srv, "", "logs", id, "-follow"); !strings.Contains(out, "unknown task type") {
+		t.Errorf("logs -follow = %q", out)
+	}
+	var rec runner.TaskRecord
+	if err := json.Unmarshal([]byte(ctl(t, srv, "", "get", id, "-o", "json")), &rec); err != nil || rec.Status != "failed" {
+		t.Errorf("get -o json = %+v, %v", rec, err)
+	}
+}
+
+func TestContexts(t *testing.T) {
+	t.Setenv("WEB4CTL_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	t.Setenv("WEB4CTL_SERVER", "")
+	t.Setenv("PROD_TOKEN", "secret")
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"config", "set-context", "dev", "-server", "http://dev:8080"},
+		{"config", "set-context", "prod", "-server", "http://prod:8080", "-token-env", "PROD_TOKEN"},
+		{"config", "use-context", "prod"},
+	} {
+		if code := run(args, nil, &out, &out); code != 0 {
+			t.Fatalf("%v: exit %d: %s", args, code, out.String())
+		}
+	}
+	cfg, _, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, token, _ := cfg.endpoint("", "", ""); server != "http://prod:8080" || token != "secret" {
+		t.Errorf("current endpoint = %s, %s", server, token)
+	}
+	if server, token, _ := cfg.endpoint("dev", "", "t"); server != "http://dev:8080" || token != "t" {
+		t.Errorf("dev endpoint = %s, %s", server, token)
+	}
+	if _, _, err := cfg.endpoint("staging", "", ""); err == nil {
+		t.Error("unknown context resolved")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats.
+const (
+	outTable = "table"
+	outJSON  = "json"
+	outYAML  = "yaml"
+)
+
+// table is what a result looks like in table output.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// render writes v as JSON or YAML, or t as a table.
+func render(w io.Writer, format string, v interface{}, t func() table) error {
+	switch format {
+	case outJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outYAML:
+		// Through JSON, so the API's field names and omissions apply.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var doc interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outTable, "":
+		tab := t()
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(tab.header, "\t"))
+		for _, row := range tab.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q: want table, json or yaml", format)
+	}
+}
+
+// cell puts s on one short line.
+func cell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		return string(r[:57]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,450 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskRecord is the API view of a task, in the shape of api/web4 Task.
+type TaskRecord struct {
+	ID        int         `json:"id"`
+	Type      string      `json:"type"`
+	Namespace string      `json:"namespace,omitempty"`
+	Status    string      `json:"status"` // pending, running, success, failed
+	Attempts  int         `json:"attempts"`
+	Output    interface{} `json:"output,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Log       []string    `json:"log"`
+	Parent    int         `json:"parent,omitempty"` // the task whose success started this one
+	Started   time.Time   `json:"started,omitzero"`
+	Finished  time.Time   `json:"finished,omitzero"`
+	Spec      *TaskSpec   `json:"spec,omitempty"`  // what ran, for retries
+	Usage     []Usage     `json:"usage,omitempty"` // AI tokens and gas of every attempt
+	CostUSD   float64     `json:"cost_usd,omitempty"`
+}
+
+// StreamEvent is one entry of a task's live log. Kind is "log" for log lines
+// and "partial" for incremental output such as streamed AI text.
+type StreamEvent struct {
+	Kind string    `json:"kind"`
+	Data string    `json:"data"`
+	Time time.Time `json:"time"`
+}
+
+type liveTask struct {
+	record  TaskRecord
+	events  []StreamEvent
+	dropped int                     // events trimmed from the front of events
+	changed chan struct{}           // closed and replaced on every update
+	cancel  context.CancelCauseFunc // set while the task runs
+}
+
+// maxStreamEvents is how many of a task's latest stream events are kept for
+// subscribers that join late; the record keeps the whole log and output.
+const maxStreamEvents = 1000
+
+func (t *liveTask) addEvent(ev StreamEvent) {
+	t.events = append(t.events, ev)
+	// Trimming in batches keeps appends cheap.
+	if len(t.events) >= 2*maxStreamEvents {
+		drop := len(t.events) - maxStreamEvents
+		t.events = slices.Clone(t.events[drop:])
+		t.dropped += drop
+	}
+}
+
+// TaskRegistry tracks every task of the run for the HTTP API.
+type TaskRegistry struct {
+	mu    sync.Mutex
+	tasks map[int]*liveTask
+}
+
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{tasks: map[int]*liveTask{}}
+}
+
+func (r *TaskRegistry) Add(id int, taskType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks[id] = &liveTask{
+		record:  TaskRecord{ID: id, Type: taskType, Status: "pending", Log: []string{}},
+		changed: make(chan struct{}),
+	}
+}
+
+// AddNext adds a task under the next free ID, after every ID in use.
+func (r *TaskRegistry) AddNext(taskType string) int {
+	r.mu.Lock()
+	id := 0
+	for used := range r.tasks {
+		id = max(id, used+1)
+	}
+	r.tasks[id] = &liveTask{
+		record:  TaskRecord{ID: id, Type: taskType, Status: "pending", Log: []string{}},
+		changed: make(chan struct{}),
+	}
+	r.mu.Unlock()
+	return id
+}
+
+// track records the spec of a task that starts running and how to cancel
+// it, until the returned function is called.
+func (r *TaskRegistry) track(id int, spec TaskSpec, cancel context.CancelCauseFunc) (untrack func()) {
+	r.update(id, func(t *liveTask) {
+		t.record.Spec = &spec
+		t.record.Namespace = namespaceOf(spec)
+		t.cancel = cancel
+	})
+	return func() {
+		r.update(id, func(t *liveTask) { t.cancel = nil })
+	}
+}
+
+// Cancel stops a running task. It fails with ErrTaskCanceled.
+func (r *TaskRegistry) Cancel(id int) error {
+	r.mu.Lock()
+	t, ok := r.tasks[id]
+	var cancel context.CancelCauseFunc
+	var status string
+	if ok {
+		cancel, status = t.cancel, t.record.Status
+	}
+	r.mu.Unlock()
+	switch {
+	case !ok:
+		return fmt.Errorf("%w %d", ErrUnknownTask, id)
+	case cancel == nil:
+		return fmt.Errorf("%w: task %d is %s", ErrTaskState, id, status)
+	}
+	cancel(ErrTaskCanceled)
+	return nil
+}
+
+// SetNamespace records the namespace of a task that has not started yet.
+func (r *TaskRegistry) SetNamespace(id int, ns string) {
+	r.update(id, func(t *liveTask) { t.record.Namespace = namespaceOr(ns) })
+}
+
+// AddUsage records what an attempt of the task consumed.
+func (r *TaskRegistry) AddUsage(id int, u Usage) {
+	r.update(id, func(t *liveTask) {
+		t.record.Usage = append(t.record.Usage, u)
+		t.record.CostUSD += u.CostUSD
+	})
+}
+
+// SetParent records which task of a tree started id.
+func (r *TaskRegistry) SetParent(id, parent int) {
+	r.update(id, func(t *liveTask) { t.record.Parent = parent })
+}
+
+// update applies fn to a task's record and wakes its stream readers. Unknown
+// IDs are ignored so tasks run outside the registry still work.
+func (r *TaskRegistry) update(id int, fn func(t *liveTask)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return
+	}
+	fn(t)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Log appends a line to the task's log, with secret values redacted.
+func (r *TaskRegistry) Log(id int, msg string) {
+	msg = redactions.Redact(msg)
+	r.update(id, func(t *liveTask) {
+		t.record.Log = append(t.record.Log, msg)
+		t.addEvent(StreamEvent{Kind: "log", Data: msg, Time: time.Now()})
+	})
+}
+
+// Partial publishes a chunk of output while the task is still running.
+func (r *TaskRegistry) Partial(id int, chunk string) {
+	chunk = redactions.Redact(chunk)
+	r.update(id, func(t *liveTask) {
+		t.addEvent(StreamEvent{Kind: "partial", Data: chunk, Time: time.Now()})
+	})
+}
+
+func (r *TaskRegistry) Start(id, attempt int) {
+	r.update(id, func(t *liveTask) {
+		t.record.Status = "running"
+		t.record.Attempts = attempt + 1
+		if attempt == 0 {
+			t.record.Started = time.Now()
+		}
+	})
+}
+
+// Finish records the outcome of an attempt. Output is kept even when err is
+// set, so partial results of a failed or canceled attempt stay visible.
+// Secret values are redacted from both.
+func (r *TaskRegistry) Finish(id int, out interface{}, err error, final bool) {
+	out, err = redactions.RedactValue(out), redactions.RedactError(err)
+	r.update(id, func(t *liveTask) {
+		if out != nil {
+			t.record.Output = out
+		}
+		t.record.Error = ""
+		if err != nil {
+			t.record.Error = err.Error()
+		}
+		switch {
+		case err == nil:
+			t.record.Status = "success"
+		case final:
+			t.record.Status = "failed"
+		}
+		if err == nil || final {
+			t.record.Finished = time.Now()
+		}
+	})
+}
+
+func (r *TaskRegistry) Get(id int) (TaskRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return TaskRecord{}, false
+	}
+	rec := t.record
+	rec.Log = append([]string(nil), t.record.Log...)
+	rec.Usage = slices.Clone(t.record.Usage)
+	return rec, true
+}
+
+func (r *TaskRegistry) List() []TaskRecord {
+	r.mu.Lock()
+	ids := make([]int, 0, len(r.tasks))
+	for id := range r.tasks {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+	sort.Ints(ids)
+	out := make([]TaskRecord, 0, len(ids))
+	for _, id := range ids {
+		if rec, ok := r.Get(id); ok {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// events returns the task's stream events from index from on, or from the
+// oldest one kept, the index after them, whether the task has finished, and
+// a channel closed on the next update.
+func (r *TaskRegistry) events(id, from int) ([]StreamEvent, int, bool, <-chan struct{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return nil, 0, false, nil, false
+	}
+	var evs []StreamEvent
+	if i := max(from-t.dropped, 0); i < len(t.events) {
+		evs = append(evs, t.events[i:]...)
+	}
+	done := t.record.Status == "success" || t.record.Status == "failed"
+	return evs, t.dropped + len(t.events), done, t.changed, true
+}
+
+// ---------------- HTTP API ----------------
+
+func newAPIHandler(run *Runner, store *ArtifactStore) http.Handler {
+	r, n := run.store.(*TaskRegistry), run.notifier
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		status, taskType, ns := q.Get("status"), q.Get("type"), q.Get("namespace")
+		recs := []TaskRecord{}
+		for _, rec := range r.List() {
+			if (status == "" || rec.Status == status) && (taskType == "" || rec.Type == taskType) &&
+				(ns == "" || namespaceOr(rec.Namespace) == ns) && visible(req, rec.Namespace) {
+				recs = append(recs, rec)
+			}
+		}
+		writeJSON(w, http.StatusOK, recs)
+	})
+	mux.HandleFunc("GET /tasks/{id}", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		rec, ok := r.Get(id)
+		if !ok || !visible(req, rec.Namespace) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	})
+	mux.HandleFunc("GET /tasks/{id}/stream", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		if rec, ok := r.Get(id); ok && !visible(req, rec.Namespace) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		streamTask(w, req, r, id)
+	})
+	mux.HandleFunc("GET /tasks/{id}/artifacts", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		if rec, ok := r.Get(id); !ok || !visible(req, rec.Namespace) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		list, err := store.ForTask(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, nonNil(list))
+	})
+
+	controlRoutes(mux, r, run)
+	mux.HandleFunc("GET /graph", serveGraph(r))
+	mux.HandleFunc("POST /graph", serveGraph(r))
+
+	mux.HandleFunc("GET /artifacts", func(w http.ResponseWriter, req *http.Request) {
+		all, err := store.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list := []*Artifact{}
+		for _, a := range all {
+			if artifactVisible(req, a) {
+				list = append(list, a)
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("GET /artifacts/{digest}", func(w http.ResponseWriter, req *http.Request) {
+		serveArtifact(w, req, store, req.PathValue("digest"))
+	})
+	mux.HandleFunc("GET /artifacts/{digest}/meta", func(w http.ResponseWriter, req *http.Request) {
+		a, err := store.Get(req.PathValue("digest"))
+		if err == nil && !artifactVisible(req, a) {
+			err = ErrArtifactNotFound
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+	})
+
+	mux.HandleFunc("GET /namespaces", serveNamespaces(run.env.quotas))
+	mux.HandleFunc("GET /costs", serveCosts(run.env.costs))
+
+	mux.HandleFunc("GET /webhooks/deliveries", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		writeJSON(w, http.StatusOK, n.Deliveries(q.Get("event_id"), q.Get("status"), func(ns string) bool {
+			if ns == "" {
+				return unrestricted(req)
+			}
+			return visible(req, ns)
+		}))
+	})
+	return mux
+}
+
+// serveArtifact sends an artifact's content. Digests never change content, so
+// the response is cacheable and supports range requests.
+func serveArtifact(w http.ResponseWriter, req *http.Request, store *ArtifactStore, digest string) {
+	f, a, err := store.Open(strings.TrimPrefix(digest, "sha256:"))
+	if err == nil && !artifactVisible(req, a) {
+		f.Close()
+		err = ErrArtifactNotFound
+	}
+	if errors.Is(err, ErrArtifactNotFound) {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if a.ContentType != "" {
+		w.Header().Set("Content-Type", a.ContentType)
+	}
+	w.Header().Set("ETag", `"`+a.Digest+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, req, "", a.Created, f)
+}
+
+// nonNil keeps empty lists as [] rather than null in responses.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// streamTask sends the task's live log as server-sent events, replaying the
+// latest maxStreamEvents so far, and ends with a "done" event carrying the
+// final record.
+func streamTask(w http.ResponseWriter, req *http.Request, r *TaskRegistry, id int) {
+	if _, _, _, _, ok := r.events(id, 0); !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	next := 0
+	for {
+		evs, after, done, changed, _ := r.events(id, next)
+		for _, ev := range evs {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, data)
+		}
+		next = after
+		if done {
+			rec, _ := r.Get(id)
+			data, _ := json.Marshal(rec)
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-req.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTaskStreamEndpoint(t *testing.T) {
+	reg := NewTaskRegistry()
+	reg.Add(1, "ai")
+	reg.Start(1, 0)
+	reg.Log(1, "Starting task type=ai")
+	reg.Partial(1, "Hel")
+
+	srv := httptest.NewServer(newAPIHandler(newAPIRunner(reg, defaultEnv, 0, 0), NewArtifactStore(t.TempDir())))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tasks/1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// Updates made while the client is connected arrive on the open stream.
+	go func() {
+		reg.Partial(1, "lo")
+		reg.Finish(1, &AIOutput{Text: "Hello"}, nil, false)
+	}()
+
+	var events []string
+	var last string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if ev, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, ev)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			last = data
+		}
+	}
+	if got := strings.Join(events, ","); got != "log,partial,partial,done" {
+		t.Fatalf("events = %s", got)
+	}
+	var rec TaskRecord
+	if err := json.Unmarshal([]byte(last), &rec); err != nil || rec.Status != "success" {
+		t.Fatalf("done event = %s (%v)", last, err)
+	}
+}
+
+func TestTaskStreamEventsBounded(t *testing.T) {
+	reg := NewTaskRegistry()
+	reg.Add(1, "ai")
+	reg.Start(1, 0)
+	n := 5 * maxStreamEvents
+	for i := range n {
+		reg.Partial(1, strconv.Itoa(i))
+	}
+	reg.Finish(1, &AIOutput{Text: "all of it"}, nil, true)
+
+	reg.mu.Lock()
+	kept := len(reg.tasks[1].events)
+	reg.mu.Unlock()
+	if kept >= 2*maxStreamEvents {
+		t.Fatalf("kept %d events", kept)
+	}
+	// A late subscriber gets the tail and the final record.
+	evs, next, done, _, _ := reg.events(1, 0)
+	if len(evs) != kept || evs[len(evs)-1].Data != strconv.Itoa(n-1) || next != n || !done {
+		t.Fatalf("events = %d ending %+v, next %d, done %v", len(evs), evs[len(evs)-1], next, done)
+	}
+	if evs, _, _, _, _ := reg.events(1, n-1); len(evs) != 1 {
+		t.Errorf("events from %d = %+v", n-1, evs)
+	}
+	if rec, _ := reg.Get(1); rec.Output.(*AIOutput).Text != "all of it" {
+		t.Errorf("record = %+v", rec)
+	}
+}
+
+func TestTaskRecordKeepsPartialOutput(t *testing.T) {
+	reg := NewTaskRegistry()
+	reg.Add(2, "ai")
+	reg.Start(2, 0)
+	reg.Finish(2, &AIOutput{Text: "half an ans", FinishReason: "canceled"}, errors.New("context canceled"), true)
+
+	srv := httptest.NewServer(newAPIHandler(newAPIRunner(reg, defaultEnv, 0, 0), NewArtifactStore(t.TempDir())))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tasks/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rec struct {
+		Status string   `json:"status"`
+		Output AIOutput `json:"output"`
+	}
+	json.NewDecoder(resp.Body).Decode(&rec)
+	if rec.Status != "failed" || rec.Output.Text != "half an ans" {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	for _, path := range []string{"/tasks/9", "/tasks/9/stream", "/tasks/x"} {
+		resp, _ := http.Get(srv.URL + path)
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("GET %s = %d, want an error status", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Artifact is the metadata of a stored blob. Blobs are keyed by the SHA-256
+// of their content, so the same output produced twice is stored once and
+// lists every task that produced it.
+type Artifact struct {
+	Digest      string          `json:"digest"` // hex SHA-256
+	Size        int64           `json:"size"`
+	ContentType string          `json:"content_type,omitempty"`
+	Name        string          `json:"name,omitempty"` // e.g. the source URL
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"` // last time a task produced it
+	Producers   []ArtifactOwner `json:"producers,omitempty"`
+}
+
+// ArtifactOwner records a task that produced an artifact.
+type ArtifactOwner struct {
+	TaskID    int       `json:"task_id"`
+	TaskType  string    `json:"task_type"`
+	Namespace string    `json:"namespace,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// InNamespace reports whether a task of namespace ns produced a. Artifacts
+// are shared by content, so one produced in two namespaces is in both.
+func (a *Artifact) InNamespace(ns string) bool {
+	for _, p := range a.Producers {
+		if namespaceOr(p.Namespace) == namespaceOr(ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionPolicy bounds what the artifact store keeps. Zero values mean
+// no limit.
+type RetentionPolicy struct {
+	MaxAge   Duration `json:"max_age,omitempty"`   // drop artifacts not produced again for this long
+	MaxBytes int64    `json:"max_bytes,omitempty"` // then drop the least recently produced until under this total
+}
+
+// ErrArtifactNotFound is returned for digests the store doesn't have.
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactStore is a content-addressed store on local disk:
+//
+//	<root>/blobs/<first 2 hex>/<digest>
+//	<root>/meta/<digest>.json
+//	<root>/tmp/   staging and partial downloads
+type ArtifactStore struct {
+	Root string
+	mu   sync.Mutex
+}
+
+func NewArtifactStore(root string) *ArtifactStore {
+	return &ArtifactStore{Root: root}
+}
+
+// TempDir returns the staging directory, creating it if needed. Files staged
+// there can be moved into the store with Adopt.
+func (s *ArtifactStore) TempDir() (string, error) {
+	dir := filepath.Join(s.Root, "tmp")
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+// Path returns where the blob with the given digest is stored.
+func (s *ArtifactStore) Path(digest string) string {
+	return filepath.Join(s.Root, "blobs", digest[:2], digest)
+}
+
+// Put stores everything read from r.
+func (s *ArtifactStore) Put(r io.Reader, name, contentType string) (*Artifact, error) {
+	dir, err := s.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "put-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Adopt(f.Name(), hex.EncodeToString(h.Sum(nil)), size, name, contentType)
+}
+
+// Adopt moves a complete file whose digest is already known into the store.
+// If the content is already stored the file is removed instead.
+func (s *ArtifactStore) Adopt(path, digest string, size int64, name, contentType string) (*Artifact, error) {
+	if !validDigest(digest) {
+		return nil, fmt.Errorf("invalid artifact digest %q", digest)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := s.Path(digest)
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(blob); err == nil {
+		os.Remove(path)
+	} else if err := os.Rename(path, blob); err != nil {
+		return nil, err
+	}
+
+	a, err := s.readMeta(digest)
+	if errors.Is(err, ErrArtifactNotFound) {
+		now := time.Now().UTC()
+		a, err = &Artifact{Digest: digest, Size: size, Created: now, Updated: now}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.ContentType == "" {
+		a.ContentType = contentType
+	}
+	if a.Name == "" {
+		a.Name = name
+	}
+	return a, s.writeMeta(a)
+}
+
+// Link records that a task of namespace ns produced the artifact.
+func (s *ArtifactStore) Link(digest string, taskID int, taskType, ns string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.readMeta(digest)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	a.Updated = now
+	a.Producers = append(a.Producers, ArtifactOwner{TaskID: taskID, TaskType: taskType, Namespace: namespaceOr(ns), Time: now})
+	return s.writeMeta(a)
+}
+
+// Get returns an artifact's metadata.
+func (s *ArtifactStore) Get(digest string) (*Artifact, error) {
+	if !validDigest(digest) {
+		return nil, ErrArtifactNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readMeta(digest)
+}
+
+// Open returns the artifact's content.
+func (s *ArtifactStore) Open(digest string) (*os.File, *Artifact, error) {
+	a, err := s.Get(digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.Path(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		err = ErrArtifactNotFound
+	}
+	return f, a, err
+}
+
+// List returns every artifact, most recently produced first.
+func (s *ArtifactStore) List() ([]*Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+// ForTask returns the artifacts produced by a task.
+func (s *ArtifactStore) ForTask(taskID int) ([]*Artifact, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var out []*Artifact
+	for _, a := range all {
+		for _, p := range a.Producers {
+			if p.TaskID == taskID {
+				out = append(out, a)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Resolve turns an artifact reference "sha256:<digest>" into the blob path.
+// Other strings are returned unchanged.
+func (s *ArtifactStore) Resolve(ref string) (string, error) {
+	digest, ok := strings.CutPrefix(ref, "sha256:")
+	if !ok {
+		return ref, nil
+	}
+	if _, err := s.Get(digest); err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	return s.Path(digest), nil
+}
+
+// ResolveIn is Resolve for a task of namespace ns, which may only use the
+// artifacts of its own namespace.
+func (s *ArtifactStore) ResolveIn(ref, ns string) (string, error) {
+	digest, ok := strings.CutPrefix(ref, "sha256:")
+	if !ok {
+		return ref, nil
+	}
+	a, err := s.Get(digest)
+	if err == nil && !a.InNamespace(ns) {
+		err = ErrArtifactNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	return s.Path(digest), nil
+}
+
+// GC applies the retention policy and removes staging files older than
+// MaxAge. It returns the digests it removed.
+func (s *ArtifactStore) GC(p RetentionPolicy, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var total int64
+	for _, a := range all {
+		total += a.Size
+	}
+	// Oldest first, so the size limit evicts least recently produced.
+	for i := len(all) - 1; i >= 0; i-- {
+		a := all[i]
+		expired := p.MaxAge > 0 && now.Sub(a.Updated) > time.Duration(p.MaxAge)
+		overSize := p.MaxBytes > 0 && total > p.MaxBytes
+		if !expired && !overSize {
+			continue
+		}
+		if err := os.Remove(s.Path(a.Digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		os.Remove(s.metaPath(a.Digest))
+		total -= a.Size
+		removed = append(removed, a.Digest)
+	}
+
+	if p.MaxAge > 0 {
+		staged, _ := os.ReadDir(filepath.Join(s.Root, "tmp"))
+		for _, e := range staged {
+			if info, err := e.Info(); err == nil && now.Sub(info.ModTime()) > time.Duration(p.MaxAge) {
+				os.Remove(filepath.Join(s.Root, "tmp", e.Name()))
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (s *ArtifactStore) list() ([]*Artifact, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Root, "meta"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []*Artifact
+	for _, e := range entries {
+		digest, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validDigest(digest) {
+			continue
+		}
+		a, err := s.readMeta(digest)
+		if err != nil {
+			continue
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Updated.After(out[j].Updated) })
+	return out, nil
+}
+
+func (s *ArtifactStore) metaPath(digest string) string {
+	return filepath.Join(s.Root, "meta", digest+".json")
+}
+
+func (s *ArtifactStore) readMeta(digest string) (*Artifact, error) {
+	data, err := os.ReadFile(s.metaPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a Artifact
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("artifact %s metadata: %w", digest, err)
+	}
+	return &a, nil
+}
+
+// writeMeta replaces the metadata file atomically.
+func (s *ArtifactStore) writeMeta(a *Artifact) error {
+	path := s.metaPath(a.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func validDigest(d string) bool {
+	if len(d) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(d)
+	return err == nil && strings.ToLower(d) == d
+}
+
+// storeOutput files a successful task output: downloads are already in the
+// store and only get linked, AI answers are stored as text or JSON.
+func (s *ArtifactStore) storeOutput(taskID int, spec TaskSpec, out interface{}) error {
+	ns := namespaceOf(spec)
+	switch o := out.(type) {
+	case *DownloadOutput:
+		if o.Artifact == "" {
+			return nil
+		}
+		return s.Link(o.SHA256, taskID, spec.Type, ns)
+	case *AIOutput:
+		contentType := "text/plain; charset=utf-8"
+		if o.JSON != nil {
+			contentType = "application/json"
+		}
+		a, err := s.Put(strings.NewReader(o.Text), fmt.Sprintf("task %d answer", taskID), contentType)
+		if err != nil {
+			return err
+		}
+		o.Artifact = "sha256:" + a.Digest
+		return s.Link(a.Digest, taskID, spec.Type, ns)
+	}
+	return nil
+}
+
+// gcArtifacts applies the retention policy to store, logging what it
+// removed.
+func gcArtifacts(store *ArtifactStore, p RetentionPolicy) {
+	if p.MaxAge == 0 && p.MaxBytes == 0 {
+		return
+	}
+	removed, err := store.GC(p, time.Now())
+	if err != nil {
+		log.Printf("Artifact GC failed: %v", err)
+	}
+	if len(removed) > 0 {
+		log.Printf("Artifact GC removed %d artifacts", len(removed))
+	}
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArtifactStore(t *testing.T) {
+	s := NewArtifactStore(t.TempDir())
+	a, err := s.Put(strings.NewReader("hello web4"), "greeting", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.Put(strings.NewReader("hello web4"), "other", "")
+	if err != nil || again.Digest != a.Digest || again.Name != "greeting" {
+		t.Fatalf("second put = %+v, %v", again, err)
+	}
+	s.Link(a.Digest, 1, "ai", "")
+	s.Link(a.Digest, 2, "ai", "")
+	b, _ := s.Put(strings.NewReader("other bytes"), "", "")
+	s.Link(b.Digest, 2, "download", "")
+
+	if list, _ := s.ForTask(2); len(list) != 2 {
+		t.Fatalf("task 2 artifacts = %d", len(list))
+	}
+	if list, _ := s.ForTask(1); len(list) != 1 || len(list[0].Producers) != 2 {
+		t.Fatalf("task 1 artifacts = %+v", list)
+	}
+	if p, err := s.Resolve("sha256:" + a.Digest); err != nil || p != s.Path(a.Digest) {
+		t.Fatalf("Resolve = %s, %v", p, err)
+	}
+	if p, _ := s.Resolve("/tmp/plain.txt"); p != "/tmp/plain.txt" {
+		t.Fatalf("plain path resolved to %s", p)
+	}
+	if _, err := s.Resolve("sha256:" + strings.Repeat("ab", 32)); err == nil {
+		t.Fatal("expected error for an unknown digest")
+	}
+}
+
+func TestArtifactGC(t *testing.T) {
+	s := NewArtifactStore(t.TempDir())
+	var digests []string
+	for _, body := range []string{"first artifact", "second artifact", "third artifact"} {
+		a, _ := s.Put(strings.NewReader(body), "", "")
+		s.Link(a.Digest, 0, "ai", "")
+		digests = append(digests, a.Digest)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	removed, err := s.GC(RetentionPolicy{MaxBytes: 30}, time.Now())
+	if err != nil || len(removed) != 1 || removed[0] != digests[0] {
+		t.Fatalf("size GC removed %v, %v", removed, err)
+	}
+	removed, _ = s.GC(RetentionPolicy{MaxAge: Duration(time.Hour)}, time.Now().Add(2*time.Hour))
+	if len(removed) != 2 {
+		t.Fatalf("age GC removed %v", removed)
+	}
+	if list, _ := s.List(); len(list) != 0 {
+		t.Fatalf("left %d artifacts", len(list))
+	}
+}
+
+func TestDownloadIntoArtifactStore(t *testing.T) {
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "a,b\n1,2\n")
+	}))
+	defer src.Close()
+	env := mustEnv(Config{ArtifactRoot: t.TempDir()})
+
+	reg := NewTaskRegistry()
+	reg.Add(4, "download")
+	task := Task{ID: 4, Spec: TaskSpec{Type: "download", Payload: src.URL + "/report"}}
+	out, err := New(Options{Store: reg, Env: env}).execute(context.Background(), task, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := out.(*DownloadOutput)
+	if !strings.HasPrefix(dl.Artifact, "sha256:") || dl.Path != env.artifacts.Path(dl.SHA256) {
+		t.Fatalf("output = %+v", dl)
+	}
+
+	api := httptest.NewServer(newAPIHandler(newAPIRunner(reg, env, 0, 0), env.artifacts))
+	defer api.Close()
+	resp, err := http.Get(api.URL + "/artifacts/" + dl.Artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "a,b\n1,2\n" || resp.Header.Get("Content-Type") != "text/csv" {
+		t.Fatalf("artifact = %q (%s)", body, resp.Header.Get("Content-Type"))
+	}
+
+	resp, _ = http.Get(api.URL + "/tasks/4/artifacts")
+	var list []Artifact
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 1 || list[0].Name != src.URL+"/report" || list[0].Producers[0].TaskID != 4 {
+		t.Fatalf("task artifacts = %+v", list)
+	}
+	if resp, _ := http.Get(api.URL + "/artifacts/" + strings.Repeat("0", 64)); resp.StatusCode != 404 {
+		t.Fatalf("unknown artifact status = %d", resp.StatusCode)
+	}
+}
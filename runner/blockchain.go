@@ -0,0 +1,484 @@
+package runner
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// BlockchainOptions controls how a blockchain task sends its transaction and
+// when it is considered done.
+type BlockchainOptions struct {
+	RPCURL          string   `json:"rpc_url,omitempty"`          // default ETH_RPC_URL; may hold ${secret:name} references
+	Signer          string   `json:"signer,omitempty"`           // alias from Config.Signers, default "default"
+	Confirmations   int      `json:"confirmations"`              // blocks required on top of inclusion, default 1
+	Data            string   `json:"data,omitempty"`             // raw hex calldata, overrides the method in the payload
+	Value           string   `json:"value,omitempty"`            // wei, decimal
+	GasLimit        uint64   `json:"gas_limit,omitempty"`        // estimated when zero
+	ABI             string   `json:"abi,omitempty"`              // contract ABI JSON, used to decode events
+	PollInterval    Duration `json:"poll_interval,omitempty"`    // default 2s
+	ReplaceAfter    Duration `json:"replace_after,omitempty"`    // resend with higher fees when still pending, default 2m
+	FeeBumpPercent  int      `json:"fee_bump_percent,omitempty"` // default 12, nodes require at least 10
+	MaxReplacements int      `json:"max_replacements,omitempty"` // default 3
+	ConfirmTimeout  Duration `json:"confirm_timeout,omitempty"`  // give up waiting this long after the first send, default 30m
+}
+
+func (o *BlockchainOptions) withDefaults() BlockchainOptions {
+	var opts BlockchainOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Confirmations <= 0 {
+		opts.Confirmations = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = Duration(2 * time.Second)
+	}
+	if opts.ReplaceAfter <= 0 {
+		opts.ReplaceAfter = Duration(2 * time.Minute)
+	}
+	if opts.FeeBumpPercent < 10 {
+		opts.FeeBumpPercent = 12
+	}
+	if opts.MaxReplacements <= 0 {
+		opts.MaxReplacements = 3
+	}
+	if opts.ConfirmTimeout <= 0 {
+		opts.ConfirmTimeout = Duration(30 * time.Minute)
+	}
+	return opts
+}
+
+// ChainBackend is the subset of ethclient.Client the blockchain executor
+// needs. Tests substitute a fake.
+type ChainBackend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// TxReceipt is the output of a confirmed blockchain task.
+type TxReceipt struct {
+	TxHash            string         `json:"tx_hash"`
+	BlockNumber       uint64         `json:"block_number"`
+	BlockHash         string         `json:"block_hash"`
+	Status            uint64         `json:"status"`
+	GasUsed           uint64         `json:"gas_used"`
+	EffectiveGasPrice string         `json:"effective_gas_price"`
+	Confirmations     int            `json:"confirmations"`
+	Replacements      int            `json:"replacements"`
+	Events            []DecodedEvent `json:"events,omitempty"`
+}
+
+// DecodedEvent is a log emitted by the transaction. Name and Args are only
+// set when the task's ABI knows the event.
+type DecodedEvent struct {
+	Address string                 `json:"address"`
+	Name    string                 `json:"name,omitempty"`
+	Args    map[string]interface{} `json:"args,omitempty"`
+	Topics  []string               `json:"topics"`
+	Data    string                 `json:"data"`
+}
+
+// ErrTxSent marks errors of a blockchain task whose transaction was already
+// sent. They fail the task without retries, since a retry would send the
+// call again with a new nonce.
+var ErrTxSent = errors.New("transaction already sent")
+
+// RevertError is returned when the transaction was mined but reverted.
+type RevertError struct {
+	TxHash string
+	Reason string
+}
+
+func (e *RevertError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("transaction %s reverted", e.TxHash)
+	}
+	return fmt.Sprintf("transaction %s reverted: %s", e.TxHash, e.Reason)
+}
+
+func (e *RevertError) Unwrap() error { return ErrTxSent }
+
+// Blockchain task: payload is "0xContract:method" (either order), where
+// method is a name like mintNFT or a full signature like mint(uint256).
+func taskBlockchain(ctx context.Context, spec TaskSpec) (*TxReceipt, error) {
+	opts := spec.Blockchain.withDefaults()
+	rpcURL := opts.RPCURL
+	if rpcURL == "" {
+		rpcURL = os.Getenv("ETH_RPC_URL")
+	}
+	if rpcURL == "" {
+		return nil, fmt.Errorf("ETH_RPC_URL missing")
+	}
+	env := envOf(ctx)
+	signer, err := env.signer(opts.Signer, spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.quotas.CheckGas(spec.Namespace); err != nil {
+		return nil, err
+	}
+	rpcClient, err := rpc.DialOptions(ctx, rpcURL, rpc.WithHTTPClient(env.client(spec.HTTP)))
+	if err != nil {
+		return nil, err
+	}
+	client := ethclient.NewClient(rpcClient)
+	defer client.Close()
+
+	to, data, err := parseContractCall(spec.Payload, spec.Blockchain)
+	if err != nil {
+		return nil, err
+	}
+	out, err := sendAndConfirm(ctx, client, signer, to, data, opts)
+	if out != nil {
+		env.quotas.AddGas(spec.Namespace, out)
+		ReportUsage(ctx, Usage{Kind: UsageGas, GasUsed: out.GasUsed, GasPriceWei: out.EffectiveGasPrice})
+	}
+	return out, err
+}
+
+// parseContractCall turns a payload into a target address and calldata.
+func parseContractCall(payload string, opts *BlockchainOptions) (common.Address, []byte, error) {
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 {
+		return common.Address{}, nil, fmt.Errorf("blockchain payload %q: want contract:method", payload)
+	}
+	addr, method := parts[0], parts[1]
+	if !strings.HasPrefix(addr, "0x") {
+		addr, method = method, addr
+	}
+	if !common.IsHexAddress(addr) {
+		return common.Address{}, nil, fmt.Errorf("blockchain payload %q: invalid contract address", payload)
+	}
+	if opts != nil && opts.Data != "" {
+		data, err := hexutil.Decode(opts.Data)
+		if err != nil {
+			return common.Address{}, nil, fmt.Errorf("invalid calldata: %v", err)
+		}
+		return common.HexToAddress(addr), data, nil
+	}
+	if !strings.Contains(method, "(") {
+		method += "()"
+	}
+	return common.HexToAddress(addr), crypto.Keccak256([]byte(method))[:4], nil
+}
+
+// logChain logs a line of a blockchain task. RPC errors quote the node's
+// URL, which may carry a resolved secret, so the line is redacted.
+func logChain(format string, args ...interface{}) {
+	log.Print(redactions.Redact(fmt.Sprintf(format, args...)))
+}
+
+// maxNonceRetries bounds how often a send is retried with a fresh nonce after
+// the node reports the previous one as taken.
+const maxNonceRetries = 3
+
+// sendAndConfirm signs and sends a dynamic fee transaction, or a legacy one
+// on chains without a base fee, replaces it with a fee bump while it stays
+// pending, and returns once the mined receipt has opts.Confirmations blocks
+// on top of it on the canonical chain. Once a transaction may be out, RPC
+// errors only delay the next poll; the errors it still returns, including a
+// send the node did not clearly reject, wrap ErrTxSent.
+func sendAndConfirm(ctx context.Context, backend ChainBackend, signer Signer, to common.Address, data []byte, opts BlockchainOptions) (*TxReceipt, error) {
+	// Everything that can be wrong with the options fails before sending.
+	parsed, err := parseABI(opts.ABI)
+	if err != nil {
+		return nil, err
+	}
+	from := signer.Address()
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value := new(big.Int)
+	if opts.Value != "" {
+		if _, ok := value.SetString(opts.Value, 10); !ok {
+			return nil, fmt.Errorf("invalid value %q", opts.Value)
+		}
+	}
+	msg := ethereum.CallMsg{From: from, To: &to, Value: value, Data: data}
+	gas := opts.GasLimit
+	if gas == 0 {
+		if gas, err = backend.EstimateGas(ctx, msg); err != nil {
+			return nil, fmt.Errorf("estimate gas: %w", err)
+		}
+	}
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// tip is the gas price of a legacy transaction, feeCap is unused.
+	var tip, feeCap *big.Int
+	legacy := head.BaseFee == nil
+	if legacy {
+		if tip, err = backend.SuggestGasPrice(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		if tip, err = backend.SuggestGasTipCap(ctx); err != nil {
+			return nil, err
+		}
+		feeCap = new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	}
+
+	var nonce uint64
+	sign := func() (*types.Transaction, error) {
+		var inner types.TxData = &types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: tip,
+			GasFeeCap: feeCap,
+			Gas:       gas,
+			To:        &to,
+			Value:     value,
+			Data:      data,
+		}
+		if legacy {
+			inner = &types.LegacyTx{Nonce: nonce, GasPrice: tip, Gas: gas, To: &to, Value: value, Data: data}
+		}
+		return signer.SignTx(ctx, types.NewTx(inner), chainID)
+	}
+
+	var tx *types.Transaction
+	nonces := envOf(ctx).nonces
+	for attempt := 0; ; attempt++ {
+		if nonce, err = nonces.Next(ctx, backend, from); err != nil {
+			return nil, err
+		}
+		if tx, err = sign(); err != nil {
+			nonces.Release(from, nonce)
+			return nil, fmt.Errorf("sign transaction: %w", err)
+		}
+		err = backend.SendTransaction(ctx, tx)
+		if err == nil || isAlreadyKnown(err) {
+			break
+		}
+		if !rejected(err) {
+			// The node may have taken the transaction before the error, so
+			// running the task again could repeat the call.
+			nonces.Reset(from)
+			return nil, fmt.Errorf("%w: send transaction %s: %w", ErrTxSent, tx.Hash().Hex(), err)
+		}
+		if !nonces.Reconcile(from, nonce, err) || attempt == maxNonceRetries {
+			return nil, fmt.Errorf("send transaction: %w", err)
+		}
+		logChain("[Blockchain] Nonce %d for %s already used (%v), resyncing", nonce, from.Hex(), err)
+	}
+	sent := []common.Hash{tx.Hash()}
+	lastSent := time.Now()
+	logChain("[Blockchain] Sent tx %s nonce=%d to %s", tx.Hash().Hex(), nonce, to.Hex())
+
+	ticker := time.NewTicker(time.Duration(opts.PollInterval))
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Duration(opts.ConfirmTimeout))
+	defer timeout.Stop()
+	for {
+		receipt, err := findReceipt(ctx, backend, sent)
+		confs := 0
+		if err == nil && receipt != nil {
+			confs, err = confirmations(ctx, backend, receipt)
+		}
+		switch {
+		case err != nil:
+			logChain("[Blockchain] Polling for %s: %v", sent[len(sent)-1].Hex(), err)
+		case receipt != nil:
+			if confs >= opts.Confirmations {
+				return finishReceipt(ctx, backend, msg, receipt, confs, len(sent)-1, parsed)
+			}
+		case time.Since(lastSent) >= time.Duration(opts.ReplaceAfter) && len(sent) <= opts.MaxReplacements:
+			tip = bumpFee(tip, opts.FeeBumpPercent)
+			if !legacy {
+				feeCap = bumpFee(feeCap, opts.FeeBumpPercent)
+			}
+			tx, err := sign()
+			if err == nil {
+				err = backend.SendTransaction(ctx, tx)
+			}
+			switch {
+			case err != nil && isNonceTooLow(err):
+				// One of the sent transactions was mined in the meantime;
+				// the next poll picks up its receipt.
+			case err != nil && !isAlreadyKnown(err):
+				logChain("[Blockchain] Fee bump for nonce %d failed: %v", nonce, err)
+			default:
+				sent = append(sent, tx.Hash())
+				logChain("[Blockchain] Replaced stuck tx with %s tip=%s feeCap=%v", tx.Hash().Hex(), tip, feeCap)
+			}
+			lastSent = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: blockchain task canceled waiting for %s: %w", ErrTxSent, sent[len(sent)-1].Hex(), ctx.Err())
+		case <-timeout.C:
+			return nil, fmt.Errorf("%w: %s not confirmed after %s", ErrTxSent, sent[len(sent)-1].Hex(), time.Duration(opts.ConfirmTimeout))
+		case <-ticker.C:
+		}
+	}
+}
+
+// findReceipt returns the receipt of whichever sent transaction was mined,
+// or nil if none of them is included yet.
+func findReceipt(ctx context.Context, backend ChainBackend, hashes []common.Hash) (*types.Receipt, error) {
+	for _, h := range hashes {
+		receipt, err := backend.TransactionReceipt(ctx, h)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return receipt, nil
+	}
+	return nil, nil
+}
+
+// confirmations counts the blocks at and above the receipt's block. It
+// returns 0 when the block the receipt points at is no longer canonical, so
+// a reorged transaction goes back to waiting for inclusion.
+func confirmations(ctx context.Context, backend ChainBackend, receipt *types.Receipt) (int, error) {
+	head, err := backend.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if head < receipt.BlockNumber.Uint64() {
+		return 0, nil
+	}
+	header, err := backend.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return 0, err
+	}
+	if header.Hash() != receipt.BlockHash {
+		logChain("[Blockchain] Tx %s was in reorged block %s, waiting again", receipt.TxHash.Hex(), receipt.BlockHash.Hex())
+		return 0, nil
+	}
+	return int(head-receipt.BlockNumber.Uint64()) + 1, nil
+}
+
+func finishReceipt(ctx context.Context, backend ChainBackend, msg ethereum.CallMsg, receipt *types.Receipt, confs, replacements int, parsed *abi.ABI) (*TxReceipt, error) {
+	out := &TxReceipt{
+		TxHash:        receipt.TxHash.Hex(),
+		BlockNumber:   receipt.BlockNumber.Uint64(),
+		BlockHash:     receipt.BlockHash.Hex(),
+		Status:        receipt.Status,
+		GasUsed:       receipt.GasUsed,
+		Confirmations: confs,
+		Replacements:  replacements,
+	}
+	if receipt.EffectiveGasPrice != nil {
+		out.EffectiveGasPrice = receipt.EffectiveGasPrice.String()
+	}
+	if receipt.Status == types.ReceiptStatusFailed {
+		return out, &RevertError{TxHash: out.TxHash, Reason: revertReason(ctx, backend, msg, receipt.BlockNumber)}
+	}
+	out.Events = decodeEvents(receipt.Logs, parsed)
+	return out, nil
+}
+
+// revertReason replays the call against the parent block to recover the
+// revert message. It returns "" when the node gives nothing back.
+func revertReason(ctx context.Context, backend ChainBackend, msg ethereum.CallMsg, block *big.Int) string {
+	parent := new(big.Int).Sub(block, big.NewInt(1))
+	_, err := backend.CallContract(ctx, msg, parent)
+	if err == nil {
+		return ""
+	}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if s, ok := dataErr.ErrorData().(string); ok {
+			if data, decErr := hexutil.Decode(s); decErr == nil {
+				if reason, unpackErr := abi.UnpackRevert(data); unpackErr == nil {
+					return reason
+				}
+			}
+		}
+	}
+	return strings.TrimPrefix(err.Error(), "execution reverted: ")
+}
+
+// parseABI parses the abi option, giving nil when it is empty.
+func parseABI(abiJSON string) (*abi.ABI, error) {
+	if abiJSON == "" {
+		return nil, nil
+	}
+	a, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract ABI: %v", err)
+	}
+	return &a, nil
+}
+
+// decodeEvents turns the logs into events, naming those parsed knows. Logs
+// it cannot decode are kept raw.
+func decodeEvents(logs []*types.Log, parsed *abi.ABI) []DecodedEvent {
+	events := make([]DecodedEvent, 0, len(logs))
+	for _, l := range logs {
+		ev := DecodedEvent{Address: l.Address.Hex(), Data: "0x" + hex.EncodeToString(l.Data)}
+		for _, t := range l.Topics {
+			ev.Topics = append(ev.Topics, t.Hex())
+		}
+		if parsed != nil && len(l.Topics) > 0 {
+			if def, err := parsed.EventByID(l.Topics[0]); err == nil {
+				args := map[string]interface{}{}
+				var indexed abi.Arguments
+				for _, in := range def.Inputs {
+					if in.Indexed {
+						indexed = append(indexed, in)
+					}
+				}
+				if err := def.Inputs.UnpackIntoMap(args, l.Data); err == nil {
+					if err := abi.ParseTopicsIntoMap(args, indexed, l.Topics[1:]); err == nil {
+						ev.Name, ev.Args = def.Name, args
+					}
+				}
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func bumpFee(v *big.Int, percent int) *big.Int {
+	bumped := new(big.Int).Mul(v, big.NewInt(int64(100+percent)))
+	bumped.Div(bumped, big.NewInt(100))
+	if bumped.Cmp(v) <= 0 {
+		bumped.Add(v, big.NewInt(1))
+	}
+	return bumped
+}
+
+func isAlreadyKnown(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "already known")
+}
+
+// rejected reports whether a send error is the node turning the transaction
+// down, so it is known not to be in the pool. Timeouts, reset connections
+// and the like may come after the node took it.
+func rejected(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) || isNonceTooLow(err) || isReplacementUnderpriced(err) ||
+		strings.Contains(strings.ToLower(err.Error()), "insufficient funds")
+}
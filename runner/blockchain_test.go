@@ -0,0 +1,489 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeChain is an in-memory ChainBackend. Transactions sit in a pool until
+// the test mines them.
+type fakeChain struct {
+	mu       sync.Mutex
+	head     uint64
+	blocks   map[uint64]common.Hash
+	pool     []*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	nonces   map[common.Address]uint64 // next nonce per account after mined txs
+	revert   bool
+	legacy   bool // no base fee, like chains before London
+	sendErr  func(tx *types.Transaction) error
+	rpcErr   error // returned by receipt and block lookups while set
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		blocks:   map[uint64]common.Hash{0: common.HexToHash("0x01")},
+		receipts: map[common.Hash]*types.Receipt{},
+		nonces:   map[common.Address]uint64{},
+	}
+}
+
+func (f *fakeChain) ChainID(context.Context) (*big.Int, error) { return big.NewInt(1337), nil }
+
+func (f *fakeChain) BlockNumber(context.Context) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.head, f.rpcErr
+}
+
+func (f *fakeChain) HeaderByNumber(_ context.Context, n *big.Int) (*types.Header, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	num := f.head
+	if n != nil {
+		num = n.Uint64()
+	}
+	return f.header(num), nil
+}
+
+func (f *fakeChain) header(num uint64) *types.Header {
+	// Extra makes the header hash match the hash recorded for the block.
+	h := &types.Header{Number: new(big.Int).SetUint64(num), BaseFee: big.NewInt(1e9), Extra: f.blocks[num].Bytes()}
+	if f.legacy {
+		h.BaseFee = nil
+	}
+	return h
+}
+
+func (f *fakeChain) blockHash(num uint64) common.Hash {
+	return f.header(num).Hash()
+}
+
+func (f *fakeChain) PendingNonceAt(_ context.Context, a common.Address) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.nonces[a]
+	for _, tx := range f.pool {
+		if from, _ := types.Sender(types.LatestSignerForChainID(big.NewInt(1337)), tx); from == a && tx.Nonce() >= n {
+			n = tx.Nonce() + 1
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeChain) SuggestGasTipCap(context.Context) (*big.Int, error) { return big.NewInt(1e9), nil }
+
+func (f *fakeChain) SuggestGasPrice(context.Context) (*big.Int, error) { return big.NewInt(2e9), nil }
+
+func (f *fakeChain) EstimateGas(context.Context, ethereum.CallMsg) (uint64, error) { return 50000, nil }
+
+func (f *fakeChain) SendTransaction(_ context.Context, tx *types.Transaction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		if err := f.sendErr(tx); err != nil {
+			return err
+		}
+	}
+	from, _ := types.Sender(types.LatestSignerForChainID(big.NewInt(1337)), tx)
+	if tx.Nonce() < f.nonces[from] {
+		return fmt.Errorf("nonce too low: next nonce %d, tx nonce %d", f.nonces[from], tx.Nonce())
+	}
+	for i, p := range f.pool {
+		if pf, _ := types.Sender(types.LatestSignerForChainID(big.NewInt(1337)), p); pf != from || p.Nonce() != tx.Nonce() {
+			continue
+		}
+		if tx.GasTipCap().Cmp(p.GasTipCap()) <= 0 {
+			return errors.New("replacement transaction underpriced")
+		}
+		f.pool[i] = tx
+		return nil
+	}
+	f.pool = append(f.pool, tx)
+	return nil
+}
+
+func (f *fakeChain) TransactionReceipt(_ context.Context, h common.Hash) (*types.Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rpcErr != nil {
+		return nil, f.rpcErr
+	}
+	if r, ok := f.receipts[h]; ok {
+		return r, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (f *fakeChain) CallContract(context.Context, ethereum.CallMsg, *big.Int) ([]byte, error) {
+	return nil, errors.New("execution reverted: sold out")
+}
+
+// mine includes the pooled transaction at index i (or the last one if i<0)
+// in a new block and drops the rest of the pool.
+func (f *fakeChain) mine(i int) *types.Transaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.pool) == 0 {
+		return nil
+	}
+	if i < 0 {
+		i = len(f.pool) - 1
+	}
+	tx := f.pool[i]
+	f.include(tx)
+	f.pool = nil
+	return tx
+}
+
+// mineAll includes every pooled transaction, one per block.
+func (f *fakeChain) mineAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tx := range f.pool {
+		f.include(tx)
+	}
+	f.pool = nil
+}
+
+func (f *fakeChain) include(tx *types.Transaction) {
+	f.head++
+	f.blocks[f.head] = common.BigToHash(big.NewInt(int64(f.head)))
+	status := types.ReceiptStatusSuccessful
+	if f.revert {
+		status = types.ReceiptStatusFailed
+	}
+	f.receipts[tx.Hash()] = &types.Receipt{
+		TxHash:      tx.Hash(),
+		Status:      status,
+		BlockNumber: new(big.Int).SetUint64(f.head),
+		BlockHash:   f.blockHash(f.head),
+		GasUsed:     21000,
+		Logs: []*types.Log{{
+			Address: *tx.To(),
+			Topics:  []common.Hash{crypto.Keccak256Hash([]byte("Minted(address,uint256)")), common.BytesToHash(tx.To().Bytes())},
+			Data:    common.LeftPadBytes(big.NewInt(7).Bytes(), 32),
+		}},
+	}
+	from, _ := types.Sender(types.LatestSignerForChainID(big.NewInt(1337)), tx)
+	if tx.Nonce() >= f.nonces[from] {
+		f.nonces[from] = tx.Nonce() + 1
+	}
+}
+
+// emptyBlocks advances the head without including anything.
+func (f *fakeChain) emptyBlocks(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.head++
+		f.blocks[f.head] = common.BigToHash(big.NewInt(int64(f.head)))
+	}
+}
+
+// reorg replaces the block at num with a different one.
+func (f *fakeChain) reorg(num uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[num] = common.HexToHash("0xdead")
+}
+
+func (f *fakeChain) poolSize() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pool)
+}
+
+const mintedABI = `[{"type":"event","name":"Minted","inputs":[{"name":"to","type":"address","indexed":true},{"name":"id","type":"uint256","indexed":false}]}]`
+
+func testOpts() BlockchainOptions {
+	return (&BlockchainOptions{
+		Confirmations: 3,
+		ABI:           mintedABI,
+		PollInterval:  Duration(time.Millisecond),
+	}).withDefaults()
+}
+
+func runConfirm(t *testing.T, chain *fakeChain, opts BlockchainOptions) (chan *TxReceipt, chan error) {
+	t.Helper()
+	key, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	done, errc := make(chan *TxReceipt, 1), make(chan error, 1)
+	go func() {
+		r, err := sendAndConfirm(context.Background(), chain, NewMemorySigner(key), to, []byte{1, 2, 3, 4}, opts)
+		done <- r
+		errc <- err
+	}()
+	waitFor(t, func() bool { return chain.poolSize() > 0 })
+	return done, errc
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendAndConfirmWaitsForConfirmations(t *testing.T) {
+	chain := newFakeChain()
+	done, errc := runConfirm(t, chain, testOpts())
+
+	chain.mine(-1)
+	chain.emptyBlocks(1)
+	select {
+	case <-done:
+		t.Fatal("returned with 2 of 3 confirmations")
+	case <-time.After(20 * time.Millisecond):
+	}
+	chain.emptyBlocks(1)
+
+	r := <-done
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if r.Confirmations != 3 || r.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("receipt = %+v", r)
+	}
+	if len(r.Events) != 1 || r.Events[0].Name != "Minted" || r.Events[0].Args["id"].(*big.Int).Int64() != 7 {
+		t.Fatalf("events = %+v", r.Events)
+	}
+}
+
+func TestSendAndConfirmSurvivesReorg(t *testing.T) {
+	chain := newFakeChain()
+	done, errc := runConfirm(t, chain, testOpts())
+
+	tx := chain.mine(-1)
+	chain.reorg(1)
+	chain.emptyBlocks(5)
+	select {
+	case <-done:
+		t.Fatal("confirmed a receipt from a reorged block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The node re-includes the transaction on the new chain.
+	chain.mu.Lock()
+	moved := *chain.receipts[tx.Hash()]
+	moved.BlockHash = chain.blockHash(1)
+	chain.receipts[tx.Hash()] = &moved
+	chain.mu.Unlock()
+
+	<-done
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendAndConfirmRevert(t *testing.T) {
+	chain := newFakeChain()
+	chain.revert = true
+	opts := testOpts()
+	opts.Confirmations = 1
+	done, errc := runConfirm(t, chain, opts)
+
+	chain.mine(-1)
+	<-done
+	var revert *RevertError
+	if err := <-errc; !errors.As(err, &revert) || revert.Reason != "sold out" || !errors.Is(err, ErrTxSent) {
+		t.Fatalf("err = %v, want revert with reason", err)
+	}
+}
+
+func TestSendAndConfirmKeepsPolling(t *testing.T) {
+	chain := newFakeChain()
+	chain.legacy = true
+	opts := testOpts()
+	opts.Confirmations = 1
+	opts.ReplaceAfter = Duration(time.Hour)
+	done, errc := runConfirm(t, chain, opts)
+
+	chain.mu.Lock()
+	tx := chain.pool[0]
+	chain.rpcErr = errors.New("502 bad gateway")
+	chain.mu.Unlock()
+	if tx.Type() != types.LegacyTxType || tx.GasPrice().Int64() != 2e9 {
+		t.Fatalf("sent type %d at gas price %s on a chain without base fee", tx.Type(), tx.GasPrice())
+	}
+	chain.mine(-1)
+	select {
+	case <-done:
+		t.Fatal("returned while the node was failing")
+	case <-time.After(20 * time.Millisecond):
+	}
+	chain.mu.Lock()
+	chain.rpcErr = nil
+	chain.mu.Unlock()
+	if r := <-done; r == nil || r.TxHash != tx.Hash().Hex() {
+		t.Fatalf("receipt = %+v", r)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendAndConfirmRedactsRPCURL(t *testing.T) {
+	t.Setenv("WEB4_SECRET_INFURA", "infura-key-0123456789")
+	rpcURL, err := NewSecrets(EnvSecrets(defaultSecretPrefix)).Resolve(context.Background(), "https://mainnet.example/v3/${secret:infura}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	chain := newFakeChain()
+	opts := testOpts()
+	opts.Confirmations = 1
+	done, errc := runConfirm(t, chain, opts)
+	chain.mu.Lock()
+	chain.rpcErr = &url.Error{Op: "Post", URL: rpcURL, Err: errors.New("connection reset by peer")}
+	chain.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	chain.mu.Lock()
+	chain.rpcErr = nil
+	chain.mu.Unlock()
+	chain.mine(-1)
+	<-done
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "connection reset") || strings.Contains(out, "infura-key-0123456789") {
+		t.Fatalf("log = %s", out)
+	}
+}
+
+func TestSendAndConfirmChecksABIFirst(t *testing.T) {
+	chain := newFakeChain()
+	key, _ := crypto.GenerateKey()
+	opts := testOpts()
+	opts.ABI = "not json"
+	if _, err := sendAndConfirm(t.Context(), chain, NewMemorySigner(key), common.HexToAddress("0xaa"), nil, opts); err == nil || errors.Is(err, ErrTxSent) {
+		t.Fatalf("err = %v", err)
+	}
+	if chain.poolSize() != 0 {
+		t.Fatal("sent a transaction with an invalid ABI")
+	}
+}
+
+func TestSendAndConfirmAmbiguousSend(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	for _, tc := range []struct {
+		err  error
+		sent bool
+	}{
+		{errors.New(`Post "http://node:8545": read tcp: connection reset by peer`), true},
+		{context.DeadlineExceeded, true},
+		{errors.New("insufficient funds for gas * price + value"), false},
+	} {
+		chain := newFakeChain()
+		var hash common.Hash
+		chain.sendErr = func(tx *types.Transaction) error {
+			hash = tx.Hash()
+			return tc.err
+		}
+		_, err := sendAndConfirm(t.Context(), chain, NewMemorySigner(key), common.HexToAddress("0xaa"), nil, testOpts())
+		if err == nil || errors.Is(err, ErrTxSent) != tc.sent {
+			t.Errorf("%v: err = %v, want sent %v", tc.err, err, tc.sent)
+		}
+		if tc.sent && !strings.Contains(err.Error(), hash.Hex()) {
+			t.Errorf("%v: err = %v, want the tx hash", tc.err, err)
+		}
+	}
+}
+
+func TestSendAndConfirmTimesOut(t *testing.T) {
+	chain := newFakeChain()
+	opts := testOpts()
+	opts.ReplaceAfter = Duration(time.Millisecond)
+	opts.MaxReplacements = 1
+	opts.ConfirmTimeout = Duration(50 * time.Millisecond)
+	done, errc := runConfirm(t, chain, opts)
+
+	if r := <-done; r != nil {
+		t.Fatalf("receipt = %+v", r)
+	}
+	if err := <-errc; !errors.Is(err, ErrTxSent) || !strings.Contains(err.Error(), "not confirmed") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSendAndConfirmReplacesStuckTx(t *testing.T) {
+	chain := newFakeChain()
+	opts := testOpts()
+	opts.Confirmations = 1
+	opts.ReplaceAfter = Duration(5 * time.Millisecond)
+	done, errc := runConfirm(t, chain, opts)
+
+	chain.mu.Lock()
+	first := chain.pool[0]
+	chain.mu.Unlock()
+	var second *types.Transaction
+	waitFor(t, func() bool {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		second = chain.pool[0]
+		return second.Hash() != first.Hash()
+	})
+	if first.Nonce() != second.Nonce() || second.GasTipCap().Cmp(first.GasTipCap()) <= 0 {
+		t.Fatalf("replacement nonce=%d tip=%s, original nonce=%d tip=%s", second.Nonce(), second.GasTipCap(), first.Nonce(), first.GasTipCap())
+	}
+
+	second = chain.mine(0)
+	r := <-done
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if r.TxHash != second.Hash().Hex() || r.Replacements < 1 {
+		t.Fatalf("receipt = %+v", r)
+	}
+}
+
+func TestParseContractCall(t *testing.T) {
+	for _, payload := range []string{"0x00000000000000000000000000000000000000aa:mintNFT", "mintNFT:0x00000000000000000000000000000000000000aa"} {
+		to, data, err := parseContractCall(payload, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", payload, err)
+		}
+		if to != common.HexToAddress("0xaa") {
+			t.Fatalf("%s: to = %s", payload, to.Hex())
+		}
+		if want := crypto.Keccak256([]byte("mintNFT()"))[:4]; string(data) != string(want) {
+			t.Fatalf("%s: data = %x, want %x", payload, data, want)
+		}
+	}
+	if _, _, err := parseContractCall("mintNFT", nil); err == nil {
+		t.Fatal("expected error for payload without address")
+	}
+}
+
+func TestRunnerDoesNotResendTx(t *testing.T) {
+	reg := NewTaskRegistry()
+	r := New(Options{Store: reg, Logger: discard, MaxRetries: 2, Executor: ExecutorFunc(func(context.Context, int, TaskSpec) (interface{}, error) {
+		return &TxReceipt{TxHash: "0x01"}, &RevertError{TxHash: "0x01"}
+	})})
+	id, _ := r.Submit(t.Context(), TaskSpec{Type: "blockchain"})
+	if rec, _ := r.Wait(id); rec.Status != "failed" || rec.Attempts != 1 {
+		t.Fatalf("record = %+v", rec)
+	}
+}
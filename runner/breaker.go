@@ -0,0 +1,362 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- CIRCUIT BREAKERS ----------------
+
+// BreakerConfig tunes the circuit breaker of one dependency.
+type BreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold,omitempty"` // consecutive failures that open the breaker, default 5
+	OpenFor          Duration `json:"open_for,omitempty"`          // how long it stays open before a trial call, default 30s
+	HalfOpenCalls    int      `json:"half_open_calls,omitempty"`   // concurrent trial calls while half-open, default 1
+	Defer            bool     `json:"defer,omitempty"`             // tasks wait for the breaker instead of failing fast
+	Disabled         bool     `json:"disabled,omitempty"`
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	c.FailureThreshold = intOrDefault(c.FailureThreshold, 5)
+	c.OpenFor = Duration(orDefault(c.OpenFor, 30*time.Second))
+	c.HalfOpenCalls = intOrDefault(c.HalfOpenCalls, 1)
+	return c
+}
+
+// Breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is wrapped by the errors of calls a breaker turned away.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned instead of calling a dependency whose
+// breaker is open.
+type CircuitOpenError struct {
+	Dependency string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %v, retry in %s", e.Dependency, ErrCircuitOpen, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// BreakerState is a snapshot of one breaker, as served by GET /breakers
+// and sent with breaker.state_changed events.
+type BreakerState struct {
+	Dependency  string    `json:"dependency"`
+	State       string    `json:"state"`
+	Failures    int       `json:"failures"` // consecutive
+	OpenUntil   time.Time `json:"open_until"`
+	Transitions int       `json:"transitions"`
+}
+
+// breaker tracks one dependency. Closed, it counts consecutive failures
+// and opens at the threshold. Open, it turns calls away until OpenFor has
+// passed, then lets HalfOpenCalls trial calls through: a success closes it,
+// a failure opens it again.
+type breaker struct {
+	key string
+	cfg BreakerConfig
+
+	state       string
+	failures    int
+	openUntil   time.Time
+	trials      int
+	transitions int
+}
+
+// BreakerSet holds the breakers of all dependencies, created on first use.
+type BreakerSet struct {
+	cfgs     map[string]BreakerConfig // by dependency, "*" for the rest
+	now      func() time.Time
+	metrics  *metrics
+	notifier *Notifier // breaker.state_changed events, nil for none
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewBreakerSet creates breakers configured by dependency host. The entry
+// "*" applies to hosts without their own.
+func NewBreakerSet(cfgs map[string]BreakerConfig) *BreakerSet {
+	return &BreakerSet{cfgs: cfgs, now: time.Now, metrics: newMetrics(), breakers: map[string]*breaker{}}
+}
+
+func (s *BreakerSet) config(key string) BreakerConfig {
+	cfg, ok := s.cfgs[key]
+	if !ok {
+		cfg = s.cfgs["*"]
+	}
+	return cfg.withDefaults()
+}
+
+// get returns key's breaker, or nil when it is disabled. Callers hold s.mu.
+func (s *BreakerSet) get(key string) *breaker {
+	if key == "" {
+		return nil
+	}
+	b, ok := s.breakers[key]
+	if !ok {
+		cfg := s.config(key)
+		if cfg.Disabled {
+			return nil
+		}
+		b = &breaker{key: key, cfg: cfg, state: BreakerClosed}
+		s.breakers[key] = b
+	}
+	return b
+}
+
+// acquire admits one call to key. It returns false and the time left
+// while the breaker is open or its trial calls are taken.
+func (s *BreakerSet) acquire(key string) (bool, time.Duration) {
+	s.mu.Lock()
+	b := s.get(key)
+	if b == nil {
+		s.mu.Unlock()
+		return true, 0
+	}
+	now := s.now()
+	var changed *BreakerState
+	if b.state == BreakerOpen && !now.Before(b.openUntil) {
+		changed = s.transition(b, BreakerHalfOpen)
+	}
+	ok, wait := true, time.Duration(0)
+	switch b.state {
+	case BreakerOpen:
+		ok, wait = false, b.openUntil.Sub(now)
+	case BreakerHalfOpen:
+		if b.trials >= b.cfg.HalfOpenCalls {
+			ok, wait = false, time.Duration(b.cfg.OpenFor)
+		} else {
+			b.trials++
+		}
+	}
+	s.mu.Unlock()
+	if changed != nil {
+		s.changed(*changed)
+	}
+	if !ok {
+		s.metrics.breakerRejected.WithLabelValues(key).Inc()
+	}
+	return ok, wait
+}
+
+// done records the outcome of a call admitted by acquire. A call that
+// neither succeeded nor failed, such as one canceled by its caller, only
+// frees its trial slot.
+func (s *BreakerSet) done(key string, success, counts bool) {
+	s.mu.Lock()
+	b := s.get(key)
+	if b == nil {
+		s.mu.Unlock()
+		return
+	}
+	var changed *BreakerState
+	half := b.state == BreakerHalfOpen
+	if half && b.trials > 0 {
+		b.trials--
+	}
+	switch {
+	case !counts:
+	case success:
+		b.failures = 0
+		if half {
+			changed = s.transition(b, BreakerClosed)
+		}
+	default:
+		b.failures++
+		if half || (b.state == BreakerClosed && b.failures >= b.cfg.FailureThreshold) {
+			changed = s.transition(b, BreakerOpen)
+		}
+	}
+	s.mu.Unlock()
+	if changed != nil {
+		s.changed(*changed)
+	}
+}
+
+// transition moves b to state and returns the snapshot to report once s.mu
+// is released. Callers hold s.mu.
+func (s *BreakerSet) transition(b *breaker, state string) *BreakerState {
+	b.state = state
+	b.transitions++
+	switch state {
+	case BreakerOpen:
+		b.openUntil = s.now().Add(time.Duration(b.cfg.OpenFor))
+		b.trials = 0
+	case BreakerClosed:
+		b.openUntil = time.Time{}
+	}
+	st := b.snapshot()
+	return &st
+}
+
+func (b *breaker) snapshot() BreakerState {
+	return BreakerState{Dependency: b.key, State: b.state, Failures: b.failures, OpenUntil: b.openUntil, Transitions: b.transitions}
+}
+
+// States returns every breaker that has seen a call, by dependency.
+func (s *BreakerSet) States() []BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []BreakerState{}
+	for _, b := range s.breakers {
+		out = append(out, b.snapshot())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Dependency < out[j].Dependency })
+	return out
+}
+
+// Admit checks the breakers of a task's dependencies before it starts.
+// While one is open the task fails fast with a *CircuitOpenError, or, for a
+// dependency configured to defer, waits until a trial call is due.
+func (s *BreakerSet) Admit(ctx context.Context, deps []string) error {
+	for _, dep := range deps {
+		for {
+			s.mu.Lock()
+			b := s.get(dep)
+			var open bool
+			var wait time.Duration
+			if b != nil && b.state == BreakerOpen {
+				wait = b.openUntil.Sub(s.now())
+				open = wait > 0
+			}
+			s.mu.Unlock()
+			if !open {
+				break
+			}
+			if !b.cfg.Defer {
+				s.metrics.breakerRejected.WithLabelValues(dep).Inc()
+				return &CircuitOpenError{Dependency: dep, RetryAfter: wait}
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+	return nil
+}
+
+// failFast reports whether err ends a task without retries: a dependency's
+// breaker is open and the task is not meant to wait for it.
+func (s *BreakerSet) failFast(err error) bool {
+	var open *CircuitOpenError
+	return errors.As(err, &open) && !s.config(open.Dependency).Defer
+}
+
+// changed exports a state change as a metric, a log line and a webhook
+// event.
+func (s *BreakerSet) changed(st BreakerState) {
+	for _, state := range []string{BreakerClosed, BreakerHalfOpen, BreakerOpen} {
+		v := 0.0
+		if state == st.State {
+			v = 1
+		}
+		s.metrics.breakerState.WithLabelValues(st.Dependency, state).Set(v)
+	}
+	s.metrics.breakerTransitions.WithLabelValues(st.Dependency, st.State).Inc()
+	log.Printf("[Breaker %s] %s after %d consecutive failures", st.Dependency, st.State, st.Failures)
+	if s.notifier != nil {
+		s.notifier.Notify(s.notifier.BreakerEvent(st))
+	}
+}
+
+// breakerTransport guards the calls of an Env's HTTP client. Network
+// errors, 429 and 5xx count as failures of the request's host.
+type breakerTransport struct {
+	base http.RoundTripper
+	set  *BreakerSet
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	set, key := t.set, req.URL.Host
+	if ok, wait := set.acquire(key); !ok {
+		return nil, &CircuitOpenError{Dependency: key, RetryAfter: wait}
+	}
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		// A call its caller gave up on says nothing about the dependency.
+		set.done(key, false, req.Context().Err() == nil)
+	default:
+		set.done(key, resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500, true)
+	}
+	return resp, err
+}
+
+// taskDependencies are the hosts a task will call, as far as its spec tells.
+func (e *Env) taskDependencies(spec TaskSpec) []string {
+	var raw string
+	switch spec.Type {
+	case "download", "http":
+		raw = spec.Payload
+	case "storage":
+		raw = e.ipfsAPI
+	case "blockchain":
+		raw = os.Getenv("ETH_RPC_URL")
+		if spec.Blockchain != nil && spec.Blockchain.RPCURL != "" {
+			raw = spec.Blockchain.RPCURL
+		}
+	case "ai":
+		alias := defaultLLM
+		if spec.AI != nil && spec.AI.Provider != "" {
+			alias = spec.AI.Provider
+		}
+		switch p := e.llmProviders[alias].(type) {
+		case *openAIProvider:
+			raw = p.baseURL
+		case *localProvider:
+			raw = p.baseURL
+		}
+	}
+	if host := hostOf(raw); host != "" {
+		return []string{host}
+	}
+	return nil
+}
+
+// hostOf is the host[:port] of a URL, or of a bare address like the IPFS
+// API's localhost:5001.
+func hostOf(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func serveBreakers(s *BreakerSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !unrestricted(req) {
+			forbiddenShared(w, req)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.States())
+	}
+}
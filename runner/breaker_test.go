@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useBreakers(t *testing.T, cfgs map[string]BreakerConfig) *BreakerSet {
+	t.Helper()
+	old, oldClient := defaultEnv.breakers, defaultEnv.httpClient
+	t.Cleanup(func() { defaultEnv.breakers, defaultEnv.httpClient = old, oldClient })
+	s := NewBreakerSet(cfgs)
+	client, err := newHTTPClient(HTTPConfig{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultEnv.breakers, defaultEnv.httpClient = s, client
+	return s
+}
+
+func TestBreakerStates(t *testing.T) {
+	s := NewBreakerSet(map[string]BreakerConfig{"*": {FailureThreshold: 2, OpenFor: Duration(10 * time.Second)}})
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	state := func() string { return s.States()[0].State }
+
+	// A success in between resets the count.
+	for _, ok := range []bool{false, true, false} {
+		s.acquire("rpc:8545")
+		s.done("rpc:8545", ok, true)
+	}
+	if state() != BreakerClosed {
+		t.Fatalf("state = %s after non-consecutive failures", state())
+	}
+	s.acquire("rpc:8545")
+	s.done("rpc:8545", false, true)
+	if state() != BreakerOpen {
+		t.Fatalf("state = %s at the threshold", state())
+	}
+	if ok, wait := s.acquire("rpc:8545"); ok || wait != 10*time.Second {
+		t.Fatalf("open breaker admitted a call: %v, %s", ok, wait)
+	}
+
+	// After OpenFor one trial call goes through; a failure reopens.
+	now = now.Add(10 * time.Second)
+	if ok, _ := s.acquire("rpc:8545"); !ok || state() != BreakerHalfOpen {
+		t.Fatalf("trial call: %v, state %s", ok, state())
+	}
+	if ok, _ := s.acquire("rpc:8545"); ok {
+		t.Fatal("second concurrent trial call admitted")
+	}
+	s.done("rpc:8545", false, true)
+	if state() != BreakerOpen {
+		t.Fatalf("state = %s after a failed trial", state())
+	}
+
+	// A canceled trial frees the slot; a successful one closes.
+	now = now.Add(10 * time.Second)
+	s.acquire("rpc:8545")
+	s.done("rpc:8545", false, false)
+	s.acquire("rpc:8545")
+	s.done("rpc:8545", true, true)
+	if st := s.States()[0]; st.State != BreakerClosed || st.Transitions != 5 {
+		t.Fatalf("state = %+v", st)
+	}
+}
+
+func TestBreakerFailsTasksFast(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	useBreakers(t, map[string]BreakerConfig{"*": {FailureThreshold: 1, OpenFor: Duration(time.Minute)}})
+
+	r := New(Options{
+		Env:        defaultEnv,
+		MaxRetries: 5,
+		Logger:     discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			resp, err := envOf(ctx).client(nil).Get(spec.Payload)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}),
+	})
+	id, _ := r.Submit(context.Background(), TaskSpec{Type: "http", Payload: srv.URL + "/rpc"})
+	rec, _ := r.Wait(id)
+
+	// The first attempt opens the breaker and the second ends the task
+	// without reaching the server.
+	if hits.Load() != 1 || rec.Status != "failed" || rec.Attempts != 2 {
+		t.Fatalf("hits = %d, record = %+v", hits.Load(), rec)
+	}
+	if !strings.Contains(rec.Error, "circuit breaker open") {
+		t.Errorf("error = %q", rec.Error)
+	}
+	if deps := defaultEnv.taskDependencies(TaskSpec{Type: "http", Payload: srv.URL}); len(deps) != 1 || deps[0] != defaultEnv.breakers.States()[0].Dependency {
+		t.Errorf("dependencies = %v, breakers = %+v", deps, defaultEnv.breakers.States())
+	}
+}
+
+func TestBreakerDefersTasks(t *testing.T) {
+	s := useBreakers(t, map[string]BreakerConfig{"localhost:5001": {FailureThreshold: 1, OpenFor: Duration(50 * time.Millisecond), Defer: true}})
+	s.acquire("localhost:5001")
+	s.done("localhost:5001", false, true)
+
+	start := time.Now()
+	if err := s.Admit(context.Background(), []string{"localhost:5001"}); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("deferred task started after %s", waited)
+	}
+	if s.failFast(&CircuitOpenError{Dependency: "localhost:5001"}) || !s.failFast(&CircuitOpenError{Dependency: "other:80"}) {
+		t.Error("failFast ignores the defer setting")
+	}
+}
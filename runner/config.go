@@ -0,0 +1,130 @@
+// Package runner is the Web4 job engine: task types, retries, the task
+// registry and API, and the batch, pipeline and dynamic schedulers. The
+// web4-runner command in cmd/web4-runner is a thin CLI over it; services
+// that embed the engine create a Runner with New and Submit tasks to it.
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --------------------- CONFIG ---------------------
+type Config struct {
+	MaxConcurrency int                     `json:"max_concurrency"`
+	MaxRetries     int                     `json:"max_retries"`
+	Tasks          []TaskSpec              `json:"tasks"`                   // batch for the run command
+	Pipeline       []PipelineTask          `json:"pipeline,omitempty"`      // task trees for the pipeline and dynamic commands
+	Rules          []Rule                  `json:"rules,omitempty"`         // follow-up rules for the dynamic command
+	MaxDepth       int                     `json:"max_depth,omitempty"`     // dynamic command: longest chain of generated tasks, default 5
+	Signers        map[string]SignerConfig `json:"signers,omitempty"`       // blockchain signing keys by alias
+	LLMProviders   map[string]LLMConfig    `json:"llm_providers,omitempty"` // model endpoints for ai tasks by alias
+	PromptsDir     string                  `json:"prompts_dir,omitempty"`   // prompt templates for ai tasks, default ./prompts
+	ArtifactRoot   string                  `json:"artifact_root,omitempty"` // content-addressed store for task outputs, default ./artifacts
+	Retention      RetentionPolicy         `json:"artifact_retention,omitempty"`
+	Webhooks       []WebhookConfig         `json:"webhooks,omitempty"` // receivers of task and run events
+	HTTP           HTTPConfig              `json:"http,omitempty"`     // outbound HTTP client shared by all tasks
+	IPFSAPI        string                  `json:"ipfs_api,omitempty"` // IPFS API for storage tasks, e.g. localhost:5001; without it uploads are simulated
+	Queue          *QueueConfig            `json:"queue,omitempty"`    // shared task queue for the worker command
+
+	// Breakers configures the circuit breakers of outbound calls by host,
+	// e.g. "localhost:5001"; "*" applies to the other hosts.
+	Breakers map[string]BreakerConfig `json:"circuit_breakers,omitempty"`
+
+	// Auth requires bearer tokens on the task API; without it the API is
+	// open to anyone who can reach it.
+	Auth *AuthConfig `json:"auth,omitempty"`
+
+	// Namespaces sets the quotas of each namespace. Once any are set, tasks
+	// may only use these and "default".
+	Namespaces map[string]NamespaceConfig `json:"namespaces,omitempty"`
+
+	// Costs prices AI tokens and gas and sets budgets on the total.
+	Costs *CostConfig `json:"costs,omitempty"`
+
+	// Secrets are where ${secret:name} references in task specs are
+	// resolved, when a task runs.
+	Secrets *SecretsConfig `json:"secrets,omitempty"`
+}
+
+type TaskSpec struct {
+	Type       string             `json:"type"`
+	Payload    string             `json:"payload"`
+	Namespace  string             `json:"namespace,omitempty"` // default "default"; pipeline children inherit their parent's
+	Blockchain *BlockchainOptions `json:"blockchain,omitempty"`
+	AI         *AIOptions         `json:"ai,omitempty"`
+	Download   *DownloadOptions   `json:"download,omitempty"`
+	Request    *HTTPTaskOptions   `json:"request,omitempty"`
+	Exec       *ExecOptions       `json:"exec,omitempty"`
+	HTTP       *HTTPOverrides     `json:"http,omitempty"`
+}
+
+// Duration is a time.Duration that reads from JSON as a string like "90s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfig is the configuration used when CONFIG_JSON is not set.
+func DefaultConfig() Config {
+	return Config{
+		MaxConcurrency: 3,
+		MaxRetries:     2,
+		Tasks: []TaskSpec{
+			{Type: "download", Payload: "https://httpbin.org/get"},
+			{Type: "ai", Payload: "Write Web4 article summary"},
+			{Type: "blockchain", Payload: "0xContractAddress:mintNFT"},
+			{Type: "storage", Payload: "/tmp/sample.txt"},
+		},
+	}
+}
+
+// LoadConfig reads CONFIG_JSON over the defaults.
+func LoadConfig() (Config, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv("CONFIG_JSON"); v != "" {
+		if err := json.Unmarshal([]byte(v), &cfg); err != nil {
+			return cfg, fmt.Errorf("CONFIG_JSON: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Setup builds the command's environment from cfg, with its metrics on
+// the default Prometheus registry, and the API's authentication. It must
+// be called before tasks run.
+func Setup(cfg Config) error {
+	env, err := NewEnv(cfg, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	auth, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	defaultEnv, apiAuth = env, auth
+	submitted = newAPIRunner(env.tasks, env, cfg.MaxConcurrency, cfg.MaxRetries)
+	if cfg.Queue == nil {
+		// With a shared queue, retention is a leader duty; see Work.
+		gcArtifacts(env.artifacts, cfg.Retention)
+	}
+	return nil
+}
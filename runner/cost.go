@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ---------------- COST ACCOUNTING ----------------
+
+// CostConfig prices what tasks use, in US dollars, and sets budgets on it.
+// Without prices usage is still recorded, at a cost of 0.
+type CostConfig struct {
+	Models            map[string]ModelPrice `json:"models,omitempty"`              // by model name, "*" for any other
+	ETHUSD            float64               `json:"eth_usd,omitempty"`             // price of one ether, to cost gas
+	DailyBudgetUSD    float64               `json:"daily_budget_usd,omitempty"`    // all namespaces, per UTC day
+	PipelineBudgetUSD float64               `json:"pipeline_budget_usd,omitempty"` // per task tree
+}
+
+// ModelPrice is what a model costs per million tokens.
+type ModelPrice struct {
+	PromptUSD     float64 `json:"prompt_usd"`
+	CompletionUSD float64 `json:"completion_usd"`
+}
+
+// Kinds of usage.
+const (
+	UsageAI  = "ai"  // LLM tokens
+	UsageGas = "gas" // gas of a mined transaction
+)
+
+// Usage is what one call of a task consumed. Executors report it with
+// ReportUsage; the runner prices it and adds it to the task's record.
+type Usage struct {
+	Attempt          int       `json:"attempt"`
+	Kind             string    `json:"kind"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	GasUsed          uint64    `json:"gas_used,omitempty"`
+	GasPriceWei      string    `json:"gas_price_wei,omitempty"` // effective gas price
+	GasWei           string    `json:"gas_wei,omitempty"`       // GasUsed times GasPriceWei
+	CostUSD          float64   `json:"cost_usd"`
+	Time             time.Time `json:"time"`
+}
+
+func (u Usage) String() string {
+	if u.Kind == UsageGas {
+		return fmt.Sprintf("gas %d at %s wei, $%.6f", u.GasUsed, u.GasPriceWei, u.CostUSD)
+	}
+	return fmt.Sprintf("%s %s: %d prompt + %d completion tokens, $%.6f", u.Kind, u.Model, u.PromptTokens, u.CompletionTokens, u.CostUSD)
+}
+
+type usageKey struct{}
+
+// ReportUsage records what a call made by the running task consumed.
+// Executors call it with the context they were given; outside a Runner it
+// does nothing.
+func ReportUsage(ctx context.Context, u Usage) {
+	if report, ok := ctx.Value(usageKey{}).(func(Usage)); ok {
+		report(u)
+	}
+}
+
+// withUsage makes ReportUsage on ctx call report.
+func withUsage(ctx context.Context, report func(Usage)) context.Context {
+	return context.WithValue(ctx, usageKey{}, report)
+}
+
+// ErrBudgetExceeded fails a task without retries.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetError is returned for an AI or blockchain task once a budget it
+// falls under is spent.
+type BudgetError struct {
+	Budget   string // daily, namespace or pipeline
+	Key      string // the date, namespace or pipeline ID
+	LimitUSD float64
+	SpentUSD float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget %s: %v, spent $%.2f of $%.2f", e.Budget, e.Key, ErrBudgetExceeded, e.SpentUSD, e.LimitUSD)
+}
+
+func (e *BudgetError) Unwrap() error { return ErrBudgetExceeded }
+
+// costlyTypes are the task types budgets hold back.
+var costlyTypes = map[string]bool{"ai": true, "blockchain": true}
+
+// CostLedger prices usage and keeps the totals budgets are checked against.
+// Like Quotas it counts what its own process ran.
+type CostLedger struct {
+	cfg     CostConfig
+	budgets map[string]float64 // daily budget by namespace
+	now     func() time.Time
+	metrics *metrics
+
+	mu       sync.Mutex
+	charges  []charge
+	daily    map[string]float64 // by UTC date
+	byNSDay  map[string]float64 // by namespace and date
+	pipeline map[int]float64
+}
+
+// charge is usage filed against a task.
+type charge struct {
+	Task, Pipeline  int
+	Namespace, Type string
+	Day             string
+	Usage
+}
+
+// NewCostLedger checks cfg and returns a ledger for it. namespaces set the
+// daily budget of each namespace.
+func NewCostLedger(cfg *CostConfig, namespaces map[string]NamespaceConfig) (*CostLedger, error) {
+	l := &CostLedger{budgets: map[string]float64{}, now: time.Now, metrics: newMetrics(),
+		daily: map[string]float64{}, byNSDay: map[string]float64{}, pipeline: map[int]float64{}}
+	if cfg != nil {
+		l.cfg = *cfg
+	}
+	for model, p := range l.cfg.Models {
+		if p.PromptUSD < 0 || p.CompletionUSD < 0 {
+			return nil, fmt.Errorf("costs: model %s: negative price", model)
+		}
+	}
+	if l.cfg.ETHUSD < 0 || l.cfg.DailyBudgetUSD < 0 || l.cfg.PipelineBudgetUSD < 0 {
+		return nil, errors.New("costs: negative price or budget")
+	}
+	for ns, c := range namespaces {
+		if c.BudgetUSDPerDay < 0 {
+			return nil, fmt.Errorf("namespace %q: negative budget_usd_per_day", ns)
+		}
+		if c.BudgetUSDPerDay > 0 {
+			l.budgets[ns] = c.BudgetUSDPerDay
+		}
+	}
+	return l, nil
+}
+
+// price fills in the gas total and the cost of u.
+func (l *CostLedger) price(u Usage) Usage {
+	switch u.Kind {
+	case UsageAI:
+		p, ok := l.cfg.Models[u.Model]
+		if !ok {
+			p = l.cfg.Models["*"]
+		}
+		u.CostUSD = (float64(u.PromptTokens)*p.PromptUSD + float64(u.CompletionTokens)*p.CompletionUSD) / 1e6
+	case UsageGas:
+		price, ok := new(big.Int).SetString(u.GasPriceWei, 10)
+		if !ok {
+			price = new(big.Int)
+		}
+		wei := price.Mul(price, new(big.Int).SetUint64(u.GasUsed))
+		u.GasWei = wei.String()
+		eth, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e18)).Float64()
+		u.CostUSD = eth * l.cfg.ETHUSD
+	}
+	return u
+}
+
+// Admit returns a *BudgetError when a task of taskType in namespace ns and
+// pipeline, -1 for none, may not start because a budget is spent. Only AI
+// and blockchain tasks are held back.
+func (l *CostLedger) Admit(taskType, ns string, pipeline int) error {
+	if !costlyTypes[taskType] {
+		return nil
+	}
+	ns = namespaceOr(ns)
+	day := l.now().UTC().Format(time.DateOnly)
+	perPipeline := l.cfg.PipelineBudgetUSD
+	if pipeline < 0 {
+		perPipeline = 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	check := func(budget, key string, limit, spent float64) error {
+		if limit <= 0 || spent < limit {
+			return nil
+		}
+		l.metrics.budgetExceeded.WithLabelValues(budget).Inc()
+		return &BudgetError{Budget: budget, Key: key, LimitUSD: limit, SpentUSD: spent}
+	}
+	return errors.Join(
+		check("daily", day, l.cfg.DailyBudgetUSD, l.daily[day]),
+		check("namespace", ns, l.budgets[ns], l.byNSDay[ns+"/"+day]),
+		check("pipeline", strconv.Itoa(pipeline), perPipeline, l.pipeline[pipeline]),
+	)
+}
+
+// Charge prices u and files it against a task, returning it priced.
+func (l *CostLedger) Charge(taskID, pipeline int, ns, taskType string, u Usage) Usage {
+	ns = namespaceOr(ns)
+	if u.Time.IsZero() {
+		u.Time = l.now()
+	}
+	u = l.price(u)
+	day := u.Time.UTC().Format(time.DateOnly)
+	l.mu.Lock()
+	l.charges = append(l.charges, charge{Task: taskID, Pipeline: pipeline, Namespace: ns, Type: taskType, Day: day, Usage: u})
+	l.daily[day] += u.CostUSD
+	l.byNSDay[ns+"/"+day] += u.CostUSD
+	l.pipeline[pipeline] += u.CostUSD
+	l.mu.Unlock()
+	l.metrics.costUSD.WithLabelValues(ns, taskType, u.Kind).Add(u.CostUSD)
+	return u
+}
+
+// CostTotal is the usage and cost rolled up under one key, as served by
+// GET /costs.
+type CostTotal struct {
+	Key              string  `json:"key"`                 // task or pipeline ID, namespace or UTC date
+	Namespace        string  `json:"namespace,omitempty"` // of a task or pipeline
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	GasWei           string  `json:"gas_wei"`
+	CostUSD          float64 `json:"cost_usd"`
+	Charges          int     `json:"charges"`
+}
+
+// Totals rolls up the charges of the namespaces keep accepts by task,
+// pipeline, namespace or day.
+func (l *CostLedger) Totals(by string, keep func(ns string) bool) ([]CostTotal, error) {
+	key := map[string]func(c charge) string{
+		"task":      func(c charge) string { return strconv.Itoa(c.Task) },
+		"pipeline":  func(c charge) string { return strconv.Itoa(c.Pipeline) },
+		"namespace": func(c charge) string { return c.Namespace },
+		"day":       func(c charge) string { return c.Day },
+	}[by]
+	if key == nil {
+		return nil, fmt.Errorf("costs: unknown rollup %q: want task, pipeline, namespace or day", by)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []CostTotal
+	index := map[string]int{}
+	gas := map[string]*big.Int{}
+	for _, c := range l.charges {
+		if !keep(c.Namespace) {
+			continue
+		}
+		k := key(c)
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			gas[k] = new(big.Int)
+			t := CostTotal{Key: k}
+			if by == "task" || by == "pipeline" {
+				t.Namespace = c.Namespace
+			}
+			out = append(out, t)
+		}
+		t := &out[i]
+		t.PromptTokens += c.PromptTokens
+		t.CompletionTokens += c.CompletionTokens
+		if wei, ok := new(big.Int).SetString(c.GasWei, 10); ok {
+			gas[k].Add(gas[k], wei)
+		}
+		t.CostUSD += c.CostUSD
+		t.Charges++
+	}
+	for i := range out {
+		out[i].GasWei = gas[out[i].Key].String()
+	}
+	if by == "namespace" || by == "day" {
+		sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	}
+	return out, nil
+}
+
+// serveCosts serves the cost totals of the visible namespaces, or of
+// ?namespace=, rolled up by ?by=, namespace by default.
+func serveCosts(l *CostLedger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		by, only := req.URL.Query().Get("by"), req.URL.Query().Get("namespace")
+		if by == "" {
+			by = "namespace"
+		}
+		out, err := l.Totals(by, func(ns string) bool { return (only == "" || ns == only) && visible(req, ns) })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if out == nil {
+			out = []CostTotal{}
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func useCosts(t *testing.T, cfg *CostConfig, namespaces map[string]NamespaceConfig) *CostLedger {
+	t.Helper()
+	old := defaultEnv.costs
+	t.Cleanup(func() { defaultEnv.costs = old })
+	l, err := NewCostLedger(cfg, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultEnv.costs = l
+	return l
+}
+
+func TestCostLedger(t *testing.T) {
+	l, err := NewCostLedger(&CostConfig{
+		Models:            map[string]ModelPrice{"gpt-4o": {PromptUSD: 2.5, CompletionUSD: 10}, "*": {PromptUSD: 1, CompletionUSD: 1}},
+		ETHUSD:            2000,
+		DailyBudgetUSD:    10,
+		PipelineBudgetUSD: 1,
+	}, map[string]NamespaceConfig{"team-a": {BudgetUSDPerDay: 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	u := l.Charge(1, 1, "team-a", "ai", Usage{Kind: UsageAI, Model: "gpt-4o", PromptTokens: 100_000, CompletionTokens: 10_000})
+	if !near(u.CostUSD, 0.35) {
+		t.Errorf("gpt-4o cost = %v", u.CostUSD)
+	}
+	if u := l.Charge(2, 1, "team-a", "ai", Usage{Kind: UsageAI, Model: "local", PromptTokens: 200_000}); !near(u.CostUSD, 0.2) {
+		t.Errorf("fallback price cost = %v", u.CostUSD)
+	}
+	u = l.Charge(3, 3, "", "blockchain", Usage{Kind: UsageGas, GasUsed: 50_000, GasPriceWei: "10000000000"})
+	if u.GasWei != "500000000000000" || !near(u.CostUSD, 1) {
+		t.Errorf("gas usage = %+v", u)
+	}
+
+	// team-a spent 0.55 of 0.5; pipeline 3 spent 1 of 1.
+	var be *BudgetError
+	if err := l.Admit("ai", "team-a", -1); !errors.As(err, &be) || be.Budget != "namespace" {
+		t.Errorf("namespace budget: %v", err)
+	}
+	if err := l.Admit("blockchain", "", 3); !errors.As(err, &be) || be.Budget != "pipeline" {
+		t.Errorf("pipeline budget: %v", err)
+	}
+	if err := errors.Join(l.Admit("storage", "team-a", 3), l.Admit("ai", "", 1)); err != nil {
+		t.Errorf("within budget: %v", err)
+	}
+	now = now.Add(24 * time.Hour)
+	if err := l.Admit("ai", "team-a", -1); err != nil {
+		t.Errorf("next day: %v", err)
+	}
+
+	all := func(string) bool { return true }
+	if got, _ := l.Totals("namespace", all); len(got) != 2 || got[0].Key != "default" || got[1].PromptTokens != 300_000 || !near(got[1].CostUSD, 0.55) {
+		t.Errorf("by namespace = %+v", got)
+	}
+	if got, _ := l.Totals("pipeline", func(ns string) bool { return ns == "default" }); len(got) != 1 || got[0].Key != "3" || got[0].GasWei != "500000000000000" {
+		t.Errorf("by pipeline = %+v", got)
+	}
+	if got, _ := l.Totals("day", all); len(got) != 1 || got[0].Key != "2026-03-01" || got[0].Charges != 3 {
+		t.Errorf("by day = %+v", got)
+	}
+	if _, err := l.Totals("week", all); err == nil {
+		t.Error("rolled up by an unknown key")
+	}
+}
+
+func TestRunnerChargesUsage(t *testing.T) {
+	useCosts(t, &CostConfig{Models: map[string]ModelPrice{"*": {PromptUSD: 1e6}}, PipelineBudgetUSD: 2}, nil)
+	reg := NewTaskRegistry()
+	r := New(Options{Store: reg, Env: defaultEnv, Logger: discard, MaxRetries: 1, Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+		ReportUsage(ctx, Usage{Kind: UsageAI, Model: "m", PromptTokens: 1})
+		if rec, _ := reg.Get(id); rec.Attempts == 1 {
+			return nil, errors.New("flaky")
+		}
+		return "ok", nil
+	})})
+	id, _ := r.Submit(context.Background(), TaskSpec{Type: "ai"})
+	rec, _ := r.Wait(id)
+	if rec.Status != "success" || len(rec.Usage) != 2 || rec.Usage[1].Attempt != 1 || rec.CostUSD != 2 {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	// The task's pipeline has spent its budget.
+	reg.Add(10, "ai")
+	reg.SetParent(10, id)
+	r.runTask(context.Background(), Task{ID: 10, Spec: TaskSpec{Type: "ai"}})
+	if rec, _ := reg.Get(10); rec.Status != "failed" || rec.Attempts != 1 || len(rec.Usage) != 0 {
+		t.Errorf("over budget: %+v", rec)
+	}
+}
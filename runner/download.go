@@ -0,0 +1,270 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DownloadOptions are the per-task settings of a download task. The payload
+// is the URL.
+type DownloadOptions struct {
+	OutputDir string            `json:"output_dir,omitempty"` // save here instead of the artifact store
+	Method    string            `json:"method,omitempty"`     // default GET
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	SHA256    string            `json:"sha256,omitempty"`    // expected digest, hex
+	MaxBytes  int64             `json:"max_bytes,omitempty"` // 0 means no limit
+	NoResume  bool              `json:"no_resume,omitempty"`
+}
+
+// DownloadOutput is the output of a download task. Files are named after
+// their SHA-256, so repeated downloads of the same content share one file.
+type DownloadOutput struct {
+	URL         string `json:"url"`
+	Path        string `json:"path"`
+	Artifact    string `json:"artifact,omitempty"` // "sha256:<digest>" when saved to the artifact store
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type,omitempty"`
+	Resumed     bool   `json:"resumed,omitempty"` // continued a partial download
+}
+
+// errTooLarge is returned when a download exceeds its MaxBytes.
+var errTooLarge = errors.New("download exceeds size limit")
+
+// partialMeta is stored next to a partial download so it is only resumed
+// against the same version of the resource.
+type partialMeta struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"` // ETag, or Last-Modified
+}
+
+// Download task: fetches the payload URL into the artifact store, or into
+// OutputDir as <sha256><ext>. The body is staged as .<key>.part and moved into
+// place once complete and verified; a failed attempt leaves the part file behind so the next
+// attempt can continue with a Range request. id is the task's ID.
+func taskDownload(ctx context.Context, id int, spec TaskSpec) (*DownloadOutput, error) {
+	var opts DownloadOptions
+	if spec.Download != nil {
+		opts = *spec.Download
+	}
+	url := spec.Payload
+	env := envOf(ctx)
+	dir := opts.OutputDir
+	if dir == "" {
+		var err error
+		if dir, err = env.artifacts.TempDir(); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// The part file is keyed by task and request, not content, so a retry
+	// finds it while other tasks fetching the same URL write their own.
+	key := sha256.Sum256([]byte(strconv.Itoa(id) + " " + method + " " + url + "\n" + opts.Body))
+	partPath := filepath.Join(dir, "."+hex.EncodeToString(key[:8])+".part")
+	metaPath := partPath + ".json"
+
+	var offset int64
+	var meta partialMeta
+	if !opts.NoResume && method == http.MethodGet {
+		offset, meta = partialState(partPath, metaPath, url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(opts.Body))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", meta.Validator)
+	}
+	resp, err := env.client(spec.HTTP).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+		// resuming
+	case resp.StatusCode >= 200 && resp.StatusCode < 300 && resp.StatusCode != http.StatusPartialContent:
+		offset = 0 // fresh body: the resource changed or ranges aren't supported
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if opts.MaxBytes > 0 && resp.ContentLength > 0 && offset+resp.ContentLength > opts.MaxBytes {
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return nil, fmt.Errorf("%w: %d bytes > %d", errTooLarge, offset+resp.ContentLength, opts.MaxBytes)
+	}
+
+	h := sha256.New()
+	part, err := openPart(partPath, offset, h)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+	if offset == 0 {
+		writeMeta(metaPath, url, resp.Header)
+	}
+
+	body := io.Reader(resp.Body)
+	if opts.MaxBytes > 0 {
+		body = io.LimitReader(resp.Body, opts.MaxBytes-offset+1)
+	}
+	n, err := io.Copy(io.MultiWriter(part, h), body)
+	size := offset + n
+	if err != nil {
+		return nil, fmt.Errorf("download interrupted after %d bytes: %w", size, err)
+	}
+	if opts.MaxBytes > 0 && size > opts.MaxBytes {
+		part.Close()
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return nil, fmt.Errorf("%w of %d bytes", errTooLarge, opts.MaxBytes)
+	}
+	if err := part.Sync(); err != nil {
+		return nil, err
+	}
+	if err := part.Close(); err != nil {
+		return nil, err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if opts.SHA256 != "" && !strings.EqualFold(opts.SHA256, digest) {
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return nil, fmt.Errorf("checksum mismatch: got sha256 %s, want %s", digest, strings.ToLower(opts.SHA256))
+	}
+
+	out := &DownloadOutput{
+		URL:         url,
+		Size:        size,
+		SHA256:      digest,
+		ContentType: resp.Header.Get("Content-Type"),
+		Resumed:     offset > 0,
+	}
+	if opts.OutputDir != "" {
+		out.Path = filepath.Join(dir, digest+fileExt(url, out.ContentType))
+		err = os.Rename(partPath, out.Path)
+	} else {
+		out.Path, out.Artifact = env.artifacts.Path(digest), "sha256:"+digest
+		_, err = env.artifacts.Adopt(partPath, digest, size, url, out.ContentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(metaPath)
+	return out, nil
+}
+
+// partialState returns how much of url is already in the part file, or 0 if
+// there is nothing that can safely be resumed.
+func partialState(partPath, metaPath, url string) (int64, partialMeta) {
+	var meta partialMeta
+	data, err := os.ReadFile(metaPath)
+	if err != nil || json.Unmarshal(data, &meta) != nil || meta.URL != url || meta.Validator == "" {
+		return 0, meta
+	}
+	st, err := os.Stat(partPath)
+	if err != nil {
+		return 0, meta
+	}
+	return st.Size(), meta
+}
+
+// openPart opens the part file for writing from offset, feeding the bytes
+// already there into h.
+func openPart(partPath string, offset int64, h hash.Hash) (*os.File, error) {
+	if offset == 0 {
+		return os.Create(partPath)
+	}
+	f, err := os.OpenFile(partPath, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func writeMeta(metaPath, url string, header http.Header) {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(metaPath)
+		return
+	}
+	data, _ := json.Marshal(partialMeta{URL: url, Validator: validator})
+	os.WriteFile(metaPath, data, 0o644)
+}
+
+// contentRangeStart parses the first byte position of a 206 response.
+func contentRangeStart(resp *http.Response) int64 {
+	cr, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(cr, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// fileExt picks a short, safe extension from the URL path or content type.
+func fileExt(url, contentType string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	if ext := path.Ext(url); len(ext) > 1 && len(ext) <= 8 && isAlnum(ext[1:]) {
+		return strings.ToLower(ext)
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+			return exts[0]
+		}
+	}
+	return ".bin"
+}
+
+func isAlnum(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
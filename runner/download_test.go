@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	content := []byte(strings.Repeat("web4 ", 1000))
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	var gotHeader, gotMethod string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader, gotMethod = r.Header.Get("X-Token"), r.Method
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	spec := TaskSpec{Type: "download", Payload: srv.URL + "/data?x=1", Download: &DownloadOptions{
+		OutputDir: dir, Method: "POST", Headers: map[string]string{"X-Token": "abc"}, SHA256: strings.ToUpper(digest),
+	}}
+	out, err := taskDownload(context.Background(), 1, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, digest+".json")
+	if out.Path != want || out.Size != int64(len(content)) || out.SHA256 != digest || gotHeader != "abc" || gotMethod != "POST" {
+		t.Fatalf("output = %+v (header %q, method %s)", out, gotHeader, gotMethod)
+	}
+	if data, _ := os.ReadFile(want); !bytes.Equal(data, content) {
+		t.Fatal("saved file differs")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("leftover files: %v", entries)
+	}
+
+	spec.Download.SHA256 = strings.Repeat("0", 64)
+	if _, err := taskDownload(context.Background(), 1, spec); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want checksum mismatch", err)
+	}
+
+	spec.Download.SHA256 = ""
+	spec.Download.MaxBytes = 100
+	if _, err := taskDownload(context.Background(), 1, spec); !errors.Is(err, errTooLarge) {
+		t.Fatalf("err = %v, want size limit", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("leftover files: %v", entries)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var calls int32
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Send half the body, then drop the connection.
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:50000])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	spec := TaskSpec{Type: "download", Payload: srv.URL + "/file.tar", Download: &DownloadOptions{OutputDir: t.TempDir()}}
+	if _, err := taskDownload(context.Background(), 1, spec); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	out, err := taskDownload(context.Background(), 1, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if !out.Resumed || out.Size != int64(len(content)) || out.SHA256 != hex.EncodeToString(sum[:]) || filepath.Ext(out.Path) != ".tar" {
+		t.Fatalf("output = %+v", out)
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=50000-" {
+		t.Fatalf("ranges = %q", ranges)
+	}
+}
+
+func TestDownloadConcurrent(t *testing.T) {
+	// Each request gets a different version of the resource.
+	bodies := [][]byte{bytes.Repeat([]byte("abcdefghij"), 10000), bytes.Repeat([]byte("0123456789"), 10000)}
+	var calls int32
+	var started sync.WaitGroup
+	started.Add(2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := bodies[atomic.AddInt32(&calls, 1)-1]
+		// Both tasks write their part files at the same time.
+		started.Done()
+		started.Wait()
+		for i := 0; i < len(content); i += 10000 {
+			w.Write(content[i : i+10000])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	spec := TaskSpec{Type: "download", Payload: srv.URL + "/same", Download: &DownloadOptions{OutputDir: dir}}
+	var wg sync.WaitGroup
+	for id := 1; id <= 2; id++ {
+		wg.Go(func() {
+			out, err := taskDownload(context.Background(), id, spec)
+			if err != nil {
+				t.Errorf("task %d: %v", id, err)
+				return
+			}
+			data, _ := os.ReadFile(out.Path)
+			if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != out.SHA256 {
+				t.Errorf("task %d: %s holds other content", id, out.Path)
+			}
+		})
+	}
+	wg.Wait()
+}
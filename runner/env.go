@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ---------------- ENVIRONMENT ----------------
+
+// Env is what tasks run against, built from one Config: the HTTP client and
+// its circuit breakers, namespace quotas, cost budgets, secrets, signers,
+// model providers, prompts, nonces, the artifact store, the webhooks, the
+// metrics and the registry of the command's tasks. Every Runner has one, so a program that embeds several
+// runners decides which of them share any of it.
+type Env struct {
+	httpClient       *http.Client
+	breakers         *BreakerSet
+	quotas           *Quotas
+	costs            *CostLedger
+	secrets          *Secrets
+	signers          map[string]Signer
+	signerNamespaces map[string][]string // limits signers to the namespaces of their config
+	llmProviders     map[string]LLMProvider
+	llmNamespaces    map[string][]string // limits providers to the namespaces of their config
+	prompts          *PromptLibrary
+	nonces           *NonceManager
+	artifacts        *ArtifactStore
+	ipfsAPI          string // storage tasks upload here; empty simulates uploads
+	notifier         *Notifier
+	metrics          *metrics
+	tasks            *TaskRegistry // the command's runs and API report here; Runners made with New have their own Store
+}
+
+// NewEnv builds the environment cfg describes; the tasks, schedulers, queue
+// and auth of cfg are not its concern. Its metrics are registered with reg
+// unless reg is nil; metrics reg already has, from another Env, are shared
+// with it.
+func NewEnv(cfg Config, reg prometheus.Registerer) (*Env, error) {
+	e := &Env{
+		breakers:         NewBreakerSet(cfg.Breakers),
+		signerNamespaces: namespacesOf(cfg.Signers),
+		llmNamespaces:    namespacesOf(cfg.LLMProviders),
+		prompts:          &PromptLibrary{Dir: "prompts"},
+		nonces:           NewNonceManager(),
+		artifacts:        NewArtifactStore("artifacts"),
+		ipfsAPI:          cfg.IPFSAPI,
+		metrics:          newMetrics(),
+		tasks:            NewTaskRegistry(),
+	}
+	var err error
+	if e.httpClient, err = newHTTPClient(cfg.HTTP, e.breakers); err != nil {
+		return nil, err
+	}
+	if e.quotas, err = NewQuotas(cfg.Namespaces); err != nil {
+		return nil, err
+	}
+	if e.costs, err = NewCostLedger(cfg.Costs, cfg.Namespaces); err != nil {
+		return nil, err
+	}
+	if e.secrets, err = loadSecrets(cfg.Secrets); err != nil {
+		return nil, err
+	}
+	if e.signers, err = loadSigners(cfg.Signers, e.httpClient); err != nil {
+		return nil, err
+	}
+	if e.llmProviders, err = loadLLMProviders(cfg.LLMProviders, e.httpClient); err != nil {
+		return nil, err
+	}
+	if e.notifier, err = NewNotifier(cfg.Webhooks, e.httpClient); err != nil {
+		return nil, err
+	}
+	if cfg.PromptsDir != "" {
+		e.prompts.Dir = cfg.PromptsDir
+	}
+	if cfg.ArtifactRoot != "" {
+		e.artifacts = NewArtifactStore(cfg.ArtifactRoot)
+	}
+	e.breakers.metrics, e.breakers.notifier = e.metrics, e.notifier
+	e.quotas.metrics, e.costs.metrics = e.metrics, e.metrics
+	if reg != nil {
+		if err := e.metrics.register(reg); err != nil {
+			return nil, fmt.Errorf("metrics: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// defaultEnv is the environment of the web4-runner command, which Setup
+// builds from its config. Runners made with New get their own.
+var defaultEnv = mustEnv(Config{})
+
+func mustEnv(cfg Config) *Env {
+	e, err := NewEnv(cfg, nil)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+type envKey struct{}
+
+// withEnv makes envOf on ctx return e.
+func withEnv(ctx context.Context, e *Env) context.Context {
+	return context.WithValue(ctx, envKey{}, e)
+}
+
+// envOf returns the environment of the Runner running the task ctx belongs
+// to, or outside a Runner the command's.
+func envOf(ctx context.Context) *Env {
+	if e, ok := ctx.Value(envKey{}).(*Env); ok {
+		return e
+	}
+	return defaultEnv
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRunnersKeepTheirEnv(t *testing.T) {
+	t.Setenv("TEAM_A_KEY", "sk-team-a-123456")
+	a, err := NewEnv(Config{Secrets: &SecretsConfig{Providers: []SecretProviderConfig{{Type: "env", Prefix: "TEAM_A_"}}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+		return spec.Payload, nil
+	})
+	ra := New(Options{Env: a, Executor: echo, Logger: discard})
+	rb := New(Options{Executor: echo, Logger: discard})
+
+	spec := TaskSpec{Type: "echo", Payload: "${secret:key}"}
+	id, _ := ra.Submit(context.Background(), spec)
+	if rec, _ := ra.Wait(id); rec.Status != "success" {
+		t.Fatalf("runner with the secret: %+v", rec)
+	}
+	id, _ = rb.Submit(context.Background(), spec)
+	if rec, _ := rb.Wait(id); rec.Status != "failed" {
+		t.Fatalf("runner without the secret: %+v", rec)
+	}
+	if rb.env == a || rb.env == defaultEnv || rb.env.quotas == defaultEnv.quotas {
+		t.Error("runner shares an environment it was not given")
+	}
+	if _, err := rb.env.secrets.Resolve(context.Background(), "${secret:key}", ""); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Resolve = %v", err)
+	}
+}
+
+func TestNewEnvSharesRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := NewEnv(Config{}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewEnv(Config{}, reg)
+	if err != nil {
+		t.Fatalf("second environment on the registry: %v", err)
+	}
+	a.metrics.taskSuccess.WithLabelValues("echo", "default").Inc()
+	b.metrics.taskSuccess.WithLabelValues("echo", "default").Inc()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n float64
+	for _, f := range families {
+		if f.GetName() == "web4_task_success_total" {
+			n = f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	if n != 2 {
+		t.Errorf("web4_task_success_total = %v, want 2", n)
+	}
+
+	// A host metric of the same name is not replaced and does not panic.
+	host := prometheus.NewRegistry()
+	host.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "web4_task_success_total", Help: "host"}))
+	if _, err := NewEnv(Config{}, host); err == nil {
+		t.Error("expected an error for a conflicting metric")
+	}
+}
@@ -0,0 +1,104 @@
+package runner_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/golang-samples/run/jobs/runner"
+)
+
+// quiet drops the runner's progress log so the examples' output is stable.
+var quiet = runner.LoggerFunc(func(taskID, attempt int, msg string) {})
+
+func Example() {
+	ctx := context.Background()
+	r := runner.New(runner.Options{
+		MaxConcurrency: 2,
+		Logger:         quiet,
+		Executor: runner.ExecutorFunc(func(ctx context.Context, id int, spec runner.TaskSpec) (interface{}, error) {
+			return strings.ToUpper(spec.Payload), nil
+		}),
+	})
+
+	id, err := r.Submit(ctx, runner.TaskSpec{Type: "shout", Payload: "hello web4"})
+	if err != nil {
+		panic(err)
+	}
+	rec, _ := r.Wait(id)
+	fmt.Println(rec.Status, rec.Output)
+
+	if err := r.Shutdown(ctx); err != nil {
+		panic(err)
+	}
+	// Output: success HELLO WEB4
+}
+
+func ExampleRunner_Subscribe() {
+	ctx := context.Background()
+	r := runner.New(runner.Options{
+		Logger: quiet,
+		Executor: runner.ExecutorFunc(func(ctx context.Context, id int, spec runner.TaskSpec) (interface{}, error) {
+			if spec.Payload == "" {
+				return nil, fmt.Errorf("empty payload")
+			}
+			return len(spec.Payload), nil
+		}),
+	})
+	events, _ := r.Subscribe(10)
+
+	a, _ := r.Submit(ctx, runner.TaskSpec{Type: "count", Payload: "abc"})
+	r.Wait(a)
+	b, _ := r.Submit(ctx, runner.TaskSpec{Type: "count"})
+	r.Wait(b)
+	r.Shutdown(ctx)
+
+	for ev := range events {
+		switch {
+		case ev.Task != nil && ev.Task.Error != "":
+			fmt.Println(ev.Type, ev.Task.ID, ev.Task.Error)
+		case ev.Task != nil:
+			fmt.Println(ev.Type, ev.Task.ID, ev.Task.Output)
+		default:
+			fmt.Println(ev.Type, ev.Run.Succeeded, ev.Run.Failed)
+		}
+	}
+	// Output:
+	// task.succeeded 1 3
+	// task.failed 2 empty payload
+	// task.dead_lettered 2 empty payload
+	// run.finished 1 1
+}
+
+// A custom executor can add task types and leave the rest to the built-in
+// ones.
+func ExampleBuiltinExecutor() {
+	ctx := context.Background()
+	store := runner.NewTaskRegistry()
+	builtin := runner.BuiltinExecutor(store)
+	r := runner.New(runner.Options{
+		Store:  store,
+		Logger: quiet,
+		Executor: runner.ExecutorFunc(func(ctx context.Context, id int, spec runner.TaskSpec) (interface{}, error) {
+			if spec.Type == "greet" {
+				return "hello " + spec.Payload, nil
+			}
+			return builtin.Execute(ctx, id, spec)
+		}),
+	})
+
+	greet, _ := r.Submit(ctx, runner.TaskSpec{Type: "greet", Payload: "runner"})
+	other, _ := r.Submit(ctx, runner.TaskSpec{Type: "teleport"})
+	for _, id := range []int{greet, other} {
+		rec, _ := r.Wait(id)
+		if rec.Error != "" {
+			fmt.Printf("%s: %s: %s\n", rec.Type, rec.Status, rec.Error)
+		} else {
+			fmt.Printf("%s: %s: %v\n", rec.Type, rec.Status, rec.Output)
+		}
+	}
+	r.Shutdown(ctx)
+	// Output:
+	// greet: success: hello runner
+	// teleport: failed: unknown task type teleport
+}
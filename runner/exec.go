@@ -0,0 +1,228 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecOptions are the per-task settings of an exec task. The payload is the
+// program to run.
+type ExecOptions struct {
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	InheritEnv bool              `json:"inherit_env,omitempty"` // pass the runner's environment; otherwise only PATH, HOME and Env
+	Dir        string            `json:"dir,omitempty"`
+	TempDir    bool              `json:"temp_dir,omitempty"` // run in a fresh directory that is removed afterwards
+	Stdin      string            `json:"stdin,omitempty"`
+
+	Timeout        Duration `json:"timeout,omitempty"`          // wall clock, default 10m
+	CPUSeconds     int      `json:"cpu_seconds,omitempty"`      // CPU time limit
+	MemoryMB       int      `json:"memory_mb,omitempty"`        // address space limit
+	MaxOutputBytes int      `json:"max_output_bytes,omitempty"` // stdout+stderr, default 1 MiB; the command is killed beyond it
+
+	SuccessCodes []int `json:"success_codes,omitempty"` // default [0]
+}
+
+// ExecOutput is the output of an exec task.
+type ExecOutput struct {
+	ExitCode int     `json:"exit_code"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// ExitCodeError is returned when the command exits with a code not listed
+// in SuccessCodes.
+type ExitCodeError struct {
+	Code   int
+	Stderr string // last line, for the error message
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("command exited with code %d: %s", e.Code, e.Stderr)
+	}
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+var errOutputLimit = errors.New("command output exceeds limit")
+
+const (
+	defaultExecTimeout   = 10 * time.Minute
+	defaultExecOutputCap = 1 << 20
+)
+
+// Exec task: runs the payload program. Every line it writes to stdout or
+// stderr is passed to onLine as it appears.
+func taskExec(ctx context.Context, spec TaskSpec, onLine func(stream, line string)) (*ExecOutput, error) {
+	var opts ExecOptions
+	if spec.Exec != nil {
+		opts = *spec.Exec
+	}
+	if spec.Payload == "" {
+		return nil, fmt.Errorf("exec task: no command")
+	}
+	dir := opts.Dir
+	if opts.TempDir {
+		tmp, err := os.MkdirTemp("", "web4-exec-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	timeout := time.Duration(opts.Timeout)
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd, err := limitedCommand(ctx, opts.CPUSeconds, opts.MemoryMB, spec.Payload, opts.Args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = dir
+	cmd.Env = execEnv(opts, dir)
+	cmd.Stdin = strings.NewReader(opts.Stdin)
+	cmd.WaitDelay = 5 * time.Second
+
+	limit := opts.MaxOutputBytes
+	if limit <= 0 {
+		limit = defaultExecOutputCap
+	}
+	capture := &outputCapture{limit: limit, onLine: onLine, kill: cancel}
+	stdout, stderr := capture.stream("stdout"), capture.stream("stderr")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	start := time.Now()
+	runErr := cmd.Run()
+	if cmd.ProcessState == nil {
+		return nil, fmt.Errorf("exec task: %w", runErr)
+	}
+	stdout.flush()
+	stderr.flush()
+	out := &ExecOutput{
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Stdout:   stdout.buf.String(),
+		Stderr:   stderr.buf.String(),
+		Duration: time.Since(start).Seconds(),
+	}
+
+	switch {
+	case capture.exceeded:
+		return out, fmt.Errorf("%w of %d bytes", errOutputLimit, limit)
+	case ctx.Err() == context.DeadlineExceeded:
+		return out, fmt.Errorf("command timed out after %s", timeout)
+	case ctx.Err() != nil:
+		return out, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return out, fmt.Errorf("exec task: %w", runErr)
+	}
+	codes := opts.SuccessCodes
+	if len(codes) == 0 {
+		codes = []int{0}
+	}
+	if out.ExitCode < 0 || !slices.Contains(codes, out.ExitCode) {
+		return out, &ExitCodeError{Code: out.ExitCode, Stderr: lastLine(out.Stderr)}
+	}
+	return out, nil
+}
+
+func execEnv(opts ExecOptions, dir string) []string {
+	var env []string
+	if opts.InheritEnv {
+		env = os.Environ()
+	} else {
+		env = []string{"PATH=" + os.Getenv("PATH")}
+		if dir != "" {
+			env = append(env, "HOME="+dir)
+		}
+	}
+	for k, v := range opts.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\n")
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	if len(s) > 200 {
+		s = s[:200]
+	}
+	return s
+}
+
+// outputCapture keeps stdout and stderr under one shared size limit and
+// kills the command once the limit is passed.
+type outputCapture struct {
+	mu       sync.Mutex
+	limit    int
+	used     int
+	exceeded bool
+	onLine   func(stream, line string)
+	kill     func()
+}
+
+type streamWriter struct {
+	c       *outputCapture
+	name    string
+	buf     bytes.Buffer
+	pending []byte // incomplete last line
+}
+
+func (c *outputCapture) stream(name string) *streamWriter {
+	return &streamWriter{c: c, name: name}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	c := w.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(p)
+	if room := c.limit - c.used; len(p) > room {
+		p = p[:max(room, 0)]
+		if !c.exceeded {
+			c.exceeded = true
+			c.kill()
+		}
+	}
+	c.used += len(p)
+	w.buf.Write(p)
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if c.onLine != nil {
+			c.onLine(w.name, string(w.pending[:i]))
+		}
+		w.pending = w.pending[i+1:]
+	}
+	return n, nil
+}
+
+// flush reports a final line without a trailing newline.
+func (w *streamWriter) flush() {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	if len(w.pending) > 0 && w.c.onLine != nil {
+		w.c.onLine(w.name, string(w.pending))
+	}
+	w.pending = nil
+}
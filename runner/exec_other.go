@@ -0,0 +1,17 @@
+//go:build !unix
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// limitedCommand runs name directly. Resource limits need a unix host.
+func limitedCommand(ctx context.Context, cpuSeconds, memoryMB int, name string, args ...string) (*exec.Cmd, error) {
+	if cpuSeconds > 0 || memoryMB > 0 {
+		return nil, fmt.Errorf("exec task: cpu and memory limits are only supported on unix")
+	}
+	return exec.CommandContext(ctx, name, args...), nil
+}
@@ -0,0 +1,88 @@
+//go:build unix
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sh(script string, opts ExecOptions) TaskSpec {
+	opts.Args = []string{"-c", script}
+	return TaskSpec{Type: "exec", Payload: "/bin/sh", Exec: &opts}
+}
+
+func TestExecTask(t *testing.T) {
+	lines := map[string][]string{}
+	onLine := func(stream, line string) { lines[stream] = append(lines[stream], line) }
+
+	out, err := taskExec(context.Background(), sh(`read name; echo "hello $name from $GREETING"; echo warn >&2; printf tail`, ExecOptions{
+		Stdin: "web4\n",
+		Env:   map[string]string{"GREETING": "exec"},
+	}), onLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ExitCode != 0 || out.Stdout != "hello web4 from exec\ntail" || out.Stderr != "warn\n" {
+		t.Fatalf("output = %+v", out)
+	}
+	if strings.Join(lines["stdout"], "|") != "hello web4 from exec|tail" || strings.Join(lines["stderr"], "|") != "warn" {
+		t.Fatalf("lines = %q", lines)
+	}
+
+	_, err = taskExec(context.Background(), sh("echo boom >&2; exit 3", ExecOptions{}), nil)
+	var ee *ExitCodeError
+	if !errors.As(err, &ee) || ee.Code != 3 || ee.Stderr != "boom" {
+		t.Fatalf("err = %v, want exit code 3", err)
+	}
+	if _, err := taskExec(context.Background(), sh("exit 3", ExecOptions{SuccessCodes: []int{0, 3}}), nil); err != nil {
+		t.Fatalf("exit 3 with success_codes: %v", err)
+	}
+	if _, err := taskExec(context.Background(), TaskSpec{Type: "exec", Payload: "/nonexistent/cmd"}, nil); err == nil {
+		t.Fatal("expected error for a missing command")
+	}
+}
+
+func TestExecTaskEnvAndTempDir(t *testing.T) {
+	t.Setenv("WEB4_RUNNER_SECRET", "s3cret")
+	out, err := taskExec(context.Background(), sh(`echo "[$WEB4_RUNNER_SECRET]"; pwd; touch made`, ExecOptions{TempDir: true}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.Stdout), "\n")
+	if lines[0] != "[]" {
+		t.Fatalf("runner env leaked: %q", lines[0])
+	}
+	if _, err := os.Stat(filepath.Join(lines[1], "made")); !os.IsNotExist(err) {
+		t.Fatalf("temp dir %s not removed", lines[1])
+	}
+
+	out, _ = taskExec(context.Background(), sh(`echo "[$WEB4_RUNNER_SECRET]"`, ExecOptions{InheritEnv: true}), nil)
+	if out.Stdout != "[s3cret]\n" {
+		t.Fatalf("inherit_env stdout = %q", out.Stdout)
+	}
+}
+
+func TestExecTaskLimits(t *testing.T) {
+	start := time.Now()
+	_, err := taskExec(context.Background(), sh("sleep 30 & wait", ExecOptions{Timeout: Duration(200 * time.Millisecond)}), nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") || time.Since(start) > 5*time.Second {
+		t.Fatalf("err = %v after %s, want timeout", err, time.Since(start))
+	}
+
+	out, err := taskExec(context.Background(), sh("yes web4", ExecOptions{MaxOutputBytes: 1000}), nil)
+	if !errors.Is(err, errOutputLimit) || len(out.Stdout) != 1000 {
+		t.Fatalf("err = %v, stdout %d bytes", err, len(out.Stdout))
+	}
+
+	_, err = taskExec(context.Background(), sh("while :; do :; done", ExecOptions{CPUSeconds: 1, Timeout: Duration(20 * time.Second)}), nil)
+	var ee *ExitCodeError
+	if !errors.As(err, &ee) {
+		t.Fatalf("err = %v, want the CPU limit to kill the command", err)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// limitedCommand runs name in its own process group, so a timeout kills
+// everything it started. CPU and memory limits are applied with ulimit in a
+// wrapping shell, which then execs the command.
+func limitedCommand(ctx context.Context, cpuSeconds, memoryMB int, name string, args ...string) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if cpuSeconds > 0 || memoryMB > 0 {
+		script := ""
+		if cpuSeconds > 0 {
+			script += fmt.Sprintf("ulimit -t %d || exit 126; ", cpuSeconds)
+		}
+		if memoryMB > 0 {
+			script += fmt.Sprintf("ulimit -v %d || exit 126; ", memoryMB*1024)
+		}
+		script += `exec "$@"`
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, "sh", name}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd, nil
+}
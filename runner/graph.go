@@ -0,0 +1,239 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------------- GRAPH EXPORT ----------------
+
+// Graph is a task tree as nodes and edges, either as defined by a config or
+// as a run executed it.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is one task. Status, Attempts and Duration are set for tasks of
+// a run.
+type GraphNode struct {
+	ID       int     `json:"id"`
+	Type     string  `json:"type"`
+	Payload  string  `json:"payload,omitempty"`
+	Wave     int     `json:"wave,omitempty"`
+	Status   string  `json:"status,omitempty"`
+	Attempts int     `json:"attempts,omitempty"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// GraphEdge leads from a task to a follow-up it starts when it succeeds.
+type GraphEdge struct {
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	Rule      string `json:"rule,omitempty"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// Graph formats.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+	GraphJSON    = "json"
+)
+
+// PlanGraph is the graph of a pipeline definition, expanded by MakePlan.
+// Edges to tasks generated by rules carry the rule and its condition.
+func PlanGraph(p *Plan) *Graph {
+	g := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, s := range p.Steps {
+		g.Nodes = append(g.Nodes, GraphNode{ID: s.ID, Type: s.Type, Payload: s.Payload, Wave: s.Wave})
+		if s.Parent != 0 {
+			g.Edges = append(g.Edges, GraphEdge{From: s.Parent, To: s.ID, Rule: s.Rule, Condition: s.Condition})
+		}
+	}
+	return g
+}
+
+// RunGraph is the graph of a run's tasks, with their status and how long
+// they took from the first attempt to the last.
+func RunGraph(recs []TaskRecord) *Graph {
+	g := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, rec := range recs {
+		n := GraphNode{ID: rec.ID, Type: rec.Type, Status: rec.Status, Attempts: rec.Attempts, Error: rec.Error}
+		if !rec.Started.IsZero() && !rec.Finished.IsZero() {
+			n.Duration = rec.Finished.Sub(rec.Started).Round(time.Millisecond).Seconds()
+		}
+		g.Nodes = append(g.Nodes, n)
+		if rec.Parent != 0 {
+			g.Edges = append(g.Edges, GraphEdge{From: rec.Parent, To: rec.ID})
+		}
+	}
+	return g
+}
+
+// Write renders the graph as Graphviz DOT, a Mermaid flowchart or JSON.
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		_, err := io.WriteString(w, g.DOT())
+		return err
+	case GraphMermaid:
+		_, err := io.WriteString(w, g.Mermaid())
+		return err
+	case GraphJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	default:
+		return fmt.Errorf("unknown graph format %q: want dot, mermaid or json", format)
+	}
+}
+
+// statusColors fill the nodes of a run by status.
+var statusColors = map[string]string{
+	"pending": "#eeeeee",
+	"running": "#fff59d",
+	"success": "#c8e6c9",
+	"failed":  "#ffcdd2",
+}
+
+// label is a node's text: its ID and type, then its payload or, for a run,
+// how it went.
+func (n GraphNode) label() []string {
+	lines := []string{fmt.Sprintf("#%d %s", n.ID, n.Type)}
+	if n.Payload != "" {
+		lines = append(lines, shorten(n.Payload, 40))
+	}
+	if n.Status != "" {
+		s := n.Status
+		if n.Duration > 0 {
+			s += fmt.Sprintf(" in %s", time.Duration(n.Duration*float64(time.Second)))
+		}
+		if n.Attempts > 1 {
+			s += fmt.Sprintf(", %d attempts", n.Attempts)
+		}
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func (e GraphEdge) label() string {
+	var parts []string
+	if e.Rule != "" {
+		parts = append(parts, e.Rule)
+	}
+	if e.Condition != "" {
+		parts = append(parts, "if "+e.Condition)
+	}
+	return strings.Join(parts, ", ")
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// DOT renders the graph for Graphviz.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\", fontname=\"Helvetica\"];\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  t%d [label=\"%s\"", n.ID, dotEscaper.Replace(strings.Join(n.label(), "\n")))
+		if c, ok := statusColors[n.Status]; ok {
+			fmt.Fprintf(&b, ", fillcolor=%q", c)
+		}
+		b.WriteString("];\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  t%d -> t%d", e.From, e.To)
+		if l := e.label(); l != "" {
+			fmt.Fprintf(&b, " [label=\"%s\", style=dashed]", dotEscaper.Replace(l))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", "<br/>")
+
+// Mermaid renders the graph as a Mermaid flowchart.
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  t%d[\"%s\"]\n", n.ID, mermaidEscaper.Replace(strings.Join(n.label(), "\n")))
+	}
+	for _, e := range g.Edges {
+		if l := e.label(); l != "" {
+			fmt.Fprintf(&b, "  t%d -.->|\"%s\"| t%d\n", e.From, mermaidEscaper.Replace(l), e.To)
+		} else {
+			fmt.Fprintf(&b, "  t%d --> t%d\n", e.From, e.To)
+		}
+	}
+	for _, status := range []string{"pending", "running", "success", "failed"} {
+		var ids []string
+		for _, n := range g.Nodes {
+			if n.Status == status {
+				ids = append(ids, fmt.Sprintf("t%d", n.ID))
+			}
+		}
+		if len(ids) > 0 {
+			fmt.Fprintf(&b, "  classDef %s fill:%s\n", status, statusColors[status])
+			fmt.Fprintf(&b, "  class %s %s\n", strings.Join(ids, ","), status)
+		}
+	}
+	return b.String()
+}
+
+// graphContentTypes are the response types of the graph endpoint.
+var graphContentTypes = map[string]string{
+	GraphDOT:     "text/vnd.graphviz; charset=utf-8",
+	GraphMermaid: "text/plain; charset=utf-8",
+	GraphJSON:    "application/json",
+}
+
+// serveGraph renders the run's tasks with GET, or the pipeline definition
+// posted as a config with POST, in the format of ?format=, JSON by default.
+// ?mode= picks the run mode of a posted config.
+func serveGraph(r *TaskRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = GraphJSON
+		}
+		contentType, ok := graphContentTypes[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown graph format %q", format), http.StatusBadRequest)
+			return
+		}
+
+		var recs []TaskRecord
+		for _, rec := range r.List() {
+			if visible(req, rec.Namespace) {
+				recs = append(recs, rec)
+			}
+		}
+		g := RunGraph(recs)
+		if req.Method == http.MethodPost {
+			var cfg Config
+			if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&cfg); err != nil {
+				http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			cfg.PromptsDir = "" // a graph has no prompts; don't read files the caller names
+			p, err := MakePlan(cfg, req.URL.Query().Get("mode"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			g = PlanGraph(p)
+		}
+		w.Header().Set("Content-Type", contentType)
+		g.Write(w, format)
+	}
+}
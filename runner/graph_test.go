@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunGraph(t *testing.T) {
+	reg := useRegistry(t)
+	roots := []PipelineTask{
+		{ID: 1, TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/a.txt"}, Next: []PipelineTask{
+			{ID: 2, TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/b.txt"}},
+			{ID: 3, TaskSpec: TaskSpec{Type: "teleport"}},
+		}},
+	}
+	RunTree(context.Background(), roots, 2, 0, 0, StaticNext)
+
+	g := RunGraph(reg.List())
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 || g.Edges[1] != (GraphEdge{From: 1, To: 3}) {
+		t.Fatalf("graph = %+v", g)
+	}
+	if n := g.Nodes[0]; n.Status != "success" || n.Duration <= 0 {
+		t.Errorf("node = %+v", n)
+	}
+
+	dot := g.DOT()
+	for _, want := range []string{`t1 -> t2;`, `t3 [label="#3 teleport\nfailed", fillcolor="#ffcdd2"];`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT lacks %q:\n%s", want, dot)
+		}
+	}
+	mermaid := g.Mermaid()
+	for _, want := range []string{"flowchart LR\n", "  t1 --> t3\n", "  class t1,t2 success\n", "  class t3 failed\n"} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Mermaid lacks %q:\n%s", want, mermaid)
+		}
+	}
+}
+
+func TestGraphEndpoint(t *testing.T) {
+	srv := httptest.NewServer(newAPIHandler(newAPIRunner(NewTaskRegistry(), defaultEnv, 0, 0), NewArtifactStore(t.TempDir())))
+	defer srv.Close()
+
+	cfg := `{"pipeline": [{"id": 1, "type": "ai", "payload": "Say \"hi\""}],
+		"rules": [{"after": "ai", "when": "^0x", "then": [{"type": "storage", "payload": "{{.Output}}"}]}],
+		"max_depth": 2}`
+	resp, err := http.Post(srv.URL+"/graph?format=mermaid", "application/json", strings.NewReader(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want := "flowchart LR\n" +
+		"  t1[\"#1 ai<br/>Say #quot;hi#quot;\"]\n" +
+		"  t2[\"#2 storage<br/><output of task 1>\"]\n" +
+		"  t1 -.->|\"rule 0, if ^0x\"| t2\n"
+	if resp.StatusCode != http.StatusOK || string(body) != want {
+		t.Fatalf("POST /graph = %d\n%s", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(srv.URL + "/graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g Graph
+	err = json.NewDecoder(resp.Body).Decode(&g)
+	resp.Body.Close()
+	if err != nil || g.Nodes == nil || len(g.Nodes) != 0 {
+		t.Errorf("GET /graph = %+v, %v", g, err)
+	}
+
+	resp, _ = http.Get(srv.URL + "/graph?format=svg")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: status %d", resp.StatusCode)
+	}
+}
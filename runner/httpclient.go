@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPConfig configures the HTTP client shared by every task that makes
+// outbound calls: downloads, model providers, RPC nodes and remote signers.
+type HTTPConfig struct {
+	Timeout               Duration `json:"timeout,omitempty"`                 // whole request incl. body, default none (tasks are bounded by their context)
+	DialTimeout           Duration `json:"dial_timeout,omitempty"`            // default 10s
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitempty"`   // default 10s
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitempty"` // default none
+	IdleConnTimeout       Duration `json:"idle_conn_timeout,omitempty"`       // default 90s
+	MaxIdleConns          int      `json:"max_idle_conns,omitempty"`          // default 100
+	MaxIdleConnsPerHost   int      `json:"max_idle_conns_per_host,omitempty"` // default 10
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitempty"`      // default unlimited
+
+	// Proxy is an http:// or https:// proxy URL. Without it the usual
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables apply.
+	Proxy string `json:"proxy,omitempty"`
+
+	CAFile             string `json:"ca_file,omitempty"`   // PEM bundle trusted in addition to the system roots
+	CertFile           string `json:"cert_file,omitempty"` // client certificate for mTLS
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	UserAgent string `json:"user_agent,omitempty"` // default "web4-job-runner"
+}
+
+// HTTPOverrides are the per-task client settings. They reuse the shared
+// connection pool.
+type HTTPOverrides struct {
+	Timeout   Duration `json:"timeout,omitempty"`
+	UserAgent string   `json:"user_agent,omitempty"`
+}
+
+const defaultUserAgent = "web4-job-runner"
+
+// newHTTPClient builds the client of an Env, whose calls pass breakers.
+func newHTTPClient(c HTTPConfig, breakers *BreakerSet) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("http ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("http ca_file %s: no certificates found", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("http client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("http proxy %q: must be an http:// or https:// URL", redactURL(c.Proxy))
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   orDefault(c.DialTimeout, 10*time.Second),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   orDefault(c.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: time.Duration(c.ResponseHeaderTimeout),
+		IdleConnTimeout:       orDefault(c.IdleConnTimeout, 90*time.Second),
+		MaxIdleConns:          intOrDefault(c.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   intOrDefault(c.MaxIdleConnsPerHost, 10),
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	return &http.Client{
+		Transport: userAgentTransport{base: breakerTransport{base: transport, set: breakers}, userAgent: userAgent},
+		Timeout:   time.Duration(c.Timeout),
+	}, nil
+}
+
+// client applies a task's overrides to the environment's client.
+func (e *Env) client(o *HTTPOverrides) *http.Client {
+	if o == nil {
+		return e.httpClient
+	}
+	client := *e.httpClient
+	if o.Timeout > 0 {
+		client.Timeout = time.Duration(o.Timeout)
+	}
+	if o.UserAgent != "" {
+		base := client.Transport
+		if ua, ok := base.(userAgentTransport); ok {
+			base = ua.base
+		}
+		client.Transport = userAgentTransport{base: base, userAgent: o.UserAgent}
+	}
+	return &client
+}
+
+// userAgentTransport sets the User-Agent on requests that don't have one.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func orDefault(d Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
+
+func intOrDefault(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// String keeps proxy credentials out of logs.
+func (c HTTPConfig) String() string {
+	type plain HTTPConfig
+	p := plain(c)
+	if p.Proxy != "" {
+		p.Proxy = redactURL(p.Proxy)
+	}
+	return fmt.Sprintf("%+v", p)
+}
@@ -0,0 +1,296 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// HTTPTaskOptions are the per-task settings of an http task. The payload is
+// the URL.
+type HTTPTaskOptions struct {
+	Method  string            `json:"method,omitempty"` // default GET, or POST with a body
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a Go template rendered with Vars; {{json .x}} writes x as JSON.
+	// JSON bodies get Content-Type application/json unless a header says
+	// otherwise.
+	Body         string                 `json:"body,omitempty"`
+	Vars         map[string]interface{} `json:"vars,omitempty"`
+	Auth         *HTTPAuth              `json:"auth,omitempty"`
+	Expect       HTTPExpect             `json:"expect,omitempty"`
+	Extract      map[string]string      `json:"extract,omitempty"`        // output field -> JSON path into the response body
+	MaxBodyBytes int64                  `json:"max_body_bytes,omitempty"` // default 10 MiB
+}
+
+// HTTPAuth authenticates the request. Credentials are read from env vars,
+// or given as ${secret:name} references, so they never appear in
+// CONFIG_JSON.
+type HTTPAuth struct {
+	Type        string `json:"type"`                   // bearer, basic or hmac
+	TokenEnv    string `json:"token_env,omitempty"`    // bearer
+	Token       string `json:"token,omitempty"`        // bearer, instead of TokenEnv
+	Username    string `json:"username,omitempty"`     // basic
+	PasswordEnv string `json:"password_env,omitempty"` // basic
+	Password    string `json:"password,omitempty"`     // basic, instead of PasswordEnv
+	SecretEnv   string `json:"secret_env,omitempty"`   // hmac: signs the body with HMAC-SHA256
+	Secret      string `json:"secret,omitempty"`       // hmac, instead of SecretEnv
+	Header      string `json:"header,omitempty"`       // hmac: default X-Signature
+}
+
+// HTTPExpect decides whether the response counts as success.
+type HTTPExpect struct {
+	Status  []int             `json:"status,omitempty"`  // default any 2xx
+	Headers map[string]string `json:"headers,omitempty"` // header -> regexp the value must match
+	JSON    []JSONAssertion   `json:"json,omitempty"`
+}
+
+// JSONAssertion checks one value of the JSON response body. Without Equals or
+// Matches it only requires the path to exist.
+type JSONAssertion struct {
+	Path    string      `json:"path"`
+	Equals  interface{} `json:"equals,omitempty"`
+	Matches string      `json:"matches,omitempty"` // regexp on the value's string form
+	Absent  bool        `json:"absent,omitempty"`  // the path must not exist
+}
+
+// HTTPTaskOutput is the output of an http task. Fields holds the extracted
+// values for downstream steps.
+type HTTPTaskOutput struct {
+	Status  int                    `json:"status"`
+	Headers map[string]string      `json:"headers,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	Body    string                 `json:"body,omitempty"` // first KiB, for non-JSON responses
+}
+
+// AssertionError lists every expectation the response failed.
+type AssertionError struct {
+	Status   int
+	Failures []string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("http status %d failed assertions: %s", e.Status, strings.Join(e.Failures, "; "))
+}
+
+const defaultMaxBodyBytes = 10 << 20
+
+// HTTP task: calls the payload URL and checks the response against Expect.
+func taskHTTP(ctx context.Context, spec TaskSpec) (*HTTPTaskOutput, error) {
+	var opts HTTPTaskOptions
+	if spec.Request != nil {
+		opts = *spec.Request
+	}
+	body, err := renderBody(opts.Body, opts.Vars)
+	if err != nil {
+		return nil, err
+	}
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+		if body != "" {
+			method = http.MethodPost
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, spec.Payload, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("http task: %w", err)
+	}
+	if body != "" && json.Valid([]byte(body)) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if err := applyAuth(req, opts.Auth, []byte(body)); err != nil {
+		return nil, err
+	}
+
+	resp, err := envOf(ctx).client(spec.HTTP).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http task: %w", err)
+	}
+	defer resp.Body.Close()
+	limit := opts.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("http task: reading response: %w", err)
+	}
+	if int64(len(raw)) > limit {
+		return nil, fmt.Errorf("http task: response larger than %d bytes", limit)
+	}
+
+	out := &HTTPTaskOutput{Status: resp.StatusCode, Headers: map[string]string{}}
+	for k := range resp.Header {
+		out.Headers[k] = resp.Header.Get(k)
+	}
+	var doc interface{}
+	isJSON := json.Unmarshal(raw, &doc) == nil
+	if !isJSON {
+		out.Body = string(raw[:min(len(raw), 1024)])
+	}
+
+	failures := checkResponse(resp, opts.Expect, doc, isJSON)
+	if len(opts.Extract) > 0 {
+		out.Fields = map[string]interface{}{}
+		for _, name := range slices.Sorted(maps.Keys(opts.Extract)) {
+			path := opts.Extract[name]
+			p, err := parseJSONPath(path)
+			if err != nil {
+				return out, err
+			}
+			if v, ok := p.Lookup(doc); ok && isJSON {
+				out.Fields[name] = v
+			} else {
+				failures = append(failures, fmt.Sprintf("extract %s: %s not found", name, path))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return out, &AssertionError{Status: resp.StatusCode, Failures: failures}
+	}
+	return out, nil
+}
+
+func renderBody(body string, vars map[string]interface{}) (string, error) {
+	if body == "" || !strings.Contains(body, "{{") {
+		return body, nil
+	}
+	tmpl, err := template.New("body").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("http task body: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("http task body: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func applyAuth(req *http.Request, a *HTTPAuth, body []byte) error {
+	if a == nil {
+		return nil
+	}
+	secret := func(env, value string) (string, error) {
+		if value != "" {
+			return value, nil
+		}
+		if env == "" {
+			return "", fmt.Errorf("http auth %s: env var not set in config", a.Type)
+		}
+		v := os.Getenv(env)
+		if v == "" {
+			return "", fmt.Errorf("http auth %s: %s is empty", a.Type, env)
+		}
+		return v, nil
+	}
+	switch a.Type {
+	case "bearer":
+		token, err := secret(a.TokenEnv, a.Token)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		password, err := secret(a.PasswordEnv, a.Password)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(a.Username, password)
+	case "hmac":
+		key, err := secret(a.SecretEnv, a.Secret)
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		header := a.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		req.Header.Set(header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	default:
+		return fmt.Errorf("unknown http auth type %q", a.Type)
+	}
+	return nil
+}
+
+func checkResponse(resp *http.Response, e HTTPExpect, doc interface{}, isJSON bool) []string {
+	var failures []string
+	if len(e.Status) > 0 {
+		if !slices.Contains(e.Status, resp.StatusCode) {
+			failures = append(failures, fmt.Sprintf("status %d not in %v", resp.StatusCode, e.Status))
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		failures = append(failures, fmt.Sprintf("status %d is not 2xx", resp.StatusCode))
+	}
+	for _, name := range slices.Sorted(maps.Keys(e.Headers)) {
+		pattern := e.Headers[name]
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("header %s: bad pattern: %v", name, err))
+		} else if v := resp.Header.Get(name); !re.MatchString(v) {
+			failures = append(failures, fmt.Sprintf("header %s = %q does not match %q", name, v, pattern))
+		}
+	}
+	if len(e.JSON) > 0 && !isJSON {
+		return append(failures, "response body is not JSON")
+	}
+	for _, a := range e.JSON {
+		if msg := a.check(doc); msg != "" {
+			failures = append(failures, msg)
+		}
+	}
+	return failures
+}
+
+func (a JSONAssertion) check(doc interface{}) string {
+	p, err := parseJSONPath(a.Path)
+	if err != nil {
+		return err.Error()
+	}
+	v, found := p.Lookup(doc)
+	switch {
+	case a.Absent:
+		if found {
+			return fmt.Sprintf("%s should be absent, got %s", a.Path, compactJSON(v))
+		}
+		return ""
+	case !found:
+		return a.Path + " not found"
+	case a.Equals != nil && !jsonEqual(a.Equals, v):
+		return fmt.Sprintf("%s = %s, want %s", a.Path, compactJSON(v), compactJSON(a.Equals))
+	case a.Matches != "":
+		re, err := regexp.Compile(a.Matches)
+		if err != nil {
+			return fmt.Sprintf("%s: bad pattern: %v", a.Path, err)
+		}
+		s, ok := v.(string)
+		if !ok {
+			s = compactJSON(v)
+		}
+		if !re.MatchString(s) {
+			return fmt.Sprintf("%s = %q does not match %q", a.Path, s, a.Matches)
+		}
+	}
+	return ""
+}
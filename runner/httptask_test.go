@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"data": {"items": [{"id": 1}, {"id": 2}], "odd key": true}}`), &doc)
+	for path, want := range map[string]interface{}{
+		"$.data.items[0].id":    float64(1),
+		"$.data.items[-1].id":   float64(2),
+		"$.data.items[*].id":    []interface{}{float64(1), float64(2)},
+		"$['data']['odd key']":  true,
+		`$.data["items"][1].id`: float64(2),
+	} {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := p.Lookup(doc); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v (%v), want %v", path, got, ok, want)
+		}
+	}
+	if p, _ := parseJSONPath("$.data.missing"); p != nil {
+		if _, ok := p.Lookup(doc); ok {
+			t.Error("missing path found")
+		}
+	}
+	for _, bad := range []string{"data.items", "$.data[", "$.items[x]"} {
+		if _, err := parseJSONPath(bad); err == nil {
+			t.Errorf("parseJSONPath(%q): expected error", bad)
+		}
+	}
+}
+
+func TestHTTPTask(t *testing.T) {
+	var gotBody, gotAuth, gotSig, gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody, gotAuth, gotSig, gotType = string(b), r.Header.Get("Authorization"), r.Header.Get("X-Hub-Signature"), r.Header.Get("Content-Type")
+		w.Header().Set("X-Request-Id", "req-42")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"job": {"id": "j-9", "state": "queued"}, "links": [{"href": "/jobs/j-9"}]}`)
+	}))
+	defer srv.Close()
+	t.Setenv("TEST_API_TOKEN", "tok")
+	t.Setenv("TEST_HMAC_SECRET", "key")
+
+	spec := TaskSpec{Type: "http", Payload: srv.URL + "/jobs", Request: &HTTPTaskOptions{
+		Body: `{"name": {{json .name}}, "count": {{.count}}}`,
+		Vars: map[string]interface{}{"name": `summary "v2"`, "count": 3},
+		Auth: &HTTPAuth{Type: "bearer", TokenEnv: "TEST_API_TOKEN"},
+		Expect: HTTPExpect{
+			Status:  []int{201},
+			Headers: map[string]string{"X-Request-Id": "^req-"},
+			JSON:    []JSONAssertion{{Path: "$.job.state", Equals: "queued"}, {Path: "$.job.id", Matches: "^j-"}, {Path: "$.error", Absent: true}},
+		},
+		Extract: map[string]string{"job_id": "$.job.id", "hrefs": "$.links[*].href"},
+	}}
+	out, err := taskHTTP(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotBody != `{"name": "summary \"v2\"", "count": 3}` || gotAuth != "Bearer tok" || gotType != "application/json" {
+		t.Fatalf("request body %q auth %q type %q", gotBody, gotAuth, gotType)
+	}
+	if out.Status != 201 || out.Fields["job_id"] != "j-9" || !reflect.DeepEqual(out.Fields["hrefs"], []interface{}{"/jobs/j-9"}) {
+		t.Fatalf("output = %+v", out)
+	}
+
+	spec.Request.Auth = &HTTPAuth{Type: "hmac", SecretEnv: "TEST_HMAC_SECRET", Header: "X-Hub-Signature"}
+	spec.Request.Expect = HTTPExpect{JSON: []JSONAssertion{{Path: "$.job.state", Equals: "done"}, {Path: "$.job.owner"}}}
+	_, err = taskHTTP(context.Background(), spec)
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(gotBody))
+	if gotSig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("signature = %q", gotSig)
+	}
+	var ae *AssertionError
+	if !errors.As(err, &ae) || len(ae.Failures) != 2 || !strings.Contains(ae.Failures[0], `want "done"`) {
+		t.Fatalf("err = %v, want two assertion failures", err)
+	}
+}
+
+func TestHTTPTaskDefaultExpectations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	out, err := taskHTTP(context.Background(), TaskSpec{Type: "http", Payload: srv.URL})
+	var ae *AssertionError
+	if !errors.As(err, &ae) || ae.Status != 500 || out.Body != "nope\n" {
+		t.Fatalf("out = %+v err = %v", out, err)
+	}
+	if _, err := taskHTTP(context.Background(), TaskSpec{Type: "http", Payload: srv.URL, Request: &HTTPTaskOptions{Auth: &HTTPAuth{Type: "bearer", TokenEnv: "UNSET_TOKEN_ENV"}}}); err == nil {
+		t.Fatal("expected error for a missing token")
+	}
+}
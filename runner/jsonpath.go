@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed path in the common JSONPath subset:
+// $.name, $['name'], $.list[0], $.list[-1] and $.list[*].field.
+type jsonPath []pathStep
+
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(p string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(p), "$")
+	if !ok {
+		return nil, fmt.Errorf("json path %q must start with $", p)
+	}
+	var steps jsonPath
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("json path %q: empty name", p)
+			}
+			if name == "*" {
+				steps = append(steps, pathStep{wildcard: true})
+			} else {
+				steps = append(steps, pathStep{key: name})
+			}
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: missing ]", p)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, pathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, pathStep{key: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("json path %q: bad index %q", p, inner)
+				}
+				steps = append(steps, pathStep{index: i, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", p, rest[:1])
+		}
+	}
+	return steps, nil
+}
+
+// Lookup evaluates the path against a decoded JSON document. A path with a
+// wildcard returns the list of matches.
+func (p jsonPath) Lookup(doc interface{}) (interface{}, bool) {
+	values := []interface{}{doc}
+	multi := false
+	for _, s := range p {
+		var next []interface{}
+		for _, v := range values {
+			switch {
+			case s.wildcard:
+				multi = true
+				switch c := v.(type) {
+				case []interface{}:
+					next = append(next, c...)
+				case map[string]interface{}:
+					for _, k := range sortedKeys(c) {
+						next = append(next, c[k])
+					}
+				}
+			case s.isIndex:
+				if list, ok := v.([]interface{}); ok {
+					i := s.index
+					if i < 0 {
+						i += len(list)
+					}
+					if i >= 0 && i < len(list) {
+						next = append(next, list[i])
+					}
+				}
+			default:
+				if obj, ok := v.(map[string]interface{}); ok {
+					if child, ok := obj[s.key]; ok {
+						next = append(next, child)
+					}
+				}
+			}
+		}
+		values = next
+	}
+	if multi {
+		return values, true
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
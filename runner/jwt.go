@@ -0,0 +1,373 @@
+package runner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- OIDC JWTs ----------------
+
+// OIDCConfig accepts JWTs from an OpenID Connect provider, checked against
+// its JSON Web Key Set.
+type OIDCConfig struct {
+	Issuer     string `json:"issuer"`                // required "iss"
+	Audience   string `json:"audience"`              // required in "aud"
+	JWKSURL    string `json:"jwks_url,omitempty"`    // key set to fetch, refreshed hourly and on unknown keys
+	JWKSFile   string `json:"jwks_file,omitempty"`   // or a key set on disk, reread like the URL
+	ScopeClaim string `json:"scope_claim,omitempty"` // claim holding the scopes, default "scope"
+	// NamespaceClaim holds the namespaces the token may use, default
+	// "namespaces"; without it the token may use all of them.
+	NamespaceClaim string   `json:"namespace_claim,omitempty"`
+	Leeway         Duration `json:"leeway,omitempty"` // clock skew allowed on exp and nbf, default 1m
+}
+
+const (
+	jwksMaxAge     = time.Hour
+	jwksMinRefetch = time.Minute // between refreshes for unknown key IDs
+)
+
+// jwtVerifier checks JWTs signed with RS*, PS*, ES* or EdDSA keys.
+type jwtVerifier struct {
+	cfg OIDCConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time
+}
+
+func newJWTVerifier(cfg OIDCConfig) (*jwtVerifier, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("issuer and audience are required")
+	}
+	if (cfg.JWKSURL == "") == (cfg.JWKSFile == "") {
+		return nil, errors.New("want one of jwks_url and jwks_file")
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = "scope"
+	}
+	if cfg.NamespaceClaim == "" {
+		cfg.NamespaceClaim = "namespaces"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = Duration(time.Minute)
+	}
+	v := &jwtVerifier{cfg: cfg, now: time.Now}
+	if cfg.JWKSFile != "" {
+		// A file can be checked now; a URL may not be reachable yet.
+		if err := v.refresh(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks token's signature and claims and returns its principal.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	var hdr jwtHeader
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrTokenInvalid, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrTokenInvalid, err)
+	}
+	key, err := v.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	if err := verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrTokenInvalid, err)
+	}
+	sub, _ := claims["sub"].(string)
+	p := &Principal{Subject: "oidc:" + sub}
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return p, fmt.Errorf("%w: issuer %q", ErrTokenInvalid, iss)
+	}
+	if !slices.Contains(claimStrings(claims["aud"]), v.cfg.Audience) {
+		return p, fmt.Errorf("%w: audience %v", ErrTokenInvalid, claims["aud"])
+	}
+	now, leeway := v.now(), time.Duration(v.cfg.Leeway)
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return p, fmt.Errorf("%w: no exp", ErrTokenInvalid)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return p, ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return p, fmt.Errorf("%w: not valid yet", ErrTokenInvalid)
+	}
+	for _, s := range claimStrings(claims[v.cfg.ScopeClaim]) {
+		if scopeRank[s] > 0 {
+			p.Scopes = append(p.Scopes, s)
+		}
+	}
+	p.Namespaces = claimStrings(claims[v.cfg.NamespaceClaim])
+	return p, nil
+}
+
+// claimStrings reads a claim that is a string of space-separated values or
+// an array of strings.
+func claimStrings(c interface{}) []string {
+	switch c := c.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		var out []string
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks sig over signed with key. The key's type must fit
+// alg, so a token cannot pick a weaker algorithm than its key; "none" and
+// HMAC are never accepted.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	digest := func() []byte {
+		h := hash.New()
+		h.Write([]byte(signed))
+		return h.Sum(nil)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(alg, "RS") && hash != 0:
+			return rsa.VerifyPKCS1v15(k, hash, digest(), sig)
+		case strings.HasPrefix(alg, "PS") && hash != 0:
+			return rsa.VerifyPSS(k, hash, digest(), sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		bits := k.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if strings.HasPrefix(alg, "ES") && hash == curveHash[bits] && len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest(), r, s) {
+				return nil
+			}
+			return errors.New("bad signature")
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			if ed25519.Verify(k, []byte(signed), sig) {
+				return nil
+			}
+			return errors.New("bad signature")
+		}
+	}
+	return fmt.Errorf("algorithm %q does not fit the key", alg)
+}
+
+// curveHash is the hash each ES algorithm pairs with its curve.
+var curveHash = map[int]crypto.Hash{256: crypto.SHA256, 384: crypto.SHA384, 521: crypto.SHA512}
+
+// key returns the key with ID kid, refreshing the key set when it is old
+// or lacks kid.
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	k, ok := v.keys[kid]
+	age := v.now().Sub(v.fetched)
+	if (!ok && age >= jwksMinRefetch) || age >= jwksMaxAge {
+		if err := v.refresh(ctx); err != nil {
+			if !ok {
+				return nil, err
+			}
+			// Keep using the known key while the provider is unreachable.
+			return k, nil
+		}
+		k, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return k, nil
+}
+
+// refresh reloads the key set. Callers hold v.mu, except at construction.
+func (v *jwtVerifier) refresh(ctx context.Context) error {
+	v.fetched = v.now()
+	var data []byte
+	var err error
+	if v.cfg.JWKSFile != "" {
+		data, err = os.ReadFile(v.cfg.JWKSFile)
+	} else {
+		data, err = fetchJWKS(ctx, v.cfg.JWKSURL)
+	}
+	if err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	v.keys = keys
+	return nil
+}
+
+// fetchJWKS calls out with the command's client, like the rest of the API.
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := defaultEnv.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", redactURL(url), resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk is one JSON Web Key; only the public parts are read.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the signing keys of a key set. Keys of unknown types are
+// skipped, as providers may publish more than the verifier needs.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,275 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ---------------- LEADER ELECTION ----------------
+
+// Fence is one leadership term. Leader-only writes carry it, and the queue
+// rejects them once a newer term has begun, so a deposed leader that has not
+// noticed yet cannot act twice.
+type Fence struct {
+	Name  string `json:"name"`
+	Token int64  `json:"token"`
+}
+
+// ErrFenced is returned for a leader-only write whose term has ended.
+var ErrFenced = errors.New("leadership term has ended")
+
+// LeaderInfo is the current holder of a leadership lease.
+type LeaderInfo struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	Token      int64     `json:"token"`
+	LeaseUntil time.Time `json:"lease_until"`
+}
+
+// AcquireLeadership takes or renews the lease name for holder. A new term,
+// with a higher token, starts when the lease changes hands; renewals keep
+// the token. ok is false while another holder's lease is current.
+func (q *Queue) AcquireLeadership(ctx context.Context, name, holder string, ttl time.Duration) (fence Fence, ok bool, err error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Fence{}, false, fmt.Errorf("queue: leader: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := q.nowMillis()
+	fence.Name = name
+	var cur LeaderInfo
+	var until int64
+	err = tx.QueryRowContext(ctx, `SELECT holder, token, lease_until FROM queue_leader WHERE name = ?`, name).Scan(&cur.Holder, &cur.Token, &until)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		fence.Token = 1
+		_, err = tx.ExecContext(ctx, `INSERT INTO queue_leader (name, holder, token, lease_until) VALUES (?, ?, ?, ?)`,
+			name, holder, fence.Token, now+ttl.Milliseconds())
+	case err != nil:
+	case cur.Holder == holder && until > 0:
+		fence.Token = cur.Token
+		_, err = tx.ExecContext(ctx, `UPDATE queue_leader SET lease_until = ? WHERE name = ?`, now+ttl.Milliseconds(), name)
+	case until < now:
+		fence.Token = cur.Token + 1
+		_, err = tx.ExecContext(ctx, `UPDATE queue_leader SET holder = ?, token = ?, lease_until = ? WHERE name = ?`,
+			holder, fence.Token, now+ttl.Milliseconds(), name)
+	default:
+		return Fence{}, false, nil
+	}
+	if err != nil {
+		return Fence{}, false, fmt.Errorf("queue: leader: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Fence{}, false, fmt.Errorf("queue: leader: %w", err)
+	}
+	return fence, true, nil
+}
+
+// ResignLeadership ends fence's term at once so another candidate can take
+// over without waiting for the lease to expire.
+func (q *Queue) ResignLeadership(ctx context.Context, fence Fence) error {
+	if _, err := q.db.ExecContext(ctx, `UPDATE queue_leader SET lease_until = 0 WHERE name = ? AND token = ?`, fence.Name, fence.Token); err != nil {
+		return fmt.Errorf("queue: leader: %w", err)
+	}
+	return nil
+}
+
+// Leader returns the holder of the lease name.
+func (q *Queue) Leader(ctx context.Context, name string) (LeaderInfo, error) {
+	info := LeaderInfo{Name: name}
+	var until int64
+	err := q.db.QueryRowContext(ctx, `SELECT holder, token, lease_until FROM queue_leader WHERE name = ?`, name).Scan(&info.Holder, &info.Token, &until)
+	if err != nil {
+		return info, fmt.Errorf("queue: leader %s: %w", name, err)
+	}
+	info.LeaseUntil = time.UnixMilli(until).UTC()
+	return info, nil
+}
+
+// fenced runs fn in a transaction that only commits while fence's term is
+// the current one.
+func (q *Queue) fenced(ctx context.Context, fence Fence, fn func(tx *sql.Tx) error) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var token int64
+	err = tx.QueryRowContext(ctx, `SELECT token FROM queue_leader WHERE name = ?`, fence.Name).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && token != fence.Token) {
+		return fmt.Errorf("%w: %s term %d", ErrFenced, fence.Name, fence.Token)
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkFence returns ErrFenced once fence's term has ended, for leader
+// duties whose work is outside the queue.
+func (q *Queue) checkFence(ctx context.Context, fence Fence) error {
+	return q.fenced(ctx, fence, func(*sql.Tx) error { return nil })
+}
+
+// Elector campaigns for a leadership lease and runs the registered duties
+// while it holds it. Duties get a context that is canceled when leadership
+// is lost, and the elector waits for them to return before campaigning
+// again, so within one process they never overlap across terms.
+//
+// The lease is written with the holder's clock and read with everyone
+// else's. The leader therefore treats its lease as ending MaxClockSkew
+// early and steps down if it cannot renew by then, before a follower whose
+// clock runs that much ahead can take over.
+type Elector struct {
+	Name         string
+	ID           string
+	TTL          time.Duration
+	RenewEvery   time.Duration
+	MaxClockSkew time.Duration
+
+	queue  *Queue
+	duties []func(ctx context.Context, fence Fence)
+
+	mu       sync.Mutex
+	fence    Fence
+	leading  bool
+	deadline time.Time // local end of the current term
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewElector creates an elector for the lease name, campaigning as id.
+func NewElector(q *Queue, name, id string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+	return &Elector{Name: name, ID: id, TTL: ttl, RenewEvery: ttl / 3, MaxClockSkew: ttl / 5, queue: q}
+}
+
+// Duty registers a leader-only loop. It must be called before Run.
+func (e *Elector) Duty(fn func(ctx context.Context, fence Fence)) {
+	e.duties = append(e.duties, fn)
+}
+
+// Leader reports whether the elector currently leads, and its term.
+func (e *Elector) Leader() (Fence, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fence, e.leading
+}
+
+// Run campaigns until ctx is canceled, then stops the duties and resigns.
+func (e *Elector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.RenewEvery)
+	defer ticker.Stop()
+	for {
+		e.tick(ctx)
+		select {
+		case <-ctx.Done():
+			e.stepDown(context.WithoutCancel(ctx), true)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick makes one campaign or renewal attempt.
+func (e *Elector) tick(ctx context.Context) {
+	start := e.queue.now()
+	fence, ok, err := e.queue.AcquireLeadership(ctx, e.Name, e.ID, e.TTL)
+
+	e.mu.Lock()
+	leading := e.leading
+	e.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Printf("[Leader %s] %s: %v", e.Name, e.ID, err)
+		// Partitioned from the store: keep leading until the term runs out.
+		if leading && !e.queue.now().Before(e.localDeadline()) {
+			log.Printf("[Leader %s] %s: lease could not be renewed, stepping down", e.Name, e.ID)
+			e.stepDown(ctx, false)
+		}
+	case !ok || (leading && fence != e.currentFence()):
+		if leading {
+			log.Printf("[Leader %s] %s: lost leadership", e.Name, e.ID)
+			e.stepDown(ctx, false)
+		}
+	case leading:
+		e.mu.Lock()
+		e.deadline = start.Add(e.TTL - e.MaxClockSkew)
+		e.mu.Unlock()
+	default:
+		e.stepUp(ctx, fence, start.Add(e.TTL-e.MaxClockSkew))
+	}
+}
+
+func (e *Elector) currentFence() Fence {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fence
+}
+
+func (e *Elector) localDeadline() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deadline
+}
+
+func (e *Elector) stepUp(ctx context.Context, fence Fence, deadline time.Time) {
+	log.Printf("[Leader %s] %s: elected, term %d", e.Name, e.ID, fence.Token)
+	dutyCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	e.mu.Lock()
+	e.fence, e.leading, e.deadline, e.stop = fence, true, deadline, stop
+	e.mu.Unlock()
+	for _, duty := range e.duties {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			duty(dutyCtx, fence)
+		}()
+	}
+}
+
+// stepDown stops the duties and, when resign is set, gives up the lease.
+func (e *Elector) stepDown(ctx context.Context, resign bool) {
+	e.mu.Lock()
+	fence, leading, stop := e.fence, e.leading, e.stop
+	e.leading, e.stop = false, nil
+	e.mu.Unlock()
+	if !leading {
+		return
+	}
+	stop()
+	e.wg.Wait()
+	if resign {
+		if err := e.queue.ResignLeadership(ctx, fence); err != nil {
+			log.Printf("[Leader %s] %s: %v", e.Name, e.ID, err)
+			return
+		}
+		log.Printf("[Leader %s] %s: resigned, term %d", e.Name, e.ID, fence.Token)
+	}
+}
+
+// everyTick runs fn now and then at every interval until ctx is canceled,
+// the shape of most leader duties.
+func everyTick(ctx context.Context, interval time.Duration, fn func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock is a manual clock shared by replicas, each of which may see it
+// with an offset.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *testClock) at(offset time.Duration) func() time.Time {
+	return func() time.Time {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.now.Add(offset)
+	}
+}
+
+// electionReplica is one elector with its own connection and clock.
+type electionReplica struct {
+	q      *Queue
+	e      *Elector
+	active atomic.Int32 // running duties
+}
+
+func newElectionReplicas(t *testing.T, clock *testClock, offsets ...time.Duration) []*electionReplica {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queue.db")
+	var rs []*electionReplica
+	for i, off := range offsets {
+		r := &electionReplica{q: openTestQueue(t, path, time.Minute)}
+		r.q.now = clock.at(off)
+		r.e = NewElector(r.q, "maintenance", fmt.Sprintf("r%d", i), 15*time.Second)
+		r.e.MaxClockSkew = 3 * time.Second
+		r.e.Duty(func(ctx context.Context, fence Fence) {
+			r.active.Add(1)
+			<-ctx.Done()
+			r.active.Add(-1)
+		})
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func tickAll(rs []*electionReplica) {
+	for _, r := range rs {
+		r.e.tick(context.Background())
+	}
+}
+
+func leaders(rs []*electionReplica) []int {
+	var ids []int
+	for i, r := range rs {
+		if _, ok := r.e.Leader(); ok {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+func TestElectorSingleLeader(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	rs := newElectionReplicas(t, clock, 0, 0, 0)
+
+	for range 5 {
+		tickAll(rs)
+		clock.advance(5 * time.Second)
+		if ls := leaders(rs); len(ls) != 1 || ls[0] != 0 {
+			t.Fatalf("leaders = %v", ls)
+		}
+	}
+	fence, _ := rs[0].e.Leader()
+	waitFor(t, func() bool { return rs[0].active.Load() == 1 })
+
+	// A clean step-down hands over at once, in a new term.
+	rs[0].e.stepDown(context.Background(), true)
+	if rs[0].active.Load() != 0 {
+		t.Fatal("duty still running after step-down")
+	}
+	tickAll(rs[1:])
+	next, ok := rs[1].e.Leader()
+	if !ok || next.Token != fence.Token+1 {
+		t.Fatalf("after resign: leader r1 = %v, fence %+v", ok, next)
+	}
+	info, _ := rs[1].q.Leader(context.Background(), "maintenance")
+	if info.Holder != "r1" || info.Token != next.Token {
+		t.Errorf("lease = %+v", info)
+	}
+}
+
+func TestElectorPartition(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	rs := newElectionReplicas(t, clock, 0, 0)
+	tickAll(rs)
+	old, _ := rs[0].e.Leader()
+
+	// The leader loses the store. It keeps leading until its term runs out,
+	// minus the skew margin, and the follower cannot take over before the
+	// lease expires.
+	rs[0].q.Close()
+	for elapsed := time.Duration(0); elapsed <= 20*time.Second; elapsed += time.Second {
+		tickAll(rs)
+		ls := leaders(rs)
+		if len(ls) > 1 {
+			t.Fatalf("two leaders %v after %s", ls, elapsed)
+		}
+		if elapsed < 12*time.Second && (len(ls) != 1 || ls[0] != 0) {
+			t.Fatalf("leaders = %v after %s, want the partitioned leader", ls, elapsed)
+		}
+		clock.advance(time.Second)
+	}
+	if ls := leaders(rs); len(ls) != 1 || ls[0] != 1 {
+		t.Fatalf("leaders = %v, want the follower", ls)
+	}
+	if rs[0].active.Load() != 0 {
+		t.Error("partitioned leader still runs its duty")
+	}
+	if _, err := rs[1].q.ReapExpired(context.Background(), old); !errors.Is(err, ErrFenced) {
+		t.Errorf("write with the old term = %v", err)
+	}
+	if err := rs[1].q.checkFence(context.Background(), old); !errors.Is(err, ErrFenced) {
+		t.Errorf("fence check with the old term = %v", err)
+	}
+}
+
+func TestElectorClockSkew(t *testing.T) {
+	for _, tc := range []struct {
+		skew    time.Duration
+		overlap bool
+	}{
+		{2 * time.Second, false}, // within MaxClockSkew
+		{8 * time.Second, true},  // beyond it, only fencing protects
+	} {
+		t.Run(tc.skew.String(), func(t *testing.T) {
+			clock := &testClock{now: time.Unix(1700000000, 0)}
+			rs := newElectionReplicas(t, clock, 0, tc.skew)
+			tickAll(rs)
+			old, _ := rs[0].e.Leader()
+			rs[0].q.Close()
+
+			overlap := false
+			for range 40 {
+				clock.advance(500 * time.Millisecond)
+				tickAll(rs)
+				if len(leaders(rs)) > 1 {
+					overlap = true
+				}
+			}
+			if overlap != tc.overlap {
+				t.Errorf("overlap = %v, want %v", overlap, tc.overlap)
+			}
+			next, ok := rs[1].e.Leader()
+			if !ok || next.Token <= old.Token {
+				t.Fatalf("follower term = %+v, %v", next, ok)
+			}
+			if _, err := rs[1].q.ReapExpired(context.Background(), old); !errors.Is(err, ErrFenced) {
+				t.Errorf("write with the old term = %v", err)
+			}
+		})
+	}
+}
+
+func TestElectorRunResignsOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	a := NewElector(openTestQueue(t, path, time.Minute), "maintenance", "a", time.Hour)
+	b := NewElector(openTestQueue(t, path, time.Minute), "maintenance", "b", time.Hour)
+	b.RenewEvery = 10 * time.Millisecond
+	var stopped atomic.Bool
+	a.Duty(func(ctx context.Context, fence Fence) {
+		<-ctx.Done()
+		stopped.Store(true)
+	})
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	doneA := make(chan error)
+	go func() { doneA <- a.Run(ctxA) }()
+	waitFor(t, func() bool { _, ok := a.Leader(); return ok })
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go b.Run(ctxB)
+
+	stopA()
+	if err := <-doneA; err != nil {
+		t.Fatal(err)
+	}
+	if !stopped.Load() {
+		t.Error("duty not stopped before Run returned")
+	}
+	// b takes over long before a's hour-long lease would have expired.
+	waitFor(t, func() bool { _, ok := b.Leader(); return ok })
+}
@@ -0,0 +1,655 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AIOptions are the per-task settings of an ai task. The payload is the
+// user prompt.
+type AIOptions struct {
+	Provider     string   `json:"provider,omitempty"` // alias from Config.LLMProviders, default "default"
+	Model        string   `json:"model,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	MaxTokens    int      `json:"max_tokens,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+
+	// ResponseSchema constrains the answer to JSON matching this schema. An
+	// answer that doesn't validate is sent back to the model together with
+	// the validation errors, up to RepairAttempts times (default 2).
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+	RepairAttempts *int            `json:"repair_attempts,omitempty"`
+
+	// Template names a prompt template as "name@version" (or just "name" for
+	// the latest), rendered with Vars. It replaces the payload as prompt.
+	Template string                 `json:"template,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+}
+
+// LLMConfig describes a model endpoint in CONFIG_JSON.
+type LLMConfig struct {
+	Type      string `json:"type"`                  // "openai" (any OpenAI-compatible API) or "local"
+	BaseURL   string `json:"base_url,omitempty"`    // e.g. https://api.openai.com/v1 or http://localhost:11434
+	APIKeyEnv string `json:"api_key_env,omitempty"` // env var holding the API key, default OPENAI_API_KEY for openai
+	APIKey    string `json:"api_key,omitempty"`     // a ${secret:name} reference, resolved on every call, instead of APIKeyEnv
+	Model     string `json:"model,omitempty"`       // used when the task sets none
+	NoStream  bool   `json:"no_stream,omitempty"`   // for servers without streaming support
+
+	Namespaces []string `json:"namespaces,omitempty"` // namespaces whose tasks may use it, default all
+}
+
+func (c LLMConfig) namespaces() []string { return c.Namespaces }
+
+// String hides an API key written into the config; ${secret:name}
+// references are shown as they are.
+func (c LLMConfig) String() string {
+	type plain LLMConfig
+	p := plain(c)
+	if secretRef.ReplaceAllString(p.APIKey, "") != "" {
+		p.APIKey = redacted
+	}
+	return fmt.Sprintf("%+v", p)
+}
+
+func (c LLMConfig) GoString() string { return c.String() }
+
+// LLMRequest is a single-turn chat request.
+type LLMRequest struct {
+	Model        string
+	SystemPrompt string
+	Prompt       string
+	Temperature  *float64
+	MaxTokens    int
+	JSON         bool // ask the backend for a JSON object answer
+}
+
+// AIOutput is the output of an ai task.
+type AIOutput struct {
+	Text         string     `json:"text"`
+	Model        string     `json:"model"`
+	FinishReason string     `json:"finish_reason"`
+	Usage        TokenUsage `json:"usage"`
+
+	// JSON is the parsed answer of a task with a response schema.
+	JSON interface{} `json:"json,omitempty"`
+	// Attempts counts the model calls made, including repairs.
+	Attempts int `json:"attempts,omitempty"`
+	// Prompt records the template version and rendered text of a templated
+	// task, so the call can be reproduced.
+	Prompt *RenderedPrompt `json:"prompt,omitempty"`
+	// Artifact is the stored answer, "sha256:<digest>".
+	Artifact string `json:"artifact,omitempty"`
+}
+
+// ErrSchemaViolation fails a task without retries: its repair attempts
+// already asked the model again.
+var ErrSchemaViolation = errors.New("schema violation")
+
+// SchemaViolationError is returned when the model's answer still doesn't
+// match the response schema after all repair attempts.
+type SchemaViolationError struct {
+	Attempts int
+	Errors   []string // violations of the last answer
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("%v after %d attempts: %s", ErrSchemaViolation, e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+func (e *SchemaViolationError) Unwrap() error { return ErrSchemaViolation }
+
+// defaultRepairAttempts is how often an invalid answer is sent back to the
+// model when the task doesn't say.
+const defaultRepairAttempts = 2
+
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// LLMProvider runs a chat completion against some model backend.
+type LLMProvider interface {
+	Complete(ctx context.Context, req LLMRequest) (*AIOutput, error)
+}
+
+// StreamingLLMProvider is implemented by providers that can deliver the
+// completion incrementally. onDelta receives each new chunk of text. When the
+// stream breaks off, the text received so far is returned with the error.
+type StreamingLLMProvider interface {
+	LLMProvider
+	Stream(ctx context.Context, req LLMRequest, onDelta func(string)) (*AIOutput, error)
+}
+
+// defaultLLM is the alias used by ai tasks that don't pick a provider.
+const defaultLLM = "default"
+
+// llmConfigs returns the configured providers. Without any config an OpenAI
+// provider is the default when OPENAI_API_KEY is present.
+func llmConfigs(cfgs map[string]LLMConfig) map[string]LLMConfig {
+	if len(cfgs) == 0 && os.Getenv("OPENAI_API_KEY") != "" {
+		return map[string]LLMConfig{defaultLLM: {Type: "openai"}}
+	}
+	return cfgs
+}
+
+// loadLLMProviders builds every provider of llmConfigs(cfgs). The providers
+// call their APIs with client.
+func loadLLMProviders(cfgs map[string]LLMConfig, client *http.Client) (map[string]LLMProvider, error) {
+	cfgs = llmConfigs(cfgs)
+	out := make(map[string]LLMProvider, len(cfgs))
+	for alias, c := range cfgs {
+		p, err := newLLMProvider(c, client)
+		if err != nil {
+			return nil, fmt.Errorf("llm provider %q: %w", alias, err)
+		}
+		out[alias] = p
+	}
+	return out, nil
+}
+
+func newLLMProvider(c LLMConfig, client *http.Client) (LLMProvider, error) {
+	switch c.Type {
+	case "openai":
+		base := c.BaseURL
+		if base == "" {
+			base = "https://api.openai.com/v1"
+		}
+		keyEnv := c.APIKeyEnv
+		if keyEnv == "" {
+			keyEnv = "OPENAI_API_KEY"
+		}
+		key := c.APIKey
+		if key == "" {
+			key = os.Getenv(keyEnv)
+		}
+		return &openAIProvider{baseURL: strings.TrimRight(base, "/"), apiKey: key, model: c.Model, noStream: c.NoStream, client: client}, nil
+	case "local":
+		base := c.BaseURL
+		if base == "" {
+			base = "http://localhost:11434"
+		}
+		return &localProvider{baseURL: strings.TrimRight(base, "/"), model: c.Model, noStream: c.NoStream, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", c.Type)
+	}
+}
+
+// AI task: sends the payload, or the task's rendered prompt template, as the
+// prompt to the task's provider. With a streaming provider, onDelta sees the text as it is generated.
+func taskAI(ctx context.Context, spec TaskSpec, onDelta func(string)) (*AIOutput, error) {
+	var opts AIOptions
+	if spec.AI != nil {
+		opts = *spec.AI
+	}
+	prompt := spec.Payload
+	var rendered *RenderedPrompt
+	env := envOf(ctx)
+	if opts.Template != "" {
+		var err error
+		if opts, prompt, rendered, err = applyTemplate(env.prompts, spec, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := env.quotas.CheckAI(spec.Namespace); err != nil {
+		return nil, err
+	}
+	out, err := runAI(ctx, opts, prompt, spec.Namespace, onDelta)
+	if out != nil {
+		env.quotas.AddAI(spec.Namespace, out.Usage.TotalTokens)
+		ReportUsage(ctx, Usage{Kind: UsageAI, Model: out.Model, PromptTokens: out.Usage.PromptTokens, CompletionTokens: out.Usage.CompletionTokens})
+	}
+	if rendered != nil {
+		if out == nil {
+			out = &AIOutput{}
+		}
+		out.Prompt = rendered
+	}
+	return out, err
+}
+
+func runAI(ctx context.Context, opts AIOptions, prompt, ns string, onDelta func(string)) (*AIOutput, error) {
+	alias := opts.Provider
+	if alias == "" {
+		alias = defaultLLM
+	}
+	env := envOf(ctx)
+	provider, ok := env.llmProviders[alias]
+	if ok && !allowsNamespace(env.llmNamespaces[alias], ns) {
+		return nil, fmt.Errorf("LLM provider %q is not available in namespace %s", alias, namespaceOr(ns))
+	}
+	if !ok {
+		return nil, fmt.Errorf("no LLM provider configured for alias %q", alias)
+	}
+	req := LLMRequest{
+		Model:        opts.Model,
+		SystemPrompt: opts.SystemPrompt,
+		Prompt:       prompt,
+		Temperature:  opts.Temperature,
+		MaxTokens:    opts.MaxTokens,
+	}
+	if len(opts.ResponseSchema) > 0 {
+		return completeStructured(ctx, provider, req, opts, onDelta)
+	}
+	return complete(ctx, provider, req, onDelta)
+}
+
+func complete(ctx context.Context, provider LLMProvider, req LLMRequest, onDelta func(string)) (*AIOutput, error) {
+	if sp, ok := provider.(StreamingLLMProvider); ok && onDelta != nil {
+		return sp.Stream(ctx, req, onDelta)
+	}
+	return provider.Complete(ctx, req)
+}
+
+// completeStructured asks for JSON matching opts.ResponseSchema and re-prompts
+// with the validation errors until the answer validates or the repair budget
+// is spent.
+func completeStructured(ctx context.Context, provider LLMProvider, req LLMRequest, opts AIOptions, onDelta func(string)) (*AIOutput, error) {
+	schema, err := ParseSchema(opts.ResponseSchema)
+	if err != nil {
+		return nil, err
+	}
+	repairs := defaultRepairAttempts
+	if opts.RepairAttempts != nil {
+		repairs = *opts.RepairAttempts
+	}
+	instruction := "Respond with a single JSON value, without any other text, that validates against this JSON Schema:\n" + string(opts.ResponseSchema)
+	if req.SystemPrompt != "" {
+		req.SystemPrompt += "\n\n" + instruction
+	} else {
+		req.SystemPrompt = instruction
+	}
+	req.JSON = true
+	prompt := req.Prompt
+
+	var usage TokenUsage
+	for attempt := 1; ; attempt++ {
+		out, err := complete(ctx, provider, req, onDelta)
+		if out != nil {
+			usage.PromptTokens += out.Usage.PromptTokens
+			usage.CompletionTokens += out.Usage.CompletionTokens
+			usage.TotalTokens += out.Usage.TotalTokens
+			out.Usage = usage
+			out.Attempts = attempt
+		}
+		if err != nil {
+			return out, err
+		}
+
+		var errs []string
+		v, perr := parseJSONAnswer(out.Text)
+		if perr != nil {
+			errs = []string{"answer is not valid JSON: " + perr.Error()}
+		} else {
+			errs = schema.Validate(v)
+		}
+		if len(errs) == 0 {
+			out.JSON = v
+			return out, nil
+		}
+		if attempt > repairs {
+			return out, &SchemaViolationError{Attempts: attempt, Errors: errs}
+		}
+		req.Prompt = prompt + "\n\nYour previous answer was:\n" + out.Text +
+			"\n\nIt does not match the required JSON Schema:\n- " + strings.Join(errs, "\n- ") +
+			"\n\nReply with corrected JSON only."
+	}
+}
+
+// parseJSONAnswer decodes a model answer, tolerating a surrounding markdown
+// code fence.
+func parseJSONAnswer(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+			rest = rest[nl+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(text))
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return v, nil
+}
+
+// postJSON sends body to url and returns the response if its status is OK.
+// The caller closes the body.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s returned status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// callJSON is postJSON for non-streaming calls, decoding the answer into out.
+func callJSON(ctx context.Context, client *http.Client, url string, header http.Header, body, out interface{}) error {
+	resp, err := postJSON(ctx, client, url, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readLines calls fn for every line of r until fn returns false.
+func readLines(r io.Reader, fn func(line string) bool) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if !fn(sc.Text()) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// streamFailed finishes a stream that broke off: the partial text is kept and
+// the finish reason says why it stopped.
+func streamFailed(ctx context.Context, out *AIOutput, text string, err error) (*AIOutput, error) {
+	out.Text = text
+	out.FinishReason = "error"
+	if ctx.Err() != nil {
+		out.FinishReason = "canceled"
+		err = ctx.Err()
+	}
+	return out, fmt.Errorf("stream interrupted after %d bytes: %w", len(text), err)
+}
+
+// ---------------- OPENAI-COMPATIBLE ----------------
+
+type openAIProvider struct {
+	baseURL  string
+	apiKey   string // may be a secret reference
+	model    string
+	noStream bool
+	client   *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func chatMessages(req LLMRequest) []chatMessage {
+	var msgs []chatMessage
+	if req.SystemPrompt != "" {
+		msgs = append(msgs, chatMessage{Role: "system", Content: req.SystemPrompt})
+	}
+	return append(msgs, chatMessage{Role: "user", Content: req.Prompt})
+}
+
+func (p *openAIProvider) request(ctx context.Context, req LLMRequest) (map[string]interface{}, http.Header, error) {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	body := map[string]interface{}{
+		"model":    model,
+		"messages": chatMessages(req),
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		body["max_tokens"] = req.MaxTokens
+	}
+	if req.JSON {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+	header := http.Header{}
+	key, err := envOf(ctx).secrets.Resolve(ctx, p.apiKey, anyNamespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != "" {
+		header.Set("Authorization", "Bearer "+key)
+	}
+	return body, header, nil
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req LLMRequest) (*AIOutput, error) {
+	body, header, err := p.request(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message      chatMessage `json:"message"`
+			FinishReason string      `json:"finish_reason"`
+		} `json:"choices"`
+		Usage TokenUsage `json:"usage"`
+	}
+	if err := callJSON(ctx, p.client, p.baseURL+"/chat/completions", header, body, &resp); err != nil {
+		return nil, fmt.Errorf("chat completion: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("chat completion returned no choices")
+	}
+	return &AIOutput{
+		Text:         resp.Choices[0].Message.Content,
+		Model:        resp.Model,
+		FinishReason: resp.Choices[0].FinishReason,
+		Usage:        resp.Usage,
+	}, nil
+}
+
+// Stream reads the completion as server-sent events.
+func (p *openAIProvider) Stream(ctx context.Context, req LLMRequest, onDelta func(string)) (*AIOutput, error) {
+	if p.noStream {
+		return completeAsStream(ctx, p, req, onDelta)
+	}
+	body, header, err := p.request(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	body["stream"] = true
+	body["stream_options"] = map[string]bool{"include_usage": true}
+	resp, err := postJSON(ctx, p.client, p.baseURL+"/chat/completions", header, body)
+	if err != nil {
+		return nil, fmt.Errorf("chat completion: %w", err)
+	}
+	defer resp.Body.Close()
+
+	out := &AIOutput{}
+	var text strings.Builder
+	var done bool
+	var chunkErr error
+	err = readLines(resp.Body, func(line string) bool {
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			return true // blank separators, comments, event names
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			return false
+		}
+		var chunk struct {
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta        chatMessage `json:"delta"`
+				FinishReason string      `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *TokenUsage `json:"usage"`
+		}
+		if chunkErr = json.Unmarshal([]byte(data), &chunk); chunkErr != nil {
+			return false
+		}
+		if chunk.Model != "" {
+			out.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			out.Usage = *chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				text.WriteString(c.Delta.Content)
+				onDelta(c.Delta.Content)
+			}
+			if c.FinishReason != "" {
+				out.FinishReason = c.FinishReason
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = chunkErr
+	}
+	if err == nil && !done {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return streamFailed(ctx, out, text.String(), err)
+	}
+	out.Text = text.String()
+	return out, nil
+}
+
+// completeAsStream serves Stream with a single Complete call.
+func completeAsStream(ctx context.Context, p LLMProvider, req LLMRequest, onDelta func(string)) (*AIOutput, error) {
+	out, err := p.Complete(ctx, req)
+	if err == nil {
+		onDelta(out.Text)
+	}
+	return out, err
+}
+
+// ---------------- LOCAL MODEL ----------------
+
+// localProvider talks to a local model server's /api/chat endpoint, as served
+// by Ollama and compatible runtimes.
+type localProvider struct {
+	baseURL  string
+	model    string
+	noStream bool
+	client   *http.Client
+}
+
+// localChatResponse is the /api/chat answer, or one line of it when streaming.
+type localChatResponse struct {
+	Model           string      `json:"model"`
+	Message         chatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	DoneReason      string      `json:"done_reason"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	EvalCount       int         `json:"eval_count"`
+}
+
+func (r localChatResponse) output(text string) *AIOutput {
+	return &AIOutput{
+		Text:         text,
+		Model:        r.Model,
+		FinishReason: r.DoneReason,
+		Usage: TokenUsage{
+			PromptTokens:     r.PromptEvalCount,
+			CompletionTokens: r.EvalCount,
+			TotalTokens:      r.PromptEvalCount + r.EvalCount,
+		},
+	}
+}
+
+func (p *localProvider) request(req LLMRequest, stream bool) map[string]interface{} {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	options := map[string]interface{}{}
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	body := map[string]interface{}{
+		"model":    model,
+		"messages": chatMessages(req),
+		"stream":   stream,
+		"options":  options,
+	}
+	if req.JSON {
+		body["format"] = "json"
+	}
+	return body
+}
+
+func (p *localProvider) Complete(ctx context.Context, req LLMRequest) (*AIOutput, error) {
+	var resp localChatResponse
+	if err := callJSON(ctx, p.client, p.baseURL+"/api/chat", nil, p.request(req, false), &resp); err != nil {
+		return nil, fmt.Errorf("local model: %w", err)
+	}
+	return resp.output(resp.Message.Content), nil
+}
+
+// Stream reads the completion as newline-delimited JSON objects.
+func (p *localProvider) Stream(ctx context.Context, req LLMRequest, onDelta func(string)) (*AIOutput, error) {
+	if p.noStream {
+		return completeAsStream(ctx, p, req, onDelta)
+	}
+	resp, err := postJSON(ctx, p.client, p.baseURL+"/api/chat", nil, p.request(req, true))
+	if err != nil {
+		return nil, fmt.Errorf("local model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var last localChatResponse
+	var chunkErr error
+	err = readLines(resp.Body, func(line string) bool {
+		if strings.TrimSpace(line) == "" {
+			return true
+		}
+		var chunk localChatResponse
+		if chunkErr = json.Unmarshal([]byte(line), &chunk); chunkErr != nil {
+			return false
+		}
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		last = chunk
+		return !chunk.Done
+	})
+	if err == nil {
+		err = chunkErr
+	}
+	if err == nil && !last.Done {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return streamFailed(ctx, &AIOutput{Model: last.Model}, text.String(), err)
+	}
+	return last.output(text.String()), nil
+}
@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIProvider(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", 401)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{
+			"model": "gpt-4o-mini-2024",
+			"choices": [{"message": {"role": "assistant", "content": "Web4 is here."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 4, "total_tokens": 16}
+		}`))
+	}))
+	defer srv.Close()
+	t.Setenv("TEST_OPENAI_KEY", "sk-test")
+
+	p, err := newLLMProvider(LLMConfig{Type: "openai", BaseURL: srv.URL + "/v1", APIKeyEnv: "TEST_OPENAI_KEY", Model: "gpt-4o-mini"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := 0.2
+	out, err := p.Complete(context.Background(), LLMRequest{SystemPrompt: "Be brief.", Prompt: "What is Web4?", Temperature: &temp, MaxTokens: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AIOutput{Text: "Web4 is here.", Model: "gpt-4o-mini-2024", FinishReason: "stop", Usage: TokenUsage{12, 4, 16}}
+	if *out != want {
+		t.Fatalf("output = %+v, want %+v", *out, want)
+	}
+
+	if got["model"] != "gpt-4o-mini" || got["temperature"] != 0.2 || got["max_tokens"] != float64(64) {
+		t.Fatalf("request = %v", got)
+	}
+	msgs := got["messages"].([]interface{})
+	if len(msgs) != 2 || msgs[0].(map[string]interface{})["role"] != "system" {
+		t.Fatalf("messages = %v", msgs)
+	}
+}
+
+func TestOpenAIProviderError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"rate limited"}}`, 429)
+	}))
+	defer srv.Close()
+
+	p, _ := newLLMProvider(LLMConfig{Type: "openai", BaseURL: srv.URL}, srv.Client())
+	if _, err := p.Complete(context.Background(), LLMRequest{Prompt: "hi"}); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("err = %v, want status 429", err)
+	}
+}
+
+func TestLocalProvider(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":"ok"},"done":true,"done_reason":"length","prompt_eval_count":5,"eval_count":2}`))
+	}))
+	defer srv.Close()
+
+	p, _ := newLLMProvider(LLMConfig{Type: "local", BaseURL: srv.URL, Model: "llama3"}, srv.Client())
+	out, err := p.Complete(context.Background(), LLMRequest{Prompt: "hi", MaxTokens: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AIOutput{Text: "ok", Model: "llama3", FinishReason: "length", Usage: TokenUsage{5, 2, 7}}
+	if *out != want {
+		t.Fatalf("output = %+v, want %+v", *out, want)
+	}
+	if got["stream"] != false || got["options"].(map[string]interface{})["num_predict"] != float64(2) {
+		t.Fatalf("request = %v", got)
+	}
+}
+
+func TestTaskAIProviderAlias(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":   req.Model,
+			"message": map[string]string{"role": "assistant", "content": "from " + req.Model},
+		})
+	}))
+	defer srv.Close()
+
+	p, _ := newLLMProvider(LLMConfig{Type: "local", BaseURL: srv.URL, Model: "llama3"}, srv.Client())
+	defer func(old map[string]LLMProvider) { defaultEnv.llmProviders = old }(defaultEnv.llmProviders)
+	defaultEnv.llmProviders = map[string]LLMProvider{"edge": p}
+
+	out, err := taskAI(context.Background(), TaskSpec{Type: "ai", Payload: "hi", AI: &AIOptions{Provider: "edge", Model: "phi3"}}, nil)
+	if err != nil || out.Text != "from phi3" {
+		t.Fatalf("taskAI = %+v, %v", out, err)
+	}
+	if _, err := taskAI(context.Background(), TaskSpec{Type: "ai", Payload: "hi"}, nil); err == nil {
+		t.Fatal("expected error without a default provider")
+	}
+}
+
+func TestOpenAIProviderStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] != true {
+			http.Error(w, "expected stream", 400)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"model":"m","choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"model":"m","choices":[{"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+			`{"model":"m","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	p, _ := newLLMProvider(LLMConfig{Type: "openai", BaseURL: srv.URL}, srv.Client())
+	var deltas []string
+	out, err := p.(StreamingLLMProvider).Stream(context.Background(), LLMRequest{Prompt: "hi"}, func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AIOutput{Text: "Hello", Model: "m", FinishReason: "stop", Usage: TokenUsage{3, 2, 5}}
+	if *out != want || strings.Join(deltas, "|") != "Hel|lo" {
+		t.Fatalf("output = %+v deltas = %q", *out, deltas)
+	}
+}
+
+func TestLocalProviderStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model":"llama3","message":{"content":"a"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"llama3","message":{"content":"b"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"llama3","message":{"content":""},"done":true,"done_reason":"stop","prompt_eval_count":1,"eval_count":2}`)
+	}))
+	defer srv.Close()
+
+	p, _ := newLLMProvider(LLMConfig{Type: "local", BaseURL: srv.URL}, srv.Client())
+	var deltas []string
+	out, err := p.(StreamingLLMProvider).Stream(context.Background(), LLMRequest{Prompt: "hi"}, func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AIOutput{Text: "ab", Model: "llama3", FinishReason: "stop", Usage: TokenUsage{1, 2, 3}}
+	if *out != want || len(deltas) != 2 {
+		t.Fatalf("output = %+v deltas = %q", *out, deltas)
+	}
+}
+
+func TestStreamKeepsPartialTextOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"partial answer\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, _ := newLLMProvider(LLMConfig{Type: "openai", BaseURL: srv.URL}, srv.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := p.(StreamingLLMProvider).Stream(ctx, LLMRequest{Prompt: "hi"}, func(string) { cancel() })
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if out == nil || out.Text != "partial answer" || out.FinishReason != "canceled" {
+		t.Fatalf("output = %+v, want partial text", out)
+	}
+}
+
+func TestLLMConfigNeverPrintsKeys(t *testing.T) {
+	cfg := Config{LLMProviders: map[string]LLMConfig{
+		"literal": {Type: "openai", APIKey: "sk-live-123456"},
+		"ref":     {Type: "openai", APIKey: "${secret:openai/prod}"},
+	}}
+	for _, out := range []string{fmt.Sprintf("%+v", cfg), fmt.Sprintf("%#v", cfg)} {
+		if strings.Contains(out, "sk-live") || !strings.Contains(out, "${secret:openai/prod}") {
+			t.Fatalf("output: %s", out)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ---------------- PROMETHEUS METRICS ----------------
+
+// metrics are the collectors of one Env. NewEnv registers them with the
+// registry it is given; environments sharing a registry share them.
+type metrics struct {
+	taskSuccess        *prometheus.CounterVec
+	taskFailure        *prometheus.CounterVec
+	breakerState       *prometheus.GaugeVec
+	breakerTransitions *prometheus.CounterVec
+	breakerRejected    *prometheus.CounterVec
+	authFailures       *prometheus.CounterVec
+	namespaceRunning   *prometheus.GaugeVec
+	namespaceWaiting   *prometheus.GaugeVec
+	quotaExceeded      *prometheus.CounterVec
+	aiTokensUsed       *prometheus.CounterVec
+	gasSpent           *prometheus.CounterVec
+	costUSD            *prometheus.CounterVec
+	budgetExceeded     *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		taskSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_task_success_total", Help: "Successful tasks",
+		}, []string{"task_type", "namespace"}),
+
+		taskFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_task_failure_total", Help: "Failed tasks",
+		}, []string{"task_type", "namespace"}),
+
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "web4_circuit_breaker_state", Help: "Circuit breaker state by dependency, 1 for the current state",
+		}, []string{"dependency", "state"}),
+
+		breakerTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_circuit_breaker_transitions_total", Help: "Circuit breaker state changes",
+		}, []string{"dependency", "state"}),
+
+		breakerRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_circuit_breaker_rejected_total", Help: "Calls and tasks turned away by an open circuit breaker",
+		}, []string{"dependency"}),
+
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_api_auth_failures_total", Help: "Task API requests refused by authentication, by reason",
+		}, []string{"reason"}),
+
+		namespaceRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "web4_namespace_tasks_running", Help: "Tasks holding a namespace slot",
+		}, []string{"namespace"}),
+
+		namespaceWaiting: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "web4_namespace_tasks_waiting", Help: "Tasks waiting for a namespace slot",
+		}, []string{"namespace"}),
+
+		quotaExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_quota_exceeded_total", Help: "Tasks and calls turned away by a namespace quota",
+		}, []string{"namespace", "quota"}),
+
+		aiTokensUsed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_ai_tokens_total", Help: "LLM tokens used",
+		}, []string{"namespace"}),
+
+		gasSpent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_gas_spent_wei_total", Help: "Wei spent on gas by mined transactions",
+		}, []string{"namespace"}),
+
+		costUSD: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_cost_usd_total", Help: "What tasks cost in US dollars, by kind of usage",
+		}, []string{"namespace", "task_type", "kind"}),
+
+		budgetExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "web4_budget_exceeded_total", Help: "Tasks turned away by a spent cost budget",
+		}, []string{"budget"}),
+	}
+}
+
+// register adds m's collectors to reg. Where reg already has an equal
+// collector, m reports to that one instead.
+func (m *metrics) register(reg prometheus.Registerer) error {
+	var errs []error
+	for _, c := range []**prometheus.CounterVec{&m.taskSuccess, &m.taskFailure, &m.breakerTransitions, &m.breakerRejected,
+		&m.authFailures, &m.quotaExceeded, &m.aiTokensUsed, &m.gasSpent, &m.costUSD, &m.budgetExceeded} {
+		var err error
+		*c, err = registerShared(reg, *c)
+		errs = append(errs, err)
+	}
+	for _, g := range []**prometheus.GaugeVec{&m.breakerState, &m.namespaceRunning, &m.namespaceWaiting} {
+		var err error
+		*g, err = registerShared(reg, *g)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// registerShared registers c, or returns the collector reg already has in
+// its place.
+func registerShared[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	var dup prometheus.AlreadyRegisteredError
+	if errors.As(err, &dup) {
+		if existing, ok := dup.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// MetricsHandler serves the metrics of the default Prometheus registry,
+// where Setup registers the runner's.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
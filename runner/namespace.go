@@ -0,0 +1,397 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ---------------- NAMESPACES ----------------
+
+// DefaultNamespace holds tasks that name none.
+const DefaultNamespace = "default"
+
+// NamespaceConfig bounds what one namespace's tasks may use. Zero values
+// mean no limit. Usage is counted by each runner process; replicas sharing
+// a queue each enforce the limits on their own.
+type NamespaceConfig struct {
+	MaxConcurrent  int    `json:"max_concurrent,omitempty"`    // tasks running at once; more wait for a slot
+	TasksPerHour   int    `json:"tasks_per_hour,omitempty"`    // tasks started in any rolling hour
+	AITokensPerDay int    `json:"ai_tokens_per_day,omitempty"` // LLM tokens per UTC day
+	GasWeiPerDay   string `json:"gas_wei_per_day,omitempty"`   // gas spend per UTC day, in wei
+
+	BudgetUSDPerDay float64 `json:"budget_usd_per_day,omitempty"` // cost per UTC day, priced by Config.Costs
+}
+
+// ErrQuotaExceeded fails a task without retries.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError is returned for a task whose namespace has used up a quota.
+type QuotaError struct {
+	Namespace  string
+	Quota      string // tasks_per_hour, ai_tokens_per_day or gas_wei_per_day
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("namespace %s: %s %v, retry in %s", e.Namespace, e.Quota, ErrQuotaExceeded, e.RetryAfter.Round(time.Second))
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// namespaceOf is the namespace spec runs in.
+func namespaceOf(spec TaskSpec) string {
+	return namespaceOr(spec.Namespace)
+}
+
+func namespaceOr(ns string) string {
+	if ns == "" {
+		return DefaultNamespace
+	}
+	return ns
+}
+
+// allowsNamespace reports whether a list of namespaces, like a signer's or
+// a token's, includes ns. An empty list allows every namespace.
+func allowsNamespace(list []string, ns string) bool {
+	return len(list) == 0 || slices.Contains(list, namespaceOr(ns))
+}
+
+// namespacesOf maps the aliases of signer or LLM provider configs to the
+// namespaces that may use them.
+func namespacesOf[C interface{ namespaces() []string }](cfgs map[string]C) map[string][]string {
+	out := make(map[string][]string, len(cfgs))
+	for alias, c := range cfgs {
+		out[alias] = c.namespaces()
+	}
+	return out
+}
+
+// visible reports whether the request may see things of namespace ns.
+func visible(req *http.Request, ns string) bool {
+	p := principalFrom(req.Context())
+	return p == nil || allowsNamespace(p.Namespaces, ns)
+}
+
+// Quotas enforces the limits of each namespace.
+type Quotas struct {
+	cfgs    map[string]NamespaceConfig
+	gas     map[string]*big.Int // parsed GasWeiPerDay
+	now     func() time.Time
+	metrics *metrics
+
+	mu    sync.Mutex
+	usage map[string]*nsUsage
+}
+
+type nsUsage struct {
+	slots   chan struct{} // nil without MaxConcurrent
+	running int
+	waiting int
+	starts  []time.Time // within the last hour
+	day     string      // UTC date the daily counters are for
+	tokens  int
+	gasWei  *big.Int
+}
+
+// NewQuotas checks cfgs and returns their enforcer.
+func NewQuotas(cfgs map[string]NamespaceConfig) (*Quotas, error) {
+	q := &Quotas{cfgs: cfgs, gas: map[string]*big.Int{}, now: time.Now, metrics: newMetrics(), usage: map[string]*nsUsage{}}
+	for ns, c := range cfgs {
+		if !namespaceName.MatchString(ns) {
+			return nil, fmt.Errorf("namespace %q: want lowercase letters, digits and dashes", ns)
+		}
+		if c.GasWeiPerDay != "" {
+			wei, ok := new(big.Int).SetString(c.GasWeiPerDay, 10)
+			if !ok || wei.Sign() < 0 {
+				return nil, fmt.Errorf("namespace %q: invalid gas_wei_per_day %q", ns, c.GasWeiPerDay)
+			}
+			q.gas[ns] = wei
+		}
+	}
+	return q, nil
+}
+
+// Check reports an error for a namespace name tasks may not use: one that
+// is malformed or, once namespaces are configured, not one of them.
+func (q *Quotas) Check(ns string) error {
+	ns = namespaceOr(ns)
+	if !namespaceName.MatchString(ns) {
+		return fmt.Errorf("invalid namespace %q", ns)
+	}
+	if _, ok := q.cfgs[ns]; len(q.cfgs) > 0 && !ok && ns != DefaultNamespace {
+		return fmt.Errorf("unknown namespace %q", ns)
+	}
+	return nil
+}
+
+// get returns the usage of ns, starting a new day's counters when the date
+// changed. Callers hold q.mu.
+func (q *Quotas) get(ns string) *nsUsage {
+	u, ok := q.usage[ns]
+	if !ok {
+		u = &nsUsage{gasWei: new(big.Int)}
+		if n := q.cfgs[ns].MaxConcurrent; n > 0 {
+			u.slots = make(chan struct{}, n)
+		}
+		q.usage[ns] = u
+	}
+	if day := q.now().UTC().Format(time.DateOnly); u.day != day {
+		u.day, u.tokens, u.gasWei = day, 0, new(big.Int)
+	}
+	return u
+}
+
+// Acquire waits for one of the namespace's concurrent task slots and
+// returns the function that frees it. Schedulers take it before their own
+// slot, so a namespace at its limit cannot hold the shared ones. When ctx
+// ends first, the task is left to notice.
+func (q *Quotas) Acquire(ctx context.Context, ns string) (release func()) {
+	ns = namespaceOr(ns)
+	q.mu.Lock()
+	u := q.get(ns)
+	u.waiting++
+	q.mu.Unlock()
+	q.metrics.namespaceWaiting.WithLabelValues(ns).Inc()
+
+	got := true
+	if u.slots != nil {
+		select {
+		case u.slots <- struct{}{}:
+		case <-ctx.Done():
+			got = false
+		}
+	}
+	q.mu.Lock()
+	u.waiting--
+	if got {
+		u.running++
+	}
+	q.mu.Unlock()
+	q.metrics.namespaceWaiting.WithLabelValues(ns).Dec()
+	if !got {
+		return func() {}
+	}
+	q.metrics.namespaceRunning.WithLabelValues(ns).Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			u.running--
+			q.mu.Unlock()
+			q.metrics.namespaceRunning.WithLabelValues(ns).Dec()
+			if u.slots != nil {
+				<-u.slots
+			}
+		})
+	}
+}
+
+// Start counts a task start against the hourly quota, or returns a
+// *QuotaError when the namespace has used it up.
+func (q *Quotas) Start(ns string) error {
+	return q.tasks(namespaceOr(ns), true)
+}
+
+// CheckStart is Start without counting, to turn tasks away at submission.
+func (q *Quotas) CheckStart(ns string) error {
+	return q.tasks(namespaceOr(ns), false)
+}
+
+func (q *Quotas) tasks(ns string, count bool) error {
+	limit := q.cfgs[ns].TasksPerHour
+	if limit <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.get(ns)
+	now := q.now()
+	i := 0
+	for i < len(u.starts) && now.Sub(u.starts[i]) >= time.Hour {
+		i++
+	}
+	u.starts = u.starts[i:]
+	if len(u.starts) >= limit {
+		return q.exceeded(ns, "tasks_per_hour", u.starts[0].Add(time.Hour).Sub(now))
+	}
+	if count {
+		u.starts = append(u.starts, now)
+	}
+	return nil
+}
+
+// CheckAI returns a *QuotaError once the namespace has used its AI tokens
+// for the day. A call that starts under the limit may end over it.
+func (q *Quotas) CheckAI(ns string) error {
+	ns = namespaceOr(ns)
+	limit := q.cfgs[ns].AITokensPerDay
+	if limit <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.get(ns).tokens >= limit {
+		return q.exceeded(ns, "ai_tokens_per_day", q.untilTomorrow())
+	}
+	return nil
+}
+
+// AddAI counts LLM tokens used by the namespace.
+func (q *Quotas) AddAI(ns string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	ns = namespaceOr(ns)
+	q.mu.Lock()
+	q.get(ns).tokens += tokens
+	q.mu.Unlock()
+	q.metrics.aiTokensUsed.WithLabelValues(ns).Add(float64(tokens))
+}
+
+// CheckGas returns a *QuotaError once the namespace has spent its gas
+// budget for the day.
+func (q *Quotas) CheckGas(ns string) error {
+	ns = namespaceOr(ns)
+	limit, ok := q.gas[ns]
+	if !ok {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.get(ns).gasWei.Cmp(limit) >= 0 {
+		return q.exceeded(ns, "gas_wei_per_day", q.untilTomorrow())
+	}
+	return nil
+}
+
+// AddGas counts the gas a mined transaction cost the namespace.
+func (q *Quotas) AddGas(ns string, r *TxReceipt) {
+	price, ok := new(big.Int).SetString(r.EffectiveGasPrice, 10)
+	if !ok {
+		return
+	}
+	ns = namespaceOr(ns)
+	wei := price.Mul(price, new(big.Int).SetUint64(r.GasUsed))
+	q.mu.Lock()
+	u := q.get(ns)
+	u.gasWei = new(big.Int).Add(u.gasWei, wei)
+	q.mu.Unlock()
+	f, _ := new(big.Float).SetInt(wei).Float64()
+	q.metrics.gasSpent.WithLabelValues(ns).Add(f)
+}
+
+func (q *Quotas) untilTomorrow() time.Duration {
+	now := q.now().UTC()
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+func (q *Quotas) exceeded(ns, quota string, retryAfter time.Duration) error {
+	q.metrics.quotaExceeded.WithLabelValues(ns, quota).Inc()
+	return &QuotaError{Namespace: ns, Quota: quota, RetryAfter: retryAfter}
+}
+
+// NamespaceUsage is a namespace's quotas and what it used of them, as
+// served by GET /namespaces.
+type NamespaceUsage struct {
+	Namespace     string          `json:"namespace"`
+	Quota         NamespaceConfig `json:"quota"`
+	Running       int             `json:"running"`
+	Waiting       int             `json:"waiting"` // for a concurrent task slot
+	TasksLastHour int             `json:"tasks_last_hour"`
+	AITokensToday int             `json:"ai_tokens_today"`
+	GasWeiToday   string          `json:"gas_wei_today"`
+}
+
+// Usage returns every configured or used namespace, by name.
+func (q *Quotas) Usage() []NamespaceUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	names := map[string]bool{DefaultNamespace: true}
+	for ns := range q.cfgs {
+		names[ns] = true
+	}
+	for ns := range q.usage {
+		names[ns] = true
+	}
+	var out []NamespaceUsage
+	now := q.now()
+	for ns := range names {
+		u := q.get(ns)
+		recent := 0
+		for _, t := range u.starts {
+			if now.Sub(t) < time.Hour {
+				recent++
+			}
+		}
+		out = append(out, NamespaceUsage{
+			Namespace: ns, Quota: q.cfgs[ns], Running: u.running, Waiting: u.waiting,
+			TasksLastHour: recent, AITokensToday: u.tokens, GasWeiToday: u.gasWei.String(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Namespace < out[j].Namespace })
+	return out
+}
+
+// artifactVisible reports whether the request may see a: whether a task of
+// one of its namespaces produced it.
+func artifactVisible(req *http.Request, a *Artifact) bool {
+	p := principalFrom(req.Context())
+	if p == nil || len(p.Namespaces) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.Namespaces, a.InNamespace)
+}
+
+func serveNamespaces(q *Quotas) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		out := []NamespaceUsage{}
+		for _, u := range q.Usage() {
+			if visible(req, u.Namespace) {
+				out = append(out, u)
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// unrestricted reports whether the request sees every namespace, as it must
+// to read state they all share, like workers and circuit breakers.
+func unrestricted(req *http.Request) bool {
+	p := principalFrom(req.Context())
+	return p == nil || len(p.Namespaces) == 0
+}
+
+// forbiddenShared answers a request for state all namespaces share from a
+// token bound to some of them.
+func forbiddenShared(w http.ResponseWriter, req *http.Request) {
+	auditDenied(req, principalFrom(req.Context()), fmt.Errorf("%w *", ErrTokenNamespace))
+	http.Error(w, "forbidden: shared by all namespaces, needs a token not bound to any", http.StatusForbidden)
+}
+
+// forbiddenNamespace answers a request for a namespace the caller's token
+// is not bound to.
+func forbiddenNamespace(w http.ResponseWriter, req *http.Request, ns string) {
+	auditDenied(req, principalFrom(req.Context()), fmt.Errorf("%w %s", ErrTokenNamespace, namespaceOr(ns)))
+	http.Error(w, "forbidden: "+ErrTokenNamespace.Error()+" "+namespaceOr(ns), http.StatusForbidden)
+}
+
+// quotaError answers a submission turned away by a quota.
+func quotaError(w http.ResponseWriter, err error) {
+	var qe *QuotaError
+	if errors.As(err, &qe) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(qe.RetryAfter.Round(time.Second).Seconds())))
+	}
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
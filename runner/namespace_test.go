@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useQuotas(t *testing.T, cfgs map[string]NamespaceConfig) *Quotas {
+	t.Helper()
+	old := defaultEnv.quotas
+	t.Cleanup(func() { defaultEnv.quotas = old })
+	q, err := NewQuotas(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultEnv.quotas = q
+	return q
+}
+
+func TestQuotas(t *testing.T) {
+	q, err := NewQuotas(map[string]NamespaceConfig{
+		"team-a": {MaxConcurrent: 1, TasksPerHour: 2, AITokensPerDay: 100, GasWeiPerDay: "1000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	for ns, want := range map[string]bool{"team-a": true, "": true, "default": true, "team-b": false, "Team_A": false} {
+		if err := q.Check(ns); (err == nil) != want {
+			t.Errorf("Check(%q) = %v", ns, err)
+		}
+	}
+	if _, err := NewQuotas(map[string]NamespaceConfig{"x": {GasWeiPerDay: "lots"}}); err == nil {
+		t.Error("accepted an invalid gas budget")
+	}
+
+	// A second task waits for the namespace's only slot.
+	release := q.Acquire(t.Context(), "team-a")
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	q.Acquire(ctx, "team-a")()
+	if ctx.Err() == nil {
+		t.Fatal("acquired a slot over max_concurrent")
+	}
+	release()
+	q.Acquire(t.Context(), "team-a")()
+	q.Acquire(t.Context(), "other")() // no limit
+
+	// Tasks per hour: a rolling window.
+	for range 2 {
+		if err := q.Start("team-a"); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(10 * time.Minute)
+	}
+	var qe *QuotaError
+	if err := q.Start("team-a"); !errors.As(err, &qe) || qe.Quota != "tasks_per_hour" || qe.RetryAfter != 40*time.Minute {
+		t.Fatalf("third start: %v", err)
+	}
+	now = now.Add(40 * time.Minute)
+	if err := q.CheckStart("team-a"); err != nil {
+		t.Fatalf("after an hour: %v", err)
+	}
+
+	// Daily budgets end over the limit and reset at midnight UTC.
+	q.AddAI("team-a", 150)
+	if err := q.CheckAI("team-a"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckAI over budget: %v", err)
+	}
+	q.AddGas("team-a", &TxReceipt{GasUsed: 100, EffectiveGasPrice: "10"})
+	if err := q.CheckGas("team-a"); !errors.As(err, &qe) || qe.Quota != "gas_wei_per_day" || qe.RetryAfter != time.Hour {
+		t.Errorf("CheckGas over budget: %v", err)
+	}
+	if u := q.Usage(); len(u) != 3 || u[2].Namespace != "team-a" || u[2].AITokensToday != 150 || u[2].GasWeiToday != "1000" {
+		t.Errorf("Usage = %+v", u)
+	}
+	now = now.Add(time.Hour)
+	if err := errors.Join(q.CheckAI("team-a"), q.CheckGas("team-a")); err != nil {
+		t.Errorf("next day: %v", err)
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	useQuotas(t, map[string]NamespaceConfig{"team-a": {TasksPerHour: 1}, "team-b": {}})
+	s, _ := OpenTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	alice, _, _ := s.Create("alice", []string{ScopeSubmit}, []string{"team-a"}, 0)
+	bob, _, _ := s.Create("bob", []string{ScopeSubmit}, []string{"team-b"}, 0)
+	ops, _, _ := s.Create("ops", []string{ScopeRead}, nil, 0)
+	if _, _, err := s.Create("bad", []string{ScopeRead}, []string{"Team A"}, 0); err == nil {
+		t.Error("created a token with an invalid namespace")
+	}
+
+	reg := NewTaskRegistry()
+	srv := httptest.NewServer((&Authenticator{tokens: s}).Wrap(newAPIHandler(newAPIRunner(reg, defaultEnv, 0, 0), NewArtifactStore(t.TempDir()))))
+	defer srv.Close()
+	do := func(method, path, token, body string, out interface{}) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp
+	}
+
+	// A token's only namespace is the default for its submissions.
+	var sub Submitted
+	if resp := do("POST", "/tasks", alice, `{"type": "teleport"}`, &sub); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("submit: status %d", resp.StatusCode)
+	}
+	waitFor(t, func() bool { rec, _ := reg.Get(sub.ID); return rec.Status == "failed" })
+	if rec, _ := reg.Get(sub.ID); rec.Namespace != "team-a" {
+		t.Errorf("namespace = %q", rec.Namespace)
+	}
+	for _, tc := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"POST", "/tasks", alice, `{"type": "teleport", "namespace": "team-b"}`, http.StatusForbidden},
+		{"POST", "/tasks", bob, `{"type": "teleport", "namespace": "team-c"}`, http.StatusBadRequest},
+		{"GET", fmt.Sprintf("/tasks/%d", sub.ID), bob, "", http.StatusNotFound},
+		{"POST", fmt.Sprintf("/tasks/%d/retry", sub.ID), bob, "", http.StatusNotFound},
+		{"GET", fmt.Sprintf("/tasks/%d", sub.ID), ops, "", http.StatusOK},
+		{"POST", "/tasks", bob, `{"type": "teleport"}`, http.StatusAccepted},
+	} {
+		if got := do(tc.method, tc.path, tc.token, tc.body, nil).StatusCode; got != tc.want {
+			t.Errorf("%s %s as %.12s: status %d, want %d", tc.method, tc.path, tc.token, got, tc.want)
+		}
+	}
+
+	// The task started counts against team-a's hourly quota.
+	resp := do("POST", "/tasks", alice, `{"type": "teleport"}`, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("over quota: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	var recs []TaskRecord
+	do("GET", "/tasks", bob, "", &recs)
+	if len(recs) != 1 || recs[0].Namespace != "team-b" {
+		t.Errorf("bob sees %+v", recs)
+	}
+	do("GET", "/tasks?namespace=team-a", ops, "", &recs)
+	if len(recs) != 1 || recs[0].ID != sub.ID {
+		t.Errorf("ops sees %+v in team-a", recs)
+	}
+	var usage []NamespaceUsage
+	do("GET", "/namespaces", alice, "", &usage)
+	if len(usage) != 1 || usage[0].Namespace != "team-a" || usage[0].TasksLastHour != 1 {
+		t.Errorf("alice's namespaces = %+v", usage)
+	}
+}
+
+func TestSharedStateNamespaces(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+	sharedQueue.Store(q)
+	defer sharedQueue.Store(nil)
+	q.Enqueue(t.Context(), "", TaskSpec{Type: "ai", Namespace: "team-a"})
+	q.Enqueue(t.Context(), "", TaskSpec{Type: "ai", Namespace: "team-b"})
+
+	s, _ := OpenTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	alice, _, _ := s.Create("alice", []string{ScopeAdmin}, []string{"team-a"}, 0)
+	ops, _, _ := s.Create("ops", []string{ScopeRead}, nil, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /queue", serveQueue)
+	mux.HandleFunc("GET /workers", serveWorkers)
+	mux.HandleFunc("GET /breakers", serveBreakers(defaultEnv.breakers))
+	srv := httptest.NewServer((&Authenticator{tokens: s}).Wrap(mux))
+	defer srv.Close()
+	get := func(path, token string, out interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	var recs []QueueRecord
+	if get("/queue", alice, &recs); len(recs) != 1 || recs[0].Namespace != "team-a" {
+		t.Errorf("alice's queue = %+v", recs)
+	}
+	if get("/queue", ops, &recs); len(recs) != 2 {
+		t.Errorf("ops's queue = %+v", recs)
+	}
+	for _, path := range []string{"/workers", "/breakers"} {
+		if code := get(path, alice, nil); code != http.StatusForbidden {
+			t.Errorf("%s as alice: status %d", path, code)
+		}
+		if code := get(path, ops, nil); code != http.StatusOK {
+			t.Errorf("%s as ops: status %d", path, code)
+		}
+	}
+
+	n, _ := NewNotifier([]WebhookConfig{{URL: "http://127.0.0.1:1/hook", MaxAttempts: 1}}, http.DefaultClient)
+	n.Notify(n.TaskEvent(EventTaskFailed, TaskRecord{ID: 1, Namespace: "team-a"}))
+	n.Notify(n.TaskEvent(EventTaskFailed, TaskRecord{ID: 2, Namespace: "team-b"}))
+	n.Notify(n.RunFinishedEvent(NewTaskRegistry()))
+	if got := n.Deliveries("", "", func(ns string) bool { return ns == "team-a" }); len(got) != 1 || got[0].Namespace != "team-a" {
+		t.Errorf("team-a deliveries = %+v", got)
+	}
+	n.Wait(t.Context())
+}
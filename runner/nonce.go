@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NonceSource is where the nonce manager resyncs from; ethclient.Client and
+// ChainBackend both satisfy it.
+type NonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// NonceManager hands out sequential nonces per account so blockchain tasks
+// running concurrently under the same key don't collide. After any send
+// error the account is resynced from the chain's pending nonce on the next
+// call to Next.
+type NonceManager struct {
+	mu       sync.Mutex
+	accounts map[common.Address]*accountNonces
+}
+
+type accountNonces struct {
+	mu     sync.Mutex
+	next   uint64
+	synced bool
+}
+
+func NewNonceManager() *NonceManager {
+	return &NonceManager{accounts: map[common.Address]*accountNonces{}}
+}
+
+func (m *NonceManager) account(addr common.Address) *accountNonces {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[addr]
+	if !ok {
+		a = &accountNonces{}
+		m.accounts[addr] = a
+	}
+	return a
+}
+
+// Next returns the nonce to use for the account's next transaction.
+func (m *NonceManager) Next(ctx context.Context, src NonceSource, addr common.Address) (uint64, error) {
+	a := m.account(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.synced {
+		pending, err := src.PendingNonceAt(ctx, addr)
+		if err != nil {
+			return 0, err
+		}
+		a.next, a.synced = pending, true
+	}
+	n := a.next
+	a.next++
+	return n, nil
+}
+
+// Release gives back a nonce whose transaction was never accepted. If it was
+// the last one handed out it is reused directly; otherwise there is now a
+// gap, so the account resyncs.
+func (m *NonceManager) Release(addr common.Address, nonce uint64) {
+	a := m.account(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.synced && a.next == nonce+1 {
+		a.next = nonce
+		return
+	}
+	a.synced = false
+}
+
+// Reset forces the account to resync from the chain on the next call to Next.
+func (m *NonceManager) Reset(addr common.Address) {
+	a := m.account(addr)
+	a.mu.Lock()
+	a.synced = false
+	a.mu.Unlock()
+}
+
+// Reconcile handles a SendTransaction error for a nonce obtained from Next.
+// It reports whether the error means the nonce was already taken on chain,
+// in which case the caller should ask for a fresh nonce and send again.
+func (m *NonceManager) Reconcile(addr common.Address, nonce uint64, err error) bool {
+	if isNonceTooLow(err) || isReplacementUnderpriced(err) {
+		m.Reset(addr)
+		return true
+	}
+	m.Release(addr, nonce)
+	return false
+}
+
+func isNonceTooLow(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+func isReplacementUnderpriced(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "replacement transaction underpriced")
+}
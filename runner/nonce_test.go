@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type fakeNonceSource struct {
+	mu      sync.Mutex
+	pending uint64
+	calls   int
+	err     error
+}
+
+func (f *fakeNonceSource) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.pending, f.err
+}
+
+var testAccount = common.HexToAddress("0x01")
+
+func TestNonceManagerSequentialUnderConcurrency(t *testing.T) {
+	src := &fakeNonceSource{pending: 5}
+	m := NewNonceManager()
+
+	var mu sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := m.Next(context.Background(), src, testAccount)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			got = append(got, int(n))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(got)
+	for i, n := range got {
+		if n != 5+i {
+			t.Fatalf("nonces = %v, want 5..24 without gaps or duplicates", got)
+		}
+	}
+	if src.calls != 1 {
+		t.Fatalf("PendingNonceAt called %d times, want 1", src.calls)
+	}
+}
+
+func TestNonceManagerRelease(t *testing.T) {
+	src := &fakeNonceSource{pending: 0}
+	m := NewNonceManager()
+	ctx := context.Background()
+
+	a, _ := m.Next(ctx, src, testAccount)
+	m.Release(testAccount, a)
+	if n, _ := m.Next(ctx, src, testAccount); n != a {
+		t.Fatalf("after releasing the last nonce got %d, want %d reused", n, a)
+	}
+
+	b, _ := m.Next(ctx, src, testAccount)
+	m.Next(ctx, src, testAccount)
+	m.Release(testAccount, b) // leaves a gap behind the nonce after it
+	src.pending = b
+	if n, _ := m.Next(ctx, src, testAccount); n != b {
+		t.Fatalf("after a gap got %d, want resync to %d", n, b)
+	}
+	if src.calls != 2 {
+		t.Fatalf("PendingNonceAt called %d times, want 2", src.calls)
+	}
+}
+
+func TestNonceManagerReconcile(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		err   error
+		retry bool
+	}{
+		{errors.New("nonce too low: next nonce 9, tx nonce 3"), true},
+		{errors.New("replacement transaction underpriced"), true},
+		{errors.New("insufficient funds for gas * price + value"), false},
+	} {
+		src := &fakeNonceSource{pending: 3}
+		m := NewNonceManager()
+		n, _ := m.Next(ctx, src, testAccount)
+		src.pending = 9
+
+		if retry := m.Reconcile(testAccount, n, tc.err); retry != tc.retry {
+			t.Errorf("Reconcile(%q) = %v, want %v", tc.err, retry, tc.retry)
+		}
+		next, _ := m.Next(ctx, src, testAccount)
+		if tc.retry && next != 9 {
+			t.Errorf("after %q got nonce %d, want resync to 9", tc.err, next)
+		}
+		if !tc.retry && next != 3 {
+			t.Errorf("after %q got nonce %d, want released nonce 3", tc.err, next)
+		}
+	}
+}
+
+func TestNonceManagerResyncError(t *testing.T) {
+	src := &fakeNonceSource{err: errors.New("rpc down")}
+	m := NewNonceManager()
+	if _, err := m.Next(context.Background(), src, testAccount); err == nil {
+		t.Fatal("expected error while the chain is unreachable")
+	}
+	src.err, src.pending = nil, 4
+	if n, err := m.Next(context.Background(), src, testAccount); err != nil || n != 4 {
+		t.Fatalf("Next = %d, %v; want 4", n, err)
+	}
+}
+
+// Concurrent blockchain tasks sharing a key each get their own nonce, and a
+// nonce taken behind the manager's back is reconciled.
+func TestSendAndConfirmConcurrentNonces(t *testing.T) {
+	chain := newFakeChain()
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0xaa")
+
+	// Another process used nonce 0 with the same key; the node rejects it once.
+	rejected := false
+	chain.sendErr = func(tx *types.Transaction) error {
+		if tx.Nonce() == 0 && !rejected {
+			rejected = true
+			chain.nonces[from] = 1
+			return fmt.Errorf("nonce too low: next nonce 1, tx nonce 0")
+		}
+		return nil
+	}
+
+	opts := testOpts()
+	opts.Confirmations = 1
+	const n = 4
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := sendAndConfirm(context.Background(), chain, NewMemorySigner(key), to, nil, opts)
+			errc <- err
+		}()
+	}
+	waitFor(t, func() bool { return chain.poolSize() == n })
+
+	chain.mu.Lock()
+	seen := map[uint64]bool{}
+	for _, tx := range chain.pool {
+		if seen[tx.Nonce()] || tx.Nonce() == 0 {
+			t.Errorf("nonce %d reused or not reconciled", tx.Nonce())
+		}
+		seen[tx.Nonce()] = true
+	}
+	chain.mu.Unlock()
+
+	chain.mineAll()
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"text/template"
+)
+
+// ---------------- PIPELINE ----------------
+
+// PipelineTask is a node of a task tree: once it succeeds, its Next tasks
+// are started. The task's fields sit next to id and next in JSON:
+//
+//	{"id": 1, "type": "download", "payload": "https://...", "next": [...]}
+type PipelineTask struct {
+	ID int `json:"id"`
+	TaskSpec
+	Next []PipelineTask `json:"next,omitempty"`
+}
+
+// NextFunc decides which tasks follow a successful task, given its output.
+type NextFunc func(parent PipelineTask, output interface{}) []PipelineTask
+
+// StaticNext follows the Next lists written in the config.
+func StaticNext(parent PipelineTask, _ interface{}) []PipelineTask {
+	return parent.Next
+}
+
+// RunTree runs roots and everything next generates from them, at most
+// maxConcurrency tasks at a time, and returns when the whole tree is done.
+// The tasks run in the command's environment and report to its registry.
+func RunTree(ctx context.Context, roots []PipelineTask, maxConcurrency, maxRetries, maxDepth int, next NextFunc) {
+	r := defaultRunner()
+	r.sem, r.maxRetries = make(chan struct{}, max(maxConcurrency, 1)), maxRetries
+	r.RunTree(ctx, roots, maxDepth, next)
+}
+
+// RunTree runs roots and everything next generates from them, sharing the
+// runner's concurrency limit and retries with its submitted tasks, and
+// returns when the whole tree is done. Chains deeper than maxDepth are cut
+// off; 0 means no limit. Tasks without an ID, or whose ID is taken in the
+// runner's store, get the next free one.
+func (r *Runner) RunTree(ctx context.Context, roots []PipelineTask, maxDepth int, next NextFunc) {
+	tr := &treeRun{
+		run:      r,
+		ctx:      ctx,
+		maxDepth: maxDepth,
+		next:     next,
+		used:     map[int]bool{},
+	}
+	for _, rec := range r.store.List() {
+		tr.used[rec.ID] = true
+	}
+	for _, root := range roots {
+		tr.spawn(root, 1, 0, nil)
+	}
+	tr.wg.Wait()
+}
+
+type treeRun struct {
+	run      *Runner
+	ctx      context.Context
+	maxDepth int
+	next     NextFunc
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	used   map[int]bool
+	lastID int
+}
+
+// pipeline counts the tasks of the tree grown from one root, for the
+// pipeline.finished event. It is guarded by treeRun.mu.
+type pipeline struct {
+	summary PipelineSummary
+	pending int // tasks started and not yet done
+}
+
+// done records a finished task of p and sends pipeline.finished after the
+// last one.
+func (tr *treeRun) done(p *pipeline, ok bool) {
+	tr.mu.Lock()
+	p.pending--
+	if ok {
+		p.summary.Succeeded++
+	} else {
+		p.summary.Failed++
+	}
+	finished := p.pending == 0
+	tr.mu.Unlock()
+	if finished {
+		tr.run.notifier.Notify(tr.run.notifier.PipelineEvent(p.summary))
+	}
+}
+
+// assignID keeps a task's own ID when it is free.
+func (tr *treeRun) assignID(id int) int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if id == 0 || tr.used[id] {
+		for id = tr.lastID + 1; tr.used[id]; id++ {
+		}
+	}
+	tr.used[id] = true
+	tr.lastID = max(tr.lastID, id)
+	return id
+}
+
+// spawn runs pt and then its follow-up tasks, which stay in pt's namespace
+// unless they name their own. p is the tree pt belongs to, nil for a root.
+func (tr *treeRun) spawn(pt PipelineTask, depth, parent int, p *pipeline) {
+	pt.ID = tr.assignID(pt.ID)
+	r := tr.run
+	r.mu.Lock()
+	r.nextID = max(r.nextID, pt.ID) // Submit goes on after the tree's IDs
+	r.mu.Unlock()
+	r.store.Add(pt.ID, pt.Type)
+	if st, ok := r.store.(tracker); ok {
+		st.SetNamespace(pt.ID, namespaceOf(pt.TaskSpec))
+		if parent != 0 {
+			st.SetParent(pt.ID, parent)
+		}
+	}
+	if p == nil {
+		p = &pipeline{summary: PipelineSummary{Root: pt.ID, Namespace: namespaceOf(pt.TaskSpec)}}
+	}
+	tr.mu.Lock()
+	p.pending++
+	p.summary.Tasks++
+	tr.mu.Unlock()
+	tr.wg.Add(1)
+	go func() {
+		defer tr.wg.Done()
+		var (
+			out interface{}
+			err error
+		)
+		// Children are spawned before this runs, so the tree is only done
+		// after its last task.
+		defer func() { tr.done(p, err == nil) }()
+		release := r.env.quotas.Acquire(tr.ctx, pt.Namespace)
+		r.sem <- struct{}{}
+		out, err = r.runTask(tr.ctx, Task{ID: pt.ID, Spec: pt.TaskSpec, MaxRetries: r.maxRetries})
+		<-r.sem
+		release()
+		if err != nil {
+			return
+		}
+		children := tr.next(pt, out)
+		if tr.maxDepth > 0 && depth >= tr.maxDepth && len(children) > 0 {
+			tr.run.log(pt.ID, 0, fmt.Sprintf("Max depth %d reached, skipping %d follow-up tasks", tr.maxDepth, len(children)))
+			return
+		}
+		for _, child := range children {
+			if child.Namespace == "" {
+				child.Namespace = pt.Namespace
+			}
+			tr.spawn(child, depth+1, pt.ID, p)
+		}
+	}()
+}
+
+// ---------------- DYNAMIC RULES ----------------
+
+// Rule generates follow-up tasks when a task of type After succeeds and its
+// output matches When. The payloads of Then are Go templates over
+// RuleContext.
+type Rule struct {
+	After string     `json:"after"`
+	When  string     `json:"when,omitempty"` // regexp on the output text, default: any non-empty output
+	Then  []TaskSpec `json:"then"`
+}
+
+// RuleContext is the data rule payload templates are rendered with.
+type RuleContext struct {
+	Output string       // text form of the parent's output
+	Result interface{}  // the parent's output as returned by the task
+	Parent PipelineTask // the task that succeeded
+}
+
+// DefaultRules chain AI, blockchain and storage tasks: AI content is minted
+// and stored, and every transaction gets an AI analysis.
+func DefaultRules() []Rule {
+	return []Rule{
+		{After: "ai", Then: []TaskSpec{
+			{Type: "blockchain", Payload: "mintNFT:0xContract"},
+			{Type: "storage", Payload: "/tmp/content.txt"},
+		}},
+		{After: "blockchain", Then: []TaskSpec{
+			{Type: "ai", Payload: "Analyze tx {{.Output}}"},
+		}},
+	}
+}
+
+// RulesNext turns rules into a NextFunc. Rules that fail to compile or
+// render are reported as errors up front or logged against the parent.
+func RulesNext(rules []Rule) (NextFunc, error) {
+	cs, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return func(parent PipelineTask, out interface{}) []PipelineTask {
+		text := outputText(out)
+		data := RuleContext{Output: text, Result: out, Parent: parent}
+		var next []PipelineTask
+		for _, c := range cs {
+			if c.After != parent.Type || !c.matches(text) {
+				continue
+			}
+			specs, errs := c.then(data)
+			for _, err := range errs {
+				logTask(parent.ID, 0, fmt.Sprintf("Rule for %s: %v", c.After, err))
+			}
+			for _, spec := range specs {
+				next = append(next, PipelineTask{TaskSpec: spec})
+			}
+		}
+		return next
+	}, nil
+}
+
+type compiledRule struct {
+	Rule
+	when     *regexp.Regexp
+	payloads []*template.Template
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	var cs []compiledRule
+	for i, r := range rules {
+		c := compiledRule{Rule: r}
+		if r.When != "" {
+			re, err := regexp.Compile(r.When)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: when: %w", i, err)
+			}
+			c.when = re
+		}
+		for j, spec := range r.Then {
+			tmpl, err := template.New(fmt.Sprintf("rule %d then %d", i, j)).Parse(spec.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			c.payloads = append(c.payloads, tmpl)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// matches reports whether the rule fires for a parent output's text.
+func (c compiledRule) matches(text string) bool {
+	if c.when == nil {
+		return text != ""
+	}
+	return c.when.MatchString(text)
+}
+
+// then renders the rule's follow-up tasks. Tasks whose payload fails to
+// render are left out and their errors returned.
+func (c compiledRule) then(data RuleContext) ([]TaskSpec, []error) {
+	var specs []TaskSpec
+	var errs []error
+	for j, spec := range c.Then {
+		var buf bytes.Buffer
+		if err := c.payloads[j].Execute(&buf, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		spec.Payload = buf.String()
+		specs = append(specs, spec)
+	}
+	return specs, errs
+}
+
+// outputText is the short text form of a task output that rules match on.
+func outputText(out interface{}) string {
+	switch o := out.(type) {
+	case nil:
+		return ""
+	case string:
+		return o
+	case *AIOutput:
+		return o.Text
+	case *TxReceipt:
+		return o.TxHash
+	case *StorageOutput:
+		if o.CID != "" {
+			return o.CID
+		}
+		return o.Path
+	case *DownloadOutput:
+		return o.Path
+	case *ExecOutput:
+		return o.Stdout
+	case *HTTPTaskOutput:
+		return compactJSON(o.Fields)
+	default:
+		return compactJSON(o)
+	}
+}
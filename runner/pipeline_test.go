@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func useRegistry(t *testing.T) *TaskRegistry {
+	t.Helper()
+	old, oldStore := defaultEnv.tasks, defaultEnv.artifacts
+	t.Cleanup(func() { defaultEnv.tasks, defaultEnv.artifacts = old, oldStore })
+	defaultEnv.tasks = NewTaskRegistry()
+	defaultEnv.artifacts = NewArtifactStore(t.TempDir())
+	return defaultEnv.tasks
+}
+
+func TestRunTreeStatic(t *testing.T) {
+	reg := useRegistry(t)
+	roots := []PipelineTask{
+		{ID: 1, TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/a.txt"}, Next: []PipelineTask{
+			{TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/b.txt"}},
+			{ID: 1, TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/c.txt"}}, // duplicate ID
+		}},
+		{ID: 7, TaskSpec: TaskSpec{Type: "bogus"}, Next: []PipelineTask{
+			{TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/never.txt"}},
+		}},
+	}
+	RunTree(context.Background(), roots, 2, 2, 0, StaticNext)
+
+	var ids []int
+	status := map[int]string{}
+	for _, rec := range reg.List() {
+		ids = append(ids, rec.ID)
+		status[rec.ID] = rec.Status
+	}
+	slices.Sort(ids)
+	if want := []int{1, 7, 8, 9}; !slices.Equal(ids, want) {
+		t.Fatalf("task ids = %v, want %v", ids, want)
+	}
+	if status[1] != "success" || status[8] != "success" || status[9] != "success" {
+		t.Errorf("statuses = %v", status)
+	}
+	rec, _ := reg.Get(7)
+	if rec.Status != "failed" || rec.Attempts != 1 {
+		t.Errorf("unknown type: status %s after %d attempts, want failed after 1", rec.Status, rec.Attempts)
+	}
+}
+
+func TestRunTreeRules(t *testing.T) {
+	reg := useRegistry(t)
+	next, err := RulesNext([]Rule{
+		{After: "storage", When: `^/tmp/`, Then: []TaskSpec{
+			{Type: "storage", Payload: "{{.Output}}x"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []PipelineTask{{TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/a"}}}
+	RunTree(context.Background(), roots, 3, 0, 3, next)
+
+	var payloads []string
+	for _, rec := range reg.List() {
+		out, _ := rec.Output.(*StorageOutput)
+		if out == nil {
+			t.Fatalf("task %d: output %#v", rec.ID, rec.Output)
+		}
+		payloads = append(payloads, out.Path)
+	}
+	slices.Sort(payloads)
+	if want := []string{"/tmp/a", "/tmp/ax", "/tmp/axx"}; !slices.Equal(payloads, want) {
+		t.Fatalf("payloads = %v, want %v", payloads, want)
+	}
+}
+
+func TestRulesNext(t *testing.T) {
+	next, err := RulesNext(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := next(PipelineTask{TaskSpec: TaskSpec{Type: "blockchain"}}, &TxReceipt{TxHash: "0xabc"})
+	if len(got) != 1 || got[0].Type != "ai" || got[0].Payload != "Analyze tx 0xabc" {
+		t.Fatalf("blockchain follow-ups = %+v", got)
+	}
+	if got := next(PipelineTask{TaskSpec: TaskSpec{Type: "ai"}}, &AIOutput{}); len(got) != 0 {
+		t.Fatalf("empty AI output generated %+v", got)
+	}
+	if got := next(PipelineTask{TaskSpec: TaskSpec{Type: "ai"}}, &AIOutput{Text: "hi"}); len(got) != 2 {
+		t.Fatalf("AI follow-ups = %+v", got)
+	}
+
+	if _, err := RulesNext([]Rule{{After: "ai", When: "("}}); err == nil {
+		t.Error("bad regexp accepted")
+	}
+	if _, err := RulesNext([]Rule{{After: "ai", Then: []TaskSpec{{Payload: "{{.Output"}}}}); err == nil {
+		t.Error("bad template accepted")
+	}
+}
+
+func TestRunnersKeepTheirTrees(t *testing.T) {
+	echo := ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+		return spec.Payload, nil
+	})
+	a := New(Options{Executor: echo, Logger: discard})
+	b := New(Options{Executor: echo, Logger: discard})
+	root := []PipelineTask{{TaskSpec: TaskSpec{Type: "echo", Payload: "x"}, Next: []PipelineTask{
+		{TaskSpec: TaskSpec{Type: "echo", Payload: "y"}},
+	}}}
+	a.RunTree(context.Background(), root, 0, StaticNext)
+	b.RunTree(context.Background(), root, 0, StaticNext)
+
+	for name, r := range map[string]*Runner{"a": a, "b": b} {
+		recs := r.Store().List()
+		if len(recs) != 2 {
+			t.Fatalf("runner %s has %d tasks, want 2", name, len(recs))
+		}
+		if child, _ := r.Store().Get(2); child.Parent != 1 || child.Status != "success" {
+			t.Errorf("runner %s child = %+v", name, child)
+		}
+	}
+	// Submit continues after the tree's IDs.
+	id, _ := a.Submit(context.Background(), TaskSpec{Type: "echo"})
+	if id != 3 {
+		t.Errorf("Submit after RunTree = %d, want 3", id)
+	}
+	a.Wait(id)
+}
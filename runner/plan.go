@@ -0,0 +1,418 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// ---------------- PLAN ----------------
+
+// Plan is what a run mode would execute for a config, worked out without
+// calling any network, chain or storage.
+type Plan struct {
+	Mode           string     `json:"mode"`
+	MaxConcurrency int        `json:"max_concurrency"`
+	MaxRetries     int        `json:"max_retries"`
+	MaxDepth       int        `json:"max_depth,omitempty"`
+	Waves          int        `json:"waves"`
+	Steps          []PlanStep `json:"steps"`
+	Skipped        int        `json:"skipped,omitempty"`  // follow-up tasks beyond MaxDepth
+	Problems       []string   `json:"problems,omitempty"` // config-wide
+}
+
+// PlanStep is one task of a plan. Tasks run in waves: a wave holds at most
+// MaxConcurrency tasks, and a task's follow-ups run in a later wave than
+// the task itself.
+type PlanStep struct {
+	ID        int      `json:"id"`
+	Parent    int      `json:"parent,omitempty"`
+	Depth     int      `json:"depth"`
+	Wave      int      `json:"wave"`
+	Type      string   `json:"type"`
+	Payload   string   `json:"payload"`
+	Namespace string   `json:"namespace,omitempty"`
+	Prompt    string   `json:"prompt,omitempty"`    // rendered prompt template of an ai task
+	Rule      string   `json:"rule,omitempty"`      // the rule that generates the task
+	Condition string   `json:"condition,omitempty"` // regexp the parent's output must match for the rule to fire
+	Problems  []string `json:"problems,omitempty"`
+}
+
+// placeholderOutput stands in for a task's output where rule templates
+// refer to it. Payloads containing it are only known at run time.
+func placeholderOutput(id int) string {
+	return fmt.Sprintf("<output of task %d>", id)
+}
+
+const placeholderPrefix = "<output of task "
+
+// MakePlan expands the tasks mode would run for cfg: the batch for run,
+// serve and worker, the task trees for pipeline and, for dynamic, the trees
+// extended by the follow-up rules with placeholder outputs. Every task is
+// checked the way its executor would before its first call. An empty mode
+// is picked from what the config defines.
+func MakePlan(cfg Config, mode string) (*Plan, error) {
+	if mode == "" {
+		switch {
+		case len(cfg.Rules) > 0:
+			mode = "dynamic"
+		case len(cfg.Pipeline) > 0:
+			mode = "pipeline"
+		default:
+			mode = "run"
+		}
+	}
+	p := &planner{
+		cfg:     cfg,
+		prompts: &PromptLibrary{Dir: defaultEnv.prompts.Dir},
+		plan:    &Plan{Mode: mode, MaxConcurrency: max(cfg.MaxConcurrency, 1), MaxRetries: cfg.MaxRetries},
+		ids:     &treeRun{used: map[int]bool{}},
+	}
+	var err error
+	if p.quotas, err = NewQuotas(cfg.Namespaces); err != nil {
+		p.plan.Problems = append(p.plan.Problems, err.Error())
+		p.quotas, _ = NewQuotas(nil)
+	}
+	if cfg.PromptsDir != "" {
+		p.prompts.Dir = cfg.PromptsDir
+	}
+	for alias, c := range cfg.LLMProviders {
+		if _, err := newLLMProvider(c, nil); err != nil {
+			p.plan.Problems = append(p.plan.Problems, fmt.Sprintf("llm provider %s: %v", alias, err))
+		}
+	}
+
+	switch mode {
+	case "run", "serve", "worker":
+		var queue []PipelineTask
+		for i, spec := range cfg.Tasks {
+			queue = append(queue, PipelineTask{ID: i, TaskSpec: spec})
+		}
+		p.schedule(queue, false)
+	case "pipeline":
+		p.schedule(p.roots(), true)
+	case "dynamic":
+		rules := cfg.Rules
+		if len(rules) == 0 {
+			rules = DefaultRules()
+		}
+		var err error
+		if p.rules, err = compileRules(rules); err != nil {
+			return nil, err
+		}
+		p.plan.MaxDepth = cfg.MaxDepth
+		if p.plan.MaxDepth <= 0 {
+			p.plan.MaxDepth = defaultMaxDepth
+		}
+		p.schedule(p.roots(), true)
+	default:
+		return nil, fmt.Errorf("plan: unknown mode %q", mode)
+	}
+	return p.plan, nil
+}
+
+type planner struct {
+	cfg     Config
+	prompts *PromptLibrary
+	rules   []compiledRule
+	plan    *Plan
+	ids     *treeRun // hands out IDs the way RunTree does
+	quotas  *Quotas  // checks namespace names
+}
+
+// planned is a task waiting for its wave.
+type planned struct {
+	PipelineTask
+	parent, depth   int
+	rule, condition string
+}
+
+func (p *planner) roots() []PipelineTask {
+	roots := pipelineRoots(p.cfg)
+	for i := range roots {
+		roots[i].ID = p.ids.assignID(roots[i].ID)
+	}
+	return roots
+}
+
+// schedule runs the tasks in waves as if every task took the same time:
+// each wave takes the next MaxConcurrency ready tasks, and their follow-ups
+// become ready after it.
+func (p *planner) schedule(roots []PipelineTask, tree bool) {
+	var ready []planned
+	for _, pt := range roots {
+		ready = append(ready, planned{PipelineTask: pt, depth: 1})
+	}
+	for wave := 1; len(ready) > 0; wave++ {
+		n := min(len(ready), p.plan.MaxConcurrency)
+		batch := ready[:n]
+		ready = ready[n:]
+		for _, t := range batch {
+			step := PlanStep{
+				ID: t.ID, Parent: t.parent, Depth: t.depth, Wave: wave, Type: t.Type, Payload: t.Payload,
+				Namespace: t.Namespace, Rule: t.rule, Condition: t.condition,
+			}
+			step.Prompt, step.Problems = p.check(t.TaskSpec)
+			p.plan.Steps = append(p.plan.Steps, step)
+			p.plan.Waves = wave
+			if tree {
+				ready = append(ready, p.next(t)...)
+			}
+		}
+	}
+}
+
+// next is the follow-ups of t: its Next list and, in dynamic mode, what the
+// rules generate from a placeholder output.
+func (p *planner) next(t planned) []planned {
+	var children []planned
+	for _, pt := range t.Next {
+		children = append(children, planned{PipelineTask: pt})
+	}
+	out := placeholderOutput(t.ID)
+	data := RuleContext{Output: out, Result: out, Parent: t.PipelineTask}
+	for i, c := range p.rules {
+		if c.After != t.Type {
+			continue
+		}
+		specs, errs := c.then(data)
+		for _, err := range errs {
+			p.plan.Problems = append(p.plan.Problems, fmt.Sprintf("rule %d after task %d: %v", i, t.ID, err))
+		}
+		for _, spec := range specs {
+			children = append(children, planned{PipelineTask: PipelineTask{TaskSpec: spec}, rule: fmt.Sprintf("rule %d", i), condition: c.When})
+		}
+	}
+	if p.plan.MaxDepth > 0 && t.depth >= p.plan.MaxDepth && len(children) > 0 {
+		p.plan.Skipped += len(children)
+		return nil
+	}
+	for i := range children {
+		if children[i].Namespace == "" {
+			children[i].Namespace = t.Namespace
+		}
+		children[i].ID = p.ids.assignID(children[i].ID)
+		children[i].parent, children[i].depth = t.ID, t.depth+1
+	}
+	return children
+}
+
+// check validates spec the way its executor would before its first call,
+// and renders its prompt template.
+func (p *planner) check(spec TaskSpec) (prompt string, problems []string) {
+	add := func(err error) { problems = append(problems, err.Error()) }
+	known := !strings.Contains(spec.Payload, placeholderPrefix)
+	if err := p.quotas.Check(spec.Namespace); err != nil {
+		add(err)
+	}
+	if err := checkSecretRefs(spec); err != nil {
+		add(err)
+	}
+	switch spec.Type {
+	case "download":
+		if known {
+			if err := checkURL(spec.Payload); err != nil {
+				add(err)
+			}
+		}
+	case "http":
+		if known {
+			if err := checkURL(spec.Payload); err != nil {
+				add(err)
+			}
+		}
+		for _, err := range checkHTTPTask(spec) {
+			add(err)
+		}
+	case "ai":
+		var opts AIOptions
+		if spec.AI != nil {
+			opts = *spec.AI
+		}
+		if opts.Template != "" {
+			var rendered *RenderedPrompt
+			var err error
+			if opts, _, rendered, err = applyTemplate(p.prompts, spec, opts); err != nil {
+				add(err)
+			} else {
+				prompt = rendered.Prompt
+			}
+		}
+		alias := opts.Provider
+		if alias == "" {
+			alias = defaultLLM
+		}
+		if c, ok := llmConfigs(p.cfg.LLMProviders)[alias]; !ok {
+			add(fmt.Errorf("no LLM provider configured for alias %q", alias))
+		} else if !allowsNamespace(c.Namespaces, spec.Namespace) {
+			add(fmt.Errorf("LLM provider %q is not available in namespace %s", alias, namespaceOf(spec)))
+		}
+		if len(opts.ResponseSchema) > 0 {
+			if _, err := ParseSchema(opts.ResponseSchema); err != nil {
+				add(err)
+			}
+		}
+	case "blockchain":
+		if os.Getenv("ETH_RPC_URL") == "" && (spec.Blockchain == nil || spec.Blockchain.RPCURL == "") {
+			add(fmt.Errorf("ETH_RPC_URL missing"))
+		}
+		if known {
+			if _, _, err := parseContractCall(spec.Payload, spec.Blockchain); err != nil {
+				add(err)
+			}
+		}
+		opts := spec.Blockchain.withDefaults()
+		alias := opts.Signer
+		if alias == "" {
+			alias = defaultSigner
+		}
+		if c, ok := p.cfg.Signers[alias]; !ok {
+			add(fmt.Errorf("no signer configured for alias %q", alias))
+		} else if !allowsNamespace(c.Namespaces, spec.Namespace) {
+			add(fmt.Errorf("signer %q is not available in namespace %s", alias, namespaceOf(spec)))
+		}
+		if opts.Value != "" {
+			if _, ok := new(big.Int).SetString(opts.Value, 10); !ok {
+				add(fmt.Errorf("blockchain value %q: want wei in decimal", opts.Value))
+			}
+		}
+	case "storage":
+		if spec.Payload == "" {
+			add(fmt.Errorf("storage task: no file path"))
+		}
+	case "exec":
+		if spec.Payload == "" {
+			add(fmt.Errorf("exec task: no command"))
+		}
+	default:
+		add(fmt.Errorf("%w %s", errUnknownTaskType, spec.Type))
+	}
+	return prompt, problems
+}
+
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("payload %q: want an http:// or https:// URL", redactURL(raw))
+	}
+	return nil
+}
+
+// checkHTTPTask renders an http task's body and auth on a request that is
+// never sent, and compiles its expectations and extractions.
+func checkHTTPTask(spec TaskSpec) []error {
+	if spec.Request == nil {
+		return nil
+	}
+	opts := *spec.Request
+	var errs []error
+	body, err := renderBody(opts.Body, opts.Vars)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	req, err := http.NewRequest(strings.ToUpper(opts.Method), "http://plan.invalid/", nil)
+	if err != nil {
+		errs = append(errs, err)
+	} else if err := applyAuth(req, opts.Auth, []byte(body)); err != nil {
+		errs = append(errs, err)
+	}
+	for h, re := range opts.Expect.Headers {
+		if _, err := regexp.Compile(re); err != nil {
+			errs = append(errs, fmt.Errorf("expect header %s: %w", h, err))
+		}
+	}
+	for _, a := range opts.Expect.JSON {
+		if _, err := parseJSONPath(a.Path); err != nil {
+			errs = append(errs, err)
+		}
+		if a.Matches != "" {
+			if _, err := regexp.Compile(a.Matches); err != nil {
+				errs = append(errs, fmt.Errorf("expect %s: %w", a.Path, err))
+			}
+		}
+	}
+	for field, path := range opts.Extract {
+		if _, err := parseJSONPath(path); err != nil {
+			errs = append(errs, fmt.Errorf("extract %s: %w", field, err))
+		}
+	}
+	return errs
+}
+
+// Err reports whether any task or setting of the plan would fail.
+func (p *Plan) Err() error {
+	n := len(p.Problems)
+	for _, s := range p.Steps {
+		n += len(s.Problems)
+	}
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("plan: %d problems", n)
+}
+
+// WriteText prints the plan as a table of waves followed by its problems.
+func (p *Plan) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Plan for %s: %d tasks in %d waves, max concurrency %d, max retries %d", p.Mode, len(p.Steps), p.Waves, p.MaxConcurrency, p.MaxRetries)
+	if p.MaxDepth > 0 {
+		fmt.Fprintf(w, ", max depth %d", p.MaxDepth)
+	}
+	fmt.Fprint(w, "\n\n")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WAVE\tTASK\tTYPE\tAFTER\tPAYLOAD\tNOTE")
+	for _, s := range p.Steps {
+		after := ""
+		if s.Parent != 0 {
+			after = fmt.Sprint(s.Parent)
+		}
+		var note []string
+		if s.Rule != "" {
+			note = append(note, s.Rule)
+		}
+		if s.Condition != "" {
+			note = append(note, "if output matches "+s.Condition)
+		}
+		if len(s.Problems) > 0 {
+			note = append(note, "PROBLEM")
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", s.Wave, s.ID, s.Type, after, shorten(s.Payload, 60), strings.Join(note, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if p.Skipped > 0 {
+		fmt.Fprintf(w, "\n%d follow-up tasks beyond max depth %d are not run.\n", p.Skipped, p.MaxDepth)
+	}
+
+	if p.Err() != nil {
+		fmt.Fprintln(w, "\nProblems:")
+		for _, msg := range p.Problems {
+			fmt.Fprintf(w, "  %s\n", msg)
+		}
+		for _, s := range p.Steps {
+			for _, msg := range s.Problems {
+				fmt.Fprintf(w, "  task %d (%s): %s\n", s.ID, s.Type, msg)
+			}
+		}
+	}
+	return nil
+}
+
+// shorten puts s on one line of at most n runes.
+func shorten(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
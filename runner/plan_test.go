@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPlanDynamic(t *testing.T) {
+	t.Setenv("ETH_RPC_URL", "http://localhost:8545")
+	cfg := Config{
+		MaxConcurrency: 2,
+		MaxDepth:       3,
+		Signers:        map[string]SignerConfig{"default": {}},
+		LLMProviders:   map[string]LLMConfig{"default": {Type: "local"}},
+		Pipeline: []PipelineTask{
+			{ID: 1, TaskSpec: TaskSpec{Type: "ai", Payload: "Write a poem"}},
+			{TaskSpec: TaskSpec{Type: "download", Payload: "https://example.com/a.json"}, Next: []PipelineTask{
+				{TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/a.json"}},
+			}},
+		},
+		Rules: []Rule{
+			{After: "ai", Then: []TaskSpec{{Type: "blockchain", Payload: "0x0000000000000000000000000000000000000001:mint(string)"}}},
+			{After: "blockchain", When: "^0x", Then: []TaskSpec{{Type: "ai", Payload: "Analyze tx {{.Output}}"}}},
+		},
+	}
+	p, err := MakePlan(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Err(); err != nil {
+		var buf bytes.Buffer
+		p.WriteText(&buf)
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+
+	type step struct{ id, parent, wave int }
+	want := []step{{1, 0, 1}, {2, 0, 1}, {3, 1, 2}, {4, 2, 2}, {5, 3, 3}}
+	if p.Mode != "dynamic" || p.Waves != 3 || len(p.Steps) != len(want) || p.Skipped != 1 {
+		t.Fatalf("plan = %+v", p)
+	}
+	for i, s := range p.Steps {
+		if got := (step{s.ID, s.Parent, s.Wave}); got != want[i] {
+			t.Errorf("step %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if s := p.Steps[4]; s.Payload != "Analyze tx <output of task 3>" || s.Rule != "rule 1" || s.Condition != "^0x" {
+		t.Errorf("generated step = %+v", s)
+	}
+}
+
+func TestPlanProblems(t *testing.T) {
+	t.Setenv("ETH_RPC_URL", "")
+	cfg := Config{
+		MaxConcurrency: 3,
+		Tasks: []TaskSpec{
+			{Type: "download", Payload: "ftp://example.com/a"},
+			{Type: "blockchain", Payload: "mintNFT:0xContract"},
+			{Type: "http", Payload: "https://example.com", Request: &HTTPTaskOptions{
+				Auth:    &HTTPAuth{Type: "bearer", TokenEnv: "PLAN_TEST_TOKEN"},
+				Extract: map[string]string{"id": "$..["},
+			}},
+			{Type: "storage", Payload: "/tmp/x"},
+			{Type: "teleport"},
+		},
+	}
+	p, err := MakePlan(cfg, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Waves != 2 || p.Steps[3].Wave != 2 || p.Steps[0].ID != 0 {
+		t.Errorf("waves = %d, steps = %+v", p.Waves, p.Steps)
+	}
+	counts := []int{1, 3, 2, 0, 1}
+	for i, s := range p.Steps {
+		if len(s.Problems) != counts[i] {
+			t.Errorf("task %d problems = %q, want %d", s.ID, s.Problems, counts[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Plan for run: 5 tasks in 2 waves", "task 4 (teleport): unknown task type teleport", "PLAN_TEST_TOKEN is empty"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text plan lacks %q:\n%s", want, buf.String())
+		}
+	}
+	if _, err := MakePlan(cfg, "nightly"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestPlanDefaultLLMFromEnv(t *testing.T) {
+	cfg := Config{Tasks: []TaskSpec{{Type: "ai", Payload: "Write a poem"}}}
+	t.Setenv("OPENAI_API_KEY", "")
+	if p, _ := MakePlan(cfg, "run"); p.Err() == nil {
+		t.Error("ai task planned without any LLM provider")
+	}
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	p, err := MakePlan(cfg, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("plan with OPENAI_API_KEY: %v", err)
+	}
+}
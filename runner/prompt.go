@@ -0,0 +1,192 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// PromptTemplate is a named, versioned prompt stored as
+// <prompts dir>/<name>@<version>.json. System and Prompt are Go templates
+// rendered with the task's vars; the other fields are defaults the task
+// can override.
+type PromptTemplate struct {
+	Name    string `json:"-"`
+	Version string `json:"-"`
+
+	System         string          `json:"system,omitempty"`
+	Prompt         string          `json:"prompt"`
+	Provider       string          `json:"provider,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+}
+
+// Ref is the template reference recorded with the output, e.g. "summarize@v3".
+func (t *PromptTemplate) Ref() string {
+	return t.Name + "@" + t.Version
+}
+
+// RenderedPrompt is what was actually sent for a templated ai task.
+type RenderedPrompt struct {
+	Template string                 `json:"template"`
+	System   string                 `json:"system,omitempty"`
+	Prompt   string                 `json:"prompt"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+}
+
+// PromptLibrary reads templates from a directory. Files are read on every
+// lookup so templates can be updated without restarting the runner.
+type PromptLibrary struct {
+	Dir string
+}
+
+// Lookup resolves "name@version", or "name" for the highest version.
+func (l *PromptLibrary) Lookup(ref string) (*PromptTemplate, error) {
+	name, version, _ := strings.Cut(ref, "@")
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.ContainsAny(version, `/\`) {
+		return nil, fmt.Errorf("invalid prompt template reference %q", ref)
+	}
+	if version == "" {
+		var err error
+		if version, err = l.latest(name); err != nil {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(l.Dir, name+"@"+version+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("prompt template %s@%s: %w", name, version, err)
+	}
+	t := &PromptTemplate{Name: name, Version: version}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("prompt template %s: %w", t.Ref(), err)
+	}
+	return t, nil
+}
+
+// Versions lists the versions of a template, oldest first.
+func (l *PromptLibrary) Versions(name string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(l.Dir, name+"@*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, f := range files {
+		v := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), name+"@"), ".json")
+		versions = append(versions, v)
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+func (l *PromptLibrary) latest(name string) (string, error) {
+	versions, err := l.Versions(name)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no prompt template named %q in %s", name, l.Dir)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// sortVersions orders tags like v2 < v10 < v10.1 by their numeric parts.
+func sortVersions(vs []string) {
+	less := func(a, b string) bool {
+		pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+		pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+		for i := 0; i < len(pa) && i < len(pb); i++ {
+			na, errA := strconv.Atoi(pa[i])
+			nb, errB := strconv.Atoi(pb[i])
+			switch {
+			case errA == nil && errB == nil && na != nb:
+				return na < nb
+			case (errA != nil || errB != nil) && pa[i] != pb[i]:
+				return pa[i] < pb[i]
+			}
+		}
+		return len(pa) < len(pb)
+	}
+	for i := 1; i < len(vs); i++ {
+		for j := i; j > 0 && less(vs[j], vs[j-1]); j-- {
+			vs[j], vs[j-1] = vs[j-1], vs[j]
+		}
+	}
+}
+
+// Render executes the template's system and user prompts. Referencing a
+// variable that wasn't given is an error rather than an empty string.
+func (t *PromptTemplate) Render(vars map[string]interface{}) (*RenderedPrompt, error) {
+	out := &RenderedPrompt{Template: t.Ref(), Vars: vars}
+	var err error
+	if out.System, err = renderText(t.Ref()+" system", t.System, vars); err != nil {
+		return nil, err
+	}
+	if out.Prompt, err = renderText(t.Ref(), t.Prompt, vars); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func renderText(name, text string, vars map[string]interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("prompt template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("prompt template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// applyTemplate resolves opts.Template from lib and fills the task's prompt and any
+// settings the task leaves unset from it. The payload is available to the
+// template as {{.payload}} unless a var of that name is given.
+func applyTemplate(lib *PromptLibrary, spec TaskSpec, opts AIOptions) (AIOptions, string, *RenderedPrompt, error) {
+	t, err := lib.Lookup(opts.Template)
+	if err != nil {
+		return opts, "", nil, err
+	}
+	vars := make(map[string]interface{}, len(opts.Vars)+1)
+	if spec.Payload != "" {
+		vars["payload"] = spec.Payload
+	}
+	for k, v := range opts.Vars {
+		vars[k] = v
+	}
+	rendered, err := t.Render(vars)
+	if err != nil {
+		return opts, "", nil, err
+	}
+
+	if opts.Provider == "" {
+		opts.Provider = t.Provider
+	}
+	if opts.Model == "" {
+		opts.Model = t.Model
+	}
+	if opts.Temperature == nil {
+		opts.Temperature = t.Temperature
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = t.MaxTokens
+	}
+	if len(opts.ResponseSchema) == 0 {
+		opts.ResponseSchema = t.ResponseSchema
+	}
+	if opts.SystemPrompt == "" {
+		opts.SystemPrompt = rendered.System
+	}
+	rendered.System = opts.SystemPrompt
+	return opts, rendered.Prompt, rendered, nil
+}
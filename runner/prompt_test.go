@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writePrompts(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := defaultEnv.prompts
+	t.Cleanup(func() { defaultEnv.prompts = old })
+	defaultEnv.prompts = &PromptLibrary{Dir: dir}
+}
+
+func TestPromptLibraryVersions(t *testing.T) {
+	writePrompts(t, map[string]string{
+		"summarize@v2.json":  `{"prompt": "two"}`,
+		"summarize@v10.json": `{"prompt": "ten"}`,
+		"summarize@v3.json":  `{"prompt": "three"}`,
+		"translate@v1.json":  `{"prompt": "other"}`,
+	})
+	versions, _ := defaultEnv.prompts.Versions("summarize")
+	if want := []string{"v2", "v3", "v10"}; !reflect.DeepEqual(versions, want) {
+		t.Fatalf("versions = %v, want %v", versions, want)
+	}
+	for ref, want := range map[string]string{"summarize": "ten", "summarize@v3": "three"} {
+		tmpl, err := defaultEnv.prompts.Lookup(ref)
+		if err != nil || tmpl.Prompt != want {
+			t.Fatalf("Lookup(%s) = %+v, %v", ref, tmpl, err)
+		}
+	}
+	for _, ref := range []string{"summarize@v4", "missing", "../etc@v1"} {
+		if _, err := defaultEnv.prompts.Lookup(ref); err == nil {
+			t.Fatalf("Lookup(%s): expected error", ref)
+		}
+	}
+}
+
+func TestTaskAITemplate(t *testing.T) {
+	writePrompts(t, map[string]string{
+		"summarize@v3.json": `{
+			"system": "You write for {{.audience}}.",
+			"prompt": "Summarize in {{.words}} words: {{.payload}}",
+			"model": "llama3:8b",
+			"max_tokens": 200
+		}`,
+	})
+	var got struct {
+		Model    string        `json:"model"`
+		Messages []chatMessage `json:"messages"`
+		Options  struct {
+			NumPredict int `json:"num_predict"`
+		} `json:"options"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"model":"llama3:8b","message":{"content":"Short."},"done":true}`))
+	}))
+	defer srv.Close()
+	p, _ := newLLMProvider(LLMConfig{Type: "local", BaseURL: srv.URL}, srv.Client())
+	defer func(old map[string]LLMProvider) { defaultEnv.llmProviders = old }(defaultEnv.llmProviders)
+	defaultEnv.llmProviders = map[string]LLMProvider{defaultLLM: p}
+
+	spec := TaskSpec{Type: "ai", Payload: "Web4 launches", AI: &AIOptions{
+		Template:  "summarize@v3",
+		Vars:      map[string]interface{}{"audience": "developers", "words": 50},
+		MaxTokens: 80,
+	}}
+	out, err := taskAI(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "llama3:8b" || got.Options.NumPredict != 80 {
+		t.Fatalf("request model = %q max = %d", got.Model, got.Options.NumPredict)
+	}
+	want := &RenderedPrompt{
+		Template: "summarize@v3",
+		System:   "You write for developers.",
+		Prompt:   "Summarize in 50 words: Web4 launches",
+		Vars:     map[string]interface{}{"audience": "developers", "words": 50, "payload": "Web4 launches"},
+	}
+	if !reflect.DeepEqual(out.Prompt, want) || got.Messages[1].Content != want.Prompt {
+		t.Fatalf("prompt = %+v, want %+v", out.Prompt, want)
+	}
+
+	spec.AI.Vars = nil
+	if _, err := taskAI(context.Background(), spec, nil); err == nil || !strings.Contains(err.Error(), "audience") {
+		t.Fatalf("err = %v, want missing var error", err)
+	}
+}
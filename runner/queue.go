@@ -0,0 +1,472 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ---------------- SHARED TASK QUEUE ----------------
+
+// QueueConfig enables the worker command: replicas claim tasks from a
+// shared SQLite file instead of each running cfg.Tasks themselves.
+type QueueConfig struct {
+	Path         string   `json:"path"`                     // SQLite file shared by all replicas
+	LeaseTTL     Duration `json:"lease_ttl,omitempty"`      // how long a claim lasts without a heartbeat, default 30s
+	Heartbeat    Duration `json:"heartbeat,omitempty"`      // lease renewal interval, default a third of LeaseTTL
+	PollInterval Duration `json:"poll_interval,omitempty"`  // how often idle workers look for tasks, default 1s
+	MaxClaims    int      `json:"max_claims,omitempty"`     // claims per task before an expired lease fails it, default 3
+	Capabilities []string `json:"capabilities,omitempty"`   // task types this worker runs, default all built-in types
+	Capacity     int      `json:"capacity,omitempty"`       // tasks this worker runs at once, default max_concurrency
+	LeaderTTL    Duration `json:"leader_ttl,omitempty"`     // lease of the replica running reaping and retention, default 15s
+	MaxClockSkew Duration `json:"max_clock_skew,omitempty"` // clock difference tolerated between replicas, default a fifth of LeaderTTL
+}
+
+// ErrLeaseLost is returned when a worker reports on a task it no longer
+// holds, because its lease expired and another worker claimed it.
+var ErrLeaseLost = errors.New("task lease lost")
+
+// QueuedTask is a task claimed from the queue.
+type QueuedTask struct {
+	ID        int      `json:"id"`
+	Key       string   `json:"key,omitempty"`
+	Spec      TaskSpec `json:"spec"`
+	Claims    int      `json:"claims"`
+	Reclaimed bool     `json:"reclaimed,omitempty"` // its previous lease expired
+}
+
+// QueueRecord is the shared state of one queued task.
+type QueueRecord struct {
+	ID         int             `json:"id"`
+	Key        string          `json:"key,omitempty"`
+	Type       string          `json:"type"`
+	Namespace  string          `json:"namespace"`
+	Status     string          `json:"status"` // queued, running, success, failed
+	Worker     string          `json:"worker,omitempty"`
+	LeaseUntil *time.Time      `json:"lease_until,omitempty"`
+	Claims     int             `json:"claims"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Updated    time.Time       `json:"updated"`
+}
+
+// taskRecord is rec as a task event payload.
+func (rec QueueRecord) taskRecord() TaskRecord {
+	t := TaskRecord{ID: rec.ID, Type: rec.Type, Namespace: rec.Namespace, Status: rec.Status,
+		Attempts: rec.Claims, Error: rec.Error, Log: []string{}}
+	if len(rec.Output) > 0 {
+		t.Output = rec.Output
+	}
+	if rec.Status == "success" || rec.Status == "failed" {
+		t.Finished = rec.Updated
+	}
+	return t
+}
+
+// WorkerInfo is a worker's entry in the worker registry.
+type WorkerInfo struct {
+	ID           string    `json:"id"`
+	Capabilities []string  `json:"capabilities"`
+	Capacity     int       `json:"capacity"`
+	Load         int       `json:"load"`
+	Status       string    `json:"status"` // active, stopped
+	Started      time.Time `json:"started"`
+	Heartbeat    time.Time `json:"heartbeat"`
+	Alive        bool      `json:"alive"` // active with a heartbeat within the lease TTL
+}
+
+// Queue is the task queue and worker registry shared by runner replicas.
+type Queue struct {
+	db        *sql.DB
+	leaseTTL  time.Duration
+	maxClaims int
+	now       func() time.Time
+}
+
+const queueSchema = `
+CREATE TABLE IF NOT EXISTS queue_tasks (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	key         TEXT UNIQUE,
+	type        TEXT NOT NULL,
+	spec        TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	worker      TEXT,
+	lease_until INTEGER,
+	claims      INTEGER NOT NULL DEFAULT 0,
+	output      TEXT,
+	error       TEXT,
+	created     INTEGER NOT NULL,
+	updated     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS queue_tasks_claim ON queue_tasks (status, type, id);
+CREATE TABLE IF NOT EXISTS queue_workers (
+	id           TEXT PRIMARY KEY,
+	capabilities TEXT NOT NULL,
+	capacity     INTEGER NOT NULL,
+	load         INTEGER NOT NULL,
+	status       TEXT NOT NULL,
+	started      INTEGER NOT NULL,
+	heartbeat    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS queue_leader (
+	name        TEXT PRIMARY KEY,
+	holder      TEXT NOT NULL,
+	token       INTEGER NOT NULL,
+	lease_until INTEGER NOT NULL
+);`
+
+// OpenQueue opens, and creates if needed, the queue in the SQLite file at
+// path.
+func OpenQueue(path string, leaseTTL time.Duration, maxClaims int) (*Queue, error) {
+	if path == "" {
+		return nil, fmt.Errorf("queue: path missing")
+	}
+	if leaseTTL <= 0 {
+		leaseTTL = 30 * time.Second
+	}
+	if maxClaims <= 0 {
+		maxClaims = 3
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(10000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("queue: %w", err)
+	}
+	if _, err := db.Exec(queueSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("queue: %w", err)
+	}
+	return &Queue{db: db, leaseTTL: leaseTTL, maxClaims: maxClaims, now: time.Now}, nil
+}
+
+func (q *Queue) Close() error { return q.db.Close() }
+
+func (q *Queue) nowMillis() int64 { return q.now().UnixMilli() }
+
+// Enqueue adds spec to the queue. A non-empty key makes the call
+// idempotent: when a task with that key exists, its ID is returned and
+// created is false.
+func (q *Queue) Enqueue(ctx context.Context, key string, spec TaskSpec) (id int, created bool, err error) {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return 0, false, err
+	}
+	var k interface{}
+	if key != "" {
+		k = key
+	}
+	now := q.nowMillis()
+	res, err := q.db.ExecContext(ctx, `INSERT INTO queue_tasks (key, type, spec, status, created, updated)
+		VALUES (?, ?, ?, 'queued', ?, ?) ON CONFLICT (key) DO NOTHING`, k, spec.Type, string(body), now, now)
+	if err != nil {
+		return 0, false, fmt.Errorf("queue: enqueue: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		last, err := res.LastInsertId()
+		return int(last), true, err
+	}
+	err = q.db.QueryRowContext(ctx, `SELECT id FROM queue_tasks WHERE key = ?`, key).Scan(&id)
+	return id, false, err
+}
+
+// Claim leases up to n tasks of the given types to worker. Queued tasks
+// come first in ID order, along with running tasks whose lease has expired.
+func (q *Queue) Claim(ctx context.Context, worker string, types []string, n int) ([]QueuedTask, error) {
+	if n <= 0 || len(types) == 0 {
+		return nil, nil
+	}
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("queue: claim: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := q.nowMillis()
+	args := []interface{}{now, q.maxClaims}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(args, n)
+	rows, err := tx.QueryContext(ctx, `SELECT id, COALESCE(key, ''), spec, status, claims FROM queue_tasks
+		WHERE (status = 'queued' OR (status = 'running' AND lease_until < ?)) AND claims < ?
+		AND type IN (?`+strings.Repeat(", ?", len(types)-1)+`)
+		ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("queue: claim: %w", err)
+	}
+	var claimed []QueuedTask
+	for rows.Next() {
+		var t QueuedTask
+		var spec, status string
+		if err := rows.Scan(&t.ID, &t.Key, &spec, &status, &t.Claims); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("queue: claim: %w", err)
+		}
+		if err := json.Unmarshal([]byte(spec), &t.Spec); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("queue: task %d: %w", t.ID, err)
+		}
+		t.Reclaimed = status == "running"
+		t.Claims++
+		claimed = append(claimed, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("queue: claim: %w", err)
+	}
+
+	lease := now + q.leaseTTL.Milliseconds()
+	for _, t := range claimed {
+		if _, err := tx.ExecContext(ctx, `UPDATE queue_tasks SET status = 'running', worker = ?, lease_until = ?,
+			claims = ?, error = NULL, updated = ? WHERE id = ?`, worker, lease, t.Claims, now, t.ID); err != nil {
+			return nil, fmt.Errorf("queue: claim: %w", err)
+		}
+	}
+	return claimed, tx.Commit()
+}
+
+// Heartbeat renews the leases of worker's tasks and records its load. It
+// returns the IDs of the tasks the worker still holds; a late heartbeat
+// keeps a task unless another worker has claimed it in the meantime.
+func (q *Queue) Heartbeat(ctx context.Context, worker string, load int) (map[int]bool, error) {
+	now := q.nowMillis()
+	if _, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET lease_until = ?, updated = ?
+		WHERE worker = ? AND status = 'running'`, now+q.leaseTTL.Milliseconds(), now, worker); err != nil {
+		return nil, fmt.Errorf("queue: heartbeat: %w", err)
+	}
+	if _, err := q.db.ExecContext(ctx, `UPDATE queue_workers SET heartbeat = ?, load = ?, status = 'active' WHERE id = ?`, now, load, worker); err != nil {
+		return nil, fmt.Errorf("queue: heartbeat: %w", err)
+	}
+	rows, err := q.db.QueryContext(ctx, `SELECT id FROM queue_tasks WHERE worker = ? AND status = 'running'`, worker)
+	if err != nil {
+		return nil, fmt.Errorf("queue: heartbeat: %w", err)
+	}
+	defer rows.Close()
+	held := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		held[id] = true
+	}
+	return held, rows.Err()
+}
+
+// Complete records the outcome of a task worker holds. ErrLeaseLost means
+// the outcome was dropped because the task has moved on.
+func (q *Queue) Complete(ctx context.Context, id int, worker string, out interface{}, taskErr error) error {
+	status, errText := "success", ""
+	if taskErr != nil {
+		status, errText = "failed", taskErr.Error()
+	}
+	var output interface{}
+	if out != nil {
+		b, err := json.Marshal(out)
+		if err != nil {
+			return fmt.Errorf("queue: task %d output: %w", id, err)
+		}
+		output = string(b)
+	}
+	res, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET status = ?, output = ?, error = ?, lease_until = NULL, updated = ?
+		WHERE id = ? AND worker = ? AND status = 'running'`, status, output, errText, q.nowMillis(), id, worker)
+	if err != nil {
+		return fmt.Errorf("queue: complete: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: task %d", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+// Release hands a task back to the queue without counting the claim, as
+// workers do for the tasks they are running when they shut down.
+func (q *Queue) Release(ctx context.Context, id int, worker string) error {
+	res, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET status = 'queued', worker = NULL, lease_until = NULL,
+		claims = MAX(claims - 1, 0), updated = ? WHERE id = ? AND worker = ? AND status = 'running'`, q.nowMillis(), id, worker)
+	if err != nil {
+		return fmt.Errorf("queue: release: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: task %d", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+// Cancel fails a queued or running task with ErrTaskCanceled. A worker
+// running it loses its lease and stops it at its next heartbeat.
+func (q *Queue) Cancel(ctx context.Context, id int) error {
+	res, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET status = 'failed', worker = NULL, lease_until = NULL,
+		error = ?, updated = ? WHERE id = ? AND status IN ('queued', 'running')`, ErrTaskCanceled.Error(), q.nowMillis(), id)
+	if err != nil {
+		return fmt.Errorf("queue: cancel: %w", err)
+	}
+	return q.changed(ctx, res, id)
+}
+
+// Requeue puts a failed task back in the queue with its claims reset.
+func (q *Queue) Requeue(ctx context.Context, id int) error {
+	res, err := q.db.ExecContext(ctx, `UPDATE queue_tasks SET status = 'queued', worker = NULL, lease_until = NULL,
+		claims = 0, output = NULL, error = NULL, updated = ? WHERE id = ? AND status = 'failed'`, q.nowMillis(), id)
+	if err != nil {
+		return fmt.Errorf("queue: requeue: %w", err)
+	}
+	return q.changed(ctx, res, id)
+}
+
+// changed tells why an update of task id matched no row.
+func (q *Queue) changed(ctx context.Context, res sql.Result, id int) error {
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var status string
+	err := q.db.QueryRowContext(ctx, `SELECT status FROM queue_tasks WHERE id = ?`, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w %d", ErrUnknownTask, id)
+	}
+	if err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
+	return fmt.Errorf("%w: task %d is %s", ErrTaskState, id, status)
+}
+
+// ReapExpired fails tasks whose lease expired after their last allowed
+// claim, so a task that keeps killing its workers stops being retried. It
+// is a leader duty and returns the tasks failed.
+func (q *Queue) ReapExpired(ctx context.Context, fence Fence) ([]QueueRecord, error) {
+	var ids []int
+	err := q.fenced(ctx, fence, func(tx *sql.Tx) error {
+		now := q.nowMillis()
+		rows, err := tx.QueryContext(ctx, `UPDATE queue_tasks SET status = 'failed', lease_until = NULL,
+			error = 'lease expired ' || claims || ' times', updated = ?
+			WHERE status = 'running' AND lease_until < ? AND claims >= ? RETURNING id`, now, now, q.maxClaims)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("queue: reap: %w", err)
+	}
+	var recs []QueueRecord
+	for _, id := range ids {
+		rec, err := q.Get(ctx, id)
+		if err != nil {
+			return recs, fmt.Errorf("queue: reap: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// Get returns the shared state of task id.
+func (q *Queue) Get(ctx context.Context, id int) (QueueRecord, error) {
+	recs, err := q.records(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return QueueRecord{}, err
+	}
+	if len(recs) == 0 {
+		return QueueRecord{}, fmt.Errorf("queue: task %d: %w", id, ErrUnknownTask)
+	}
+	return recs[0], nil
+}
+
+// List returns every queued task in ID order.
+func (q *Queue) List(ctx context.Context) ([]QueueRecord, error) {
+	return q.records(ctx, ``)
+}
+
+func (q *Queue) records(ctx context.Context, where string, args ...interface{}) ([]QueueRecord, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT id, COALESCE(key, ''), type,
+		COALESCE(json_extract(spec, '$.namespace'), ''), status, COALESCE(worker, ''), lease_until,
+		claims, output, COALESCE(error, ''), updated FROM queue_tasks `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("queue: %w", err)
+	}
+	defer rows.Close()
+	recs := []QueueRecord{}
+	for rows.Next() {
+		var rec QueueRecord
+		var lease sql.NullInt64
+		var output sql.NullString
+		var updated int64
+		if err := rows.Scan(&rec.ID, &rec.Key, &rec.Type, &rec.Namespace, &rec.Status, &rec.Worker, &lease, &rec.Claims, &output, &rec.Error, &updated); err != nil {
+			return nil, err
+		}
+		rec.Namespace = namespaceOr(rec.Namespace)
+		rec.Updated = time.UnixMilli(updated).UTC()
+		if lease.Valid {
+			t := time.UnixMilli(lease.Int64).UTC()
+			rec.LeaseUntil = &t
+		}
+		if output.Valid {
+			rec.Output = json.RawMessage(output.String)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// RegisterWorker adds w to the worker registry, or marks it active again.
+func (q *Queue) RegisterWorker(ctx context.Context, w WorkerInfo) error {
+	caps, err := json.Marshal(w.Capabilities)
+	if err != nil {
+		return err
+	}
+	now := q.nowMillis()
+	_, err = q.db.ExecContext(ctx, `INSERT INTO queue_workers (id, capabilities, capacity, load, status, started, heartbeat)
+		VALUES (?, ?, ?, 0, 'active', ?, ?)
+		ON CONFLICT (id) DO UPDATE SET capabilities = excluded.capabilities, capacity = excluded.capacity,
+		status = 'active', started = excluded.started, heartbeat = excluded.heartbeat`,
+		w.ID, string(caps), w.Capacity, now, now)
+	if err != nil {
+		return fmt.Errorf("queue: register worker: %w", err)
+	}
+	return nil
+}
+
+// DeregisterWorker marks the worker stopped. Its entry stays for history.
+func (q *Queue) DeregisterWorker(ctx context.Context, id string) error {
+	if _, err := q.db.ExecContext(ctx, `UPDATE queue_workers SET status = 'stopped', load = 0, heartbeat = ? WHERE id = ?`, q.nowMillis(), id); err != nil {
+		return fmt.Errorf("queue: deregister worker: %w", err)
+	}
+	return nil
+}
+
+// Workers returns the worker registry.
+func (q *Queue) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT id, capabilities, capacity, load, status, started, heartbeat FROM queue_workers ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("queue: %w", err)
+	}
+	defer rows.Close()
+	now := q.now()
+	workers := []WorkerInfo{}
+	for rows.Next() {
+		var w WorkerInfo
+		var caps string
+		var started, heartbeat int64
+		if err := rows.Scan(&w.ID, &caps, &w.Capacity, &w.Load, &w.Status, &started, &heartbeat); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(caps), &w.Capabilities)
+		w.Started, w.Heartbeat = time.UnixMilli(started).UTC(), time.UnixMilli(heartbeat).UTC()
+		w.Alive = w.Status == "active" && now.Sub(w.Heartbeat) < q.leaseTTL
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestQueue(t *testing.T, path string, ttl time.Duration) *Queue {
+	t.Helper()
+	q, err := OpenQueue(path, ttl, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// countingExecutor records how often each payload ran.
+type countingExecutor struct {
+	mu    sync.Mutex
+	runs  map[string]int
+	delay time.Duration
+}
+
+func (e *countingExecutor) Execute(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+	select {
+	case <-time.After(e.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runs[spec.Payload]++
+	return "done " + spec.Payload, nil
+}
+
+func TestWorkersShareQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	exec := &countingExecutor{runs: map[string]int{}, delay: 10 * time.Millisecond}
+	cfg := QueueConfig{Capabilities: []string{"count"}, Capacity: 2, PollInterval: Duration(10 * time.Millisecond)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var workers []*Worker
+	var wg sync.WaitGroup
+	for i := range 3 {
+		// Every replica enqueues the same config tasks.
+		q := openTestQueue(t, path, time.Minute)
+		for j := range 10 {
+			spec := TaskSpec{Type: "count", Payload: fmt.Sprint(j)}
+			if _, _, err := q.Enqueue(ctx, configTaskKey(j, spec), spec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w := NewWorker(q, New(Options{Executor: exec, Logger: discard}), cfg)
+		w.ID = fmt.Sprintf("w%d", i)
+		workers = append(workers, w)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Run(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	q := workers[0].queue
+	waitFor(t, func() bool {
+		recs, _ := q.List(ctx)
+		for _, rec := range recs {
+			if rec.Status != "success" {
+				return false
+			}
+		}
+		ws, _ := q.Workers(ctx)
+		return len(recs) == 10 && len(ws) == 3
+	})
+	workersSeen, _ := q.Workers(ctx)
+	if len(workersSeen) != 3 || !workersSeen[0].Alive || workersSeen[0].Capacity != 2 {
+		t.Errorf("workers = %+v", workersSeen)
+	}
+	cancel()
+	wg.Wait()
+
+	for j := range 10 {
+		if n := exec.runs[fmt.Sprint(j)]; n != 1 {
+			t.Errorf("task %d ran %d times", j, n)
+		}
+	}
+	workersSeen, _ = q.Workers(context.Background())
+	for _, w := range workersSeen {
+		if w.Status != "stopped" || w.Alive {
+			t.Errorf("worker after shutdown: %+v", w)
+		}
+	}
+	rec, _ := q.Get(context.Background(), 1)
+	if string(rec.Output) != `"done 0"` {
+		t.Errorf("output = %s", rec.Output)
+	}
+}
+
+func TestQueueLeases(t *testing.T) {
+	ctx := context.Background()
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+
+	id, created, err := q.Enqueue(ctx, "k", TaskSpec{Type: "count", Payload: "x"})
+	if err != nil || !created {
+		t.Fatal(created, err)
+	}
+	if again, created, _ := q.Enqueue(ctx, "k", TaskSpec{Type: "count"}); again != id || created {
+		t.Fatalf("second enqueue = %d, %v", again, created)
+	}
+	if got, _ := q.Claim(ctx, "a", []string{"other"}, 5); len(got) != 0 {
+		t.Fatalf("claimed a task of another type: %+v", got)
+	}
+	got, _ := q.Claim(ctx, "a", []string{"count"}, 5)
+	if len(got) != 1 || got[0].Spec.Payload != "x" || got[0].Claims != 1 {
+		t.Fatalf("claim = %+v", got)
+	}
+	if got, _ := q.Claim(ctx, "b", []string{"count"}, 5); len(got) != 0 {
+		t.Fatalf("leased task claimed again: %+v", got)
+	}
+
+	// A heartbeat keeps the lease alive past its first TTL.
+	now = now.Add(50 * time.Second)
+	if held, _ := q.Heartbeat(ctx, "a", 1); !held[id] {
+		t.Fatal("heartbeat lost the task")
+	}
+	now = now.Add(50 * time.Second)
+	if got, _ := q.Claim(ctx, "b", []string{"count"}, 5); len(got) != 0 {
+		t.Fatalf("renewed lease reclaimed: %+v", got)
+	}
+
+	// Worker a goes silent; b reclaims the task and a's result is dropped.
+	now = now.Add(2 * time.Minute)
+	got, _ = q.Claim(ctx, "b", []string{"count"}, 5)
+	if len(got) != 1 || !got[0].Reclaimed || got[0].Claims != 2 {
+		t.Fatalf("reclaim = %+v", got)
+	}
+	if held, _ := q.Heartbeat(ctx, "a", 1); held[id] {
+		t.Fatal("stale worker still holds the task")
+	}
+	if err := q.Complete(ctx, id, "a", "late", nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("stale Complete = %v", err)
+	}
+
+	// b dies too; with max_claims=2 the leader's reaper fails the task.
+	now = now.Add(2 * time.Minute)
+	if got, _ := q.Claim(ctx, "c", []string{"count"}, 5); len(got) != 0 {
+		t.Fatalf("claimed beyond max claims: %+v", got)
+	}
+	fence, ok, err := q.AcquireLeadership(ctx, "maintenance", "c", time.Minute)
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if _, err := q.ReapExpired(ctx, Fence{Name: "maintenance", Token: fence.Token - 1}); !errors.Is(err, ErrFenced) {
+		t.Fatalf("reap with stale fence = %v", err)
+	}
+	if reaped, err := q.ReapExpired(ctx, fence); len(reaped) != 1 || err != nil {
+		t.Fatalf("reap = %+v, %v", reaped, err)
+	}
+	rec, _ := q.Get(ctx, id)
+	if rec.Status != "failed" || rec.Error != "lease expired 2 times" {
+		t.Fatalf("record = %+v", rec)
+	}
+}
+
+func TestWorkerReleasesOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	q := openTestQueue(t, path, time.Minute)
+	id, _, _ := q.Enqueue(context.Background(), "", TaskSpec{Type: "count", Payload: "slow"})
+
+	exec := &countingExecutor{runs: map[string]int{}, delay: time.Hour}
+	w := NewWorker(q, New(Options{Executor: exec, Logger: discard}), QueueConfig{Capabilities: []string{"count"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	waitFor(t, func() bool { return w.Load() == 1 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := q.Get(context.Background(), id)
+	if rec.Status != "queued" || rec.Worker != "" || rec.Claims != 0 {
+		t.Fatalf("released task = %+v", rec)
+	}
+	local, _ := w.runner.store.Get(id)
+	if local.Status == "failed" {
+		t.Errorf("released task recorded as failed: %+v", local)
+	}
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --------------------- RUN MODES ---------------------
+
+// RunBatch runs cfg.Tasks side by side, at most cfg.MaxConcurrency at a
+// time, and returns when all of them have finished or ctx is canceled.
+func RunBatch(ctx context.Context, cfg Config) {
+	log.Printf("Web4 Job Runner Configuration: %s", redactions.Redact(fmt.Sprintf("%+v", cfg)))
+	for i, spec := range cfg.Tasks {
+		defaultEnv.tasks.Add(i, spec.Type)
+		defaultEnv.tasks.SetNamespace(i, namespaceOf(spec))
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(cfg.MaxConcurrency, 1))
+	for i, spec := range cfg.Tasks {
+		wg.Add(1)
+		go func(taskID int, ts TaskSpec) {
+			defer wg.Done()
+			defer defaultEnv.quotas.Acquire(ctx, ts.Namespace)()
+			sem <- struct{}{}
+			Task{ID: taskID, Spec: ts, MaxRetries: cfg.MaxRetries}.Run(ctx)
+			<-sem
+		}(i, spec)
+	}
+	wg.Wait()
+	finishRun(cfg)
+	log.Println("Web4 Job Runner complete!")
+}
+
+// RunPipeline runs the task trees in cfg.Pipeline: a task's next tasks start
+// once it has succeeded. Without a pipeline, cfg.Tasks are run as roots.
+func RunPipeline(ctx context.Context, cfg Config) {
+	log.Printf("Web4 Autonomous Pipeline Config: %s", redactions.Redact(fmt.Sprintf("%+v", cfg)))
+	RunTree(ctx, pipelineRoots(cfg), cfg.MaxConcurrency, cfg.MaxRetries, 0, StaticNext)
+	finishRun(cfg)
+	log.Println("Web4 Autonomous Pipeline complete!")
+}
+
+// RunDynamic runs cfg.Pipeline and lets cfg.Rules, or DefaultRules when
+// there are none, generate follow-up tasks from each task's output. Chains
+// stop after cfg.MaxDepth generations, 5 by default.
+func RunDynamic(ctx context.Context, cfg Config) error {
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	next, err := RulesNext(rules)
+	if err != nil {
+		return err
+	}
+	depth := cfg.MaxDepth
+	if depth <= 0 {
+		depth = defaultMaxDepth
+	}
+	log.Printf("Web4 Dynamic Pipeline Config: %s", redactions.Redact(fmt.Sprintf("%+v", cfg)))
+	RunTree(ctx, pipelineRoots(cfg), cfg.MaxConcurrency, cfg.MaxRetries, depth, func(parent PipelineTask, out interface{}) []PipelineTask {
+		return append(StaticNext(parent, out), next(parent, out)...)
+	})
+	finishRun(cfg)
+	log.Println("Web4 Dynamic Pipeline complete!")
+	return nil
+}
+
+const defaultMaxDepth = 5
+
+func pipelineRoots(cfg Config) []PipelineTask {
+	if len(cfg.Pipeline) > 0 {
+		return cfg.Pipeline
+	}
+	roots := make([]PipelineTask, len(cfg.Tasks))
+	for i, spec := range cfg.Tasks {
+		roots[i] = PipelineTask{ID: i, TaskSpec: spec}
+	}
+	return roots
+}
+
+// finishRun reports the end of a run to the webhooks and prunes artifacts.
+func finishRun(cfg Config) {
+	n := defaultEnv.notifier
+	ev := n.RunFinishedEvent(defaultEnv.tasks)
+	n.Notify(ev)
+	gcArtifacts(defaultEnv.artifacts, cfg.Retention)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := n.Wait(ctx); err != nil {
+		log.Println(err)
+	}
+	log.Printf("Run %s: Success=%d, Failure=%d", ev.RunID, ev.Run.Succeeded, ev.Run.Failed)
+}
+
+// Work adds cfg.Tasks to the shared queue in cfg.Queue, once across all
+// replicas with the same config, then claims and runs queued tasks until
+// ctx is canceled.
+func Work(ctx context.Context, cfg Config) error {
+	if cfg.Queue == nil {
+		return errors.New("worker: no queue configured")
+	}
+	q, err := OpenQueue(cfg.Queue.Path, time.Duration(cfg.Queue.LeaseTTL), cfg.Queue.MaxClaims)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	sharedQueue.Store(q)
+	defer sharedQueue.Store(nil)
+
+	for i, spec := range cfg.Tasks {
+		if _, _, err := q.Enqueue(ctx, configTaskKey(i, spec), spec); err != nil {
+			return err
+		}
+	}
+	r := defaultRunner()
+	r.maxRetries = cfg.MaxRetries
+	qc := *cfg.Queue
+	if qc.Capacity <= 0 {
+		qc.Capacity = cfg.MaxConcurrency
+	}
+	w := NewWorker(q, r, qc)
+
+	// Reaping and retention run on one replica at a time.
+	e := NewElector(q, "maintenance", w.ID, time.Duration(qc.LeaderTTL))
+	if qc.MaxClockSkew > 0 {
+		e.MaxClockSkew = time.Duration(qc.MaxClockSkew)
+	}
+	e.Duty(func(ctx context.Context, fence Fence) {
+		everyTick(ctx, w.PollInterval, func() {
+			reaped, err := q.ReapExpired(ctx, fence)
+			if err != nil {
+				log.Printf("[Leader %s] %v", fence.Name, err)
+			}
+			if len(reaped) > 0 {
+				log.Printf("[Leader %s] Failed %d tasks with expired leases and no claims left", fence.Name, len(reaped))
+			}
+			n := r.env.notifier
+			for _, rec := range reaped {
+				task := rec.taskRecord()
+				n.Notify(n.TaskEvent(EventTaskFailed, task))
+				n.Notify(n.TaskEvent(EventTaskDeadLettered, task))
+			}
+		})
+	})
+	e.Duty(func(ctx context.Context, fence Fence) {
+		everyTick(ctx, time.Hour, func() {
+			// A deposed leader must not sweep alongside the new one.
+			if err := q.checkFence(ctx, fence); err != nil {
+				log.Printf("[Leader %s] Artifact GC skipped: %v", fence.Name, err)
+				return
+			}
+			gcArtifacts(r.env.artifacts, cfg.Retention)
+		})
+	})
+	elected := make(chan error, 1)
+	go func() { elected <- e.Run(ctx) }()
+	err = w.Run(ctx)
+	if eerr := <-elected; err == nil {
+		err = eerr
+	}
+	return err
+}
+
+// configTaskKey identifies a task of the config, so replicas with the same
+// config enqueue it only once while a changed task is queued anew.
+func configTaskKey(i int, spec TaskSpec) string {
+	sum := sha256.Sum256([]byte(compactJSON(spec)))
+	return fmt.Sprintf("config:%d:%x", i, sum[:8])
+}
+
+// Handler serves the task API and /metrics, behind the configured
+// authentication.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	mux.HandleFunc("GET /workers", serveWorkers)
+	mux.HandleFunc("GET /queue", serveQueue)
+	mux.HandleFunc("GET /breakers", serveBreakers(submitted.env.breakers))
+	mux.Handle("/", newAPIHandler(submitted, submitted.env.artifacts))
+	return apiAuth.Wrap(mux)
+}
+
+// submitted runs the tasks submitted through Handler.
+var submitted = newAPIRunner(defaultEnv.tasks, defaultEnv, 0, 0)
+
+// Shutdown stops taking tasks through the API and waits for the ones it
+// took. If ctx ends first they are canceled, and ctx's error is returned
+// once they have stopped.
+func Shutdown(ctx context.Context) error {
+	return submitted.drain(ctx)
+}
+
+// Serve serves Handler on addr while running cfg.Tasks, and keeps serving
+// until ctx is canceled.
+func Serve(ctx context.Context, cfg Config, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: Handler()}
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Task API listening on %s", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	go RunBatch(ctx, cfg)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --------------------- RUNNER ---------------------
+
+// Store keeps the state of submitted tasks. *TaskRegistry is the default
+// and the one the HTTP API reads.
+type Store interface {
+	Add(id int, taskType string)
+	Start(id, attempt int)
+	Finish(id int, out interface{}, err error, final bool)
+	Log(id int, msg string)
+	Partial(id int, chunk string)
+	Get(id int) (TaskRecord, bool)
+	List() []TaskRecord
+}
+
+// tracker is implemented by stores that can cancel the tasks they track
+// and retry them from their spec, like TaskRegistry.
+type tracker interface {
+	track(id int, spec TaskSpec, cancel context.CancelCauseFunc) (untrack func())
+	SetNamespace(id int, ns string)
+	SetParent(id, parent int)
+	AddUsage(id int, u Usage)
+}
+
+// Executor runs one attempt of a task.
+type Executor interface {
+	Execute(ctx context.Context, id int, spec TaskSpec) (interface{}, error)
+}
+
+// ExecutorFunc adapts a function to Executor.
+type ExecutorFunc func(ctx context.Context, id int, spec TaskSpec) (interface{}, error)
+
+func (f ExecutorFunc) Execute(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+	return f(ctx, id, spec)
+}
+
+// Logger receives the progress messages of every task attempt.
+type Logger interface {
+	Log(taskID, attempt int, msg string)
+}
+
+// LoggerFunc adapts a function to Logger.
+type LoggerFunc func(taskID, attempt int, msg string)
+
+func (f LoggerFunc) Log(taskID, attempt int, msg string) { f(taskID, attempt, msg) }
+
+// Options configure a Runner. Zero values get the defaults.
+type Options struct {
+	MaxConcurrency int       // tasks running at once, default 3
+	MaxRetries     int       // retries after a failed attempt
+	Store          Store     // default a new TaskRegistry
+	Executor       Executor  // default BuiltinExecutor(Store)
+	Logger         Logger    // default JSON lines on the standard logger
+	Notifier       *Notifier // webhooks for task and run events, default Env's
+	Env            *Env      // clients, quotas, budgets and secrets tasks use, default an empty one of its own
+}
+
+// ErrRunnerClosed is returned by Submit after Shutdown.
+var ErrRunnerClosed = errors.New("runner is shut down")
+
+// ErrUnknownTask is returned by Wait for an ID the runner did not issue.
+var ErrUnknownTask = errors.New("unknown task")
+
+// Runner runs submitted tasks in the background, for programs that embed
+// the job engine instead of running the web4-runner command.
+type Runner struct {
+	store      Store
+	exec       Executor
+	logger     Logger
+	notifier   *Notifier
+	env        *Env
+	maxRetries int
+	sem        chan struct{}
+	addNext    func(taskType string) int // adds tasks under the store's next free ID, for a shared registry
+
+	base   context.Context // canceled when Shutdown gives up waiting
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	nextID int
+	done   map[int]chan struct{}
+	subs   map[chan Event]bool
+}
+
+func New(opts Options) *Runner {
+	concurrency := opts.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 3
+	}
+	r := &Runner{
+		store:      opts.Store,
+		exec:       opts.Executor,
+		logger:     opts.Logger,
+		notifier:   opts.Notifier,
+		env:        opts.Env,
+		maxRetries: opts.MaxRetries,
+		sem:        make(chan struct{}, concurrency),
+		done:       map[int]chan struct{}{},
+		subs:       map[chan Event]bool{},
+	}
+	if r.store == nil {
+		r.store = NewTaskRegistry()
+	}
+	if r.exec == nil {
+		r.exec = BuiltinExecutor(r.store)
+	}
+	if r.env == nil {
+		r.env, _ = NewEnv(Config{}, nil)
+	}
+	if r.notifier == nil {
+		r.notifier = r.env.notifier
+	}
+	if r.logger == nil {
+		r.logger = runLogger{r.notifier.RunID}
+	}
+	r.base, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Store returns the runner's task store.
+func (r *Runner) Store() Store { return r.store }
+
+// RunID identifies the runner in logs and events.
+func (r *Runner) RunID() string { return r.notifier.RunID }
+
+// Submit queues spec and returns the task's ID. The task runs until it
+// finishes, ctx is canceled, or Shutdown runs out of time.
+func (r *Runner) Submit(ctx context.Context, spec TaskSpec) (int, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, ErrRunnerClosed
+	}
+	var id int
+	if r.addNext != nil {
+		id = r.addNext(spec.Type)
+	} else {
+		r.nextID++
+		id = r.nextID
+	}
+	done := make(chan struct{})
+	r.done[id] = done
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	if r.addNext == nil {
+		r.store.Add(id, spec.Type)
+	}
+	if tr, ok := r.store.(tracker); ok {
+		tr.SetNamespace(id, namespaceOf(spec))
+	}
+	go func() {
+		defer r.wg.Done()
+		defer close(done)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(r.base, cancel)
+		defer stop()
+		defer r.env.quotas.Acquire(ctx, spec.Namespace)()
+
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			r.log(id, 0, "Task canceled")
+			r.store.Finish(id, nil, ctx.Err(), true)
+			r.fail(id)
+			return
+		}
+		defer func() { <-r.sem }()
+		r.runTask(ctx, Task{ID: id, Spec: spec, MaxRetries: r.maxRetries})
+	}()
+	return id, nil
+}
+
+// Wait blocks until task id has finished and returns its final record.
+func (r *Runner) Wait(id int) (TaskRecord, error) {
+	r.mu.Lock()
+	done, ok := r.done[id]
+	r.mu.Unlock()
+	if !ok {
+		return TaskRecord{}, fmt.Errorf("%w %d", ErrUnknownTask, id)
+	}
+	<-done
+	rec, _ := r.store.Get(id)
+	return rec, nil
+}
+
+// Subscribe returns a channel of task.succeeded, task.failed,
+// task.dead_lettered and, on Shutdown, run.finished events, and a function
+// that ends the subscription. Events are dropped for a subscriber whose
+// buffer is full. The channel is closed by unsubscribe or Shutdown.
+func (r *Runner) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	r.mu.Lock()
+	if r.closed {
+		close(ch)
+	} else {
+		r.subs[ch] = true
+	}
+	r.mu.Unlock()
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.subs[ch] {
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Shutdown stops accepting tasks and waits for the submitted ones. If ctx
+// ends first, the remaining tasks are canceled and ctx's error is returned
+// once they have stopped. Webhook deliveries are awaited within ctx too.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	err := r.drain(ctx)
+	ev := r.notifier.RunFinishedEvent(r.store)
+	r.notifier.Notify(ev)
+	r.publish(ev)
+	r.mu.Lock()
+	for ch := range r.subs {
+		delete(r.subs, ch)
+		close(ch)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.notifier.Wait(ctx)
+}
+
+// drain stops accepting tasks and waits for the submitted ones, canceling
+// them when ctx ends first.
+func (r *Runner) drain(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(idle)
+	}()
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.cancel()
+		<-idle
+	}
+	r.cancel()
+	return err
+}
+
+// runTask runs t with retries and returns the output and error of the last
+// attempt.
+func (r *Runner) runTask(ctx context.Context, t Task) (interface{}, error) {
+	if tr, ok := r.store.(tracker); ok {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		defer tr.track(t.ID, t.Spec, cancel)()
+	}
+	ns := namespaceOf(t.Spec)
+	if err := r.env.quotas.Start(ns); err != nil {
+		r.log(t.ID, 0, fmt.Sprintf("Task not started: %v", err))
+		r.store.Finish(t.ID, nil, err, true)
+		r.env.metrics.taskFailure.WithLabelValues(t.Spec.Type, ns).Inc()
+		r.fail(t.ID)
+		return nil, err
+	}
+	var out interface{}
+	var err error
+	for attempt := 0; attempt <= t.MaxRetries; attempt++ {
+		select {
+		case <-ctx.Done():
+			if released(ctx) {
+				r.log(t.ID, attempt, "Task released")
+				return out, context.Cause(ctx)
+			}
+			err := ctx.Err()
+			if canceled(ctx) {
+				err = ErrTaskCanceled
+			}
+			r.log(t.ID, attempt, "Task canceled")
+			r.store.Finish(t.ID, nil, err, true)
+			r.fail(t.ID)
+			return nil, err
+		default:
+		}
+
+		start := time.Now()
+		r.store.Start(t.ID, attempt)
+		r.log(t.ID, attempt, fmt.Sprintf("Starting task type=%s payload=%s", t.Spec.Type, t.Spec.Payload))
+		out, err = r.execute(ctx, t, attempt)
+		duration := time.Since(start).Seconds()
+		if err != nil && released(ctx) {
+			r.log(t.ID, attempt, fmt.Sprintf("Attempt %d released after %.2fs", attempt, duration))
+			return out, context.Cause(ctx)
+		}
+		if err != nil && canceled(ctx) {
+			err = ErrTaskCanceled
+		}
+		final := attempt == t.MaxRetries || errors.Is(err, errUnknownTaskType) || r.env.breakers.failFast(err) ||
+			errors.Is(err, ErrTaskCanceled) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrBudgetExceeded) ||
+			errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrTxSent) ||
+			errors.Is(err, ErrSchemaViolation)
+		r.store.Finish(t.ID, out, err, final)
+
+		if err != nil {
+			r.env.metrics.taskFailure.WithLabelValues(t.Spec.Type, ns).Inc()
+			r.log(t.ID, attempt, fmt.Sprintf("Attempt %d failed after %.2fs: %v", attempt, duration, err))
+			if !final {
+				backoff := time.Duration(500*int64(1<<attempt)) * time.Millisecond
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done(): // recorded at the top of the loop
+				}
+			} else {
+				r.fail(t.ID)
+				break
+			}
+			continue
+		}
+		r.env.metrics.taskSuccess.WithLabelValues(t.Spec.Type, ns).Inc()
+		if out != nil {
+			r.log(t.ID, attempt, fmt.Sprintf("Attempt %d succeeded in %.2fs: %+v", attempt, duration, out))
+		} else {
+			r.log(t.ID, attempt, fmt.Sprintf("Attempt %d succeeded in %.2fs", attempt, duration))
+		}
+		r.notify(EventTaskSucceeded, t.ID)
+		break
+	}
+	return out, err
+}
+
+// errTaskReleased is the cancel cause of a task that is handed back instead
+// of failed, like a queue task whose worker shuts down. Released tasks are
+// not recorded as failed and send no events.
+var errTaskReleased = errors.New("task released")
+
+func released(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTaskReleased)
+}
+
+// ErrTaskCanceled is the error of a task canceled through the API.
+var ErrTaskCanceled = errors.New("task canceled")
+
+// ErrTaskState is returned for an operation the task's status does not
+// allow, like canceling a finished task.
+var ErrTaskState = errors.New("operation not allowed")
+
+func canceled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrTaskCanceled)
+}
+
+// execute runs one attempt with the task's secret references resolved,
+// once the breakers of its dependencies and its cost budgets admit it,
+// charges the usage it reports and files its outputs in the artifact store.
+func (r *Runner) execute(ctx context.Context, t Task, attempt int) (interface{}, error) {
+	ctx = withEnv(ctx, r.env)
+	ns, pipeline := namespaceOf(t.Spec), r.pipelineOf(t.ID)
+	if err := r.env.costs.Admit(t.Spec.Type, ns, pipeline); err != nil {
+		return nil, err
+	}
+	spec, err := r.env.secrets.ResolveSpec(ctx, t.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.env.breakers.Admit(ctx, r.env.taskDependencies(spec)); err != nil {
+		return nil, err
+	}
+	ctx = withUsage(ctx, func(u Usage) {
+		u.Attempt = attempt
+		u = r.env.costs.Charge(t.ID, pipeline, ns, t.Spec.Type, u)
+		if tr, ok := r.store.(tracker); ok {
+			tr.AddUsage(t.ID, u)
+		}
+		r.log(t.ID, attempt, "Usage: "+u.String())
+	})
+	out, err := r.exec.Execute(ctx, t.ID, spec)
+	if err == nil {
+		if aerr := r.env.artifacts.storeOutput(t.ID, t.Spec, out); aerr != nil {
+			r.log(t.ID, 0, fmt.Sprintf("Storing artifacts failed: %v", aerr))
+		}
+	}
+	return out, err
+}
+
+// pipelineOf is the root of the task tree id belongs to, id itself for a
+// task that no other task started.
+func (r *Runner) pipelineOf(id int) int {
+	for range 1000 { // parents form a tree; the bound guards a corrupt store
+		rec, ok := r.store.Get(id)
+		if !ok || rec.Parent == 0 || rec.Parent == id {
+			break
+		}
+		id = rec.Parent
+	}
+	return id
+}
+
+func (r *Runner) log(taskID, attempt int, msg string) {
+	msg = redactions.Redact(msg)
+	r.logger.Log(taskID, attempt, msg)
+	r.store.Log(taskID, msg)
+}
+
+// notify sends the task's current record to the webhooks and subscribers.
+func (r *Runner) notify(eventType string, id int) {
+	rec, ok := r.store.Get(id)
+	if !ok {
+		return
+	}
+	ev := r.notifier.TaskEvent(eventType, rec)
+	r.notifier.Notify(ev)
+	r.publish(ev)
+}
+
+// fail sends the events of a task that failed for good.
+func (r *Runner) fail(id int) {
+	r.notify(EventTaskFailed, id)
+	r.notify(EventTaskDeadLettered, id)
+}
+
+func (r *Runner) publish(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range r.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
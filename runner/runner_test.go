@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discard = LoggerFunc(func(int, int, string) {})
+
+func TestRunnerRetries(t *testing.T) {
+	var calls atomic.Int32
+	r := New(Options{
+		MaxRetries: 1,
+		Logger:     discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("flaky")
+			}
+			return "ok", nil
+		}),
+	})
+	id, err := r.Submit(context.Background(), TaskSpec{Type: "flaky"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Wait(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != "success" || rec.Attempts != 2 || rec.Output != "ok" {
+		t.Fatalf("record = %+v", rec)
+	}
+	if len(rec.Log) == 0 {
+		t.Error("store got no log lines")
+	}
+	if _, err := r.Wait(99); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("Wait(99) = %v", err)
+	}
+}
+
+func TestRunnerConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	r := New(Options{
+		MaxConcurrency: 2,
+		Logger:         discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return nil, nil
+		}),
+	})
+	for range 6 {
+		if _, err := r.Submit(context.Background(), TaskSpec{Type: "sleep"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("peak concurrency = %d, want 2", p)
+	}
+	for _, rec := range r.Store().List() {
+		if rec.Status != "success" {
+			t.Errorf("task %d: %s", rec.ID, rec.Status)
+		}
+	}
+	if _, err := r.Submit(context.Background(), TaskSpec{Type: "sleep"}); !errors.Is(err, ErrRunnerClosed) {
+		t.Errorf("Submit after Shutdown = %v", err)
+	}
+}
+
+func TestRunnerShutdownDeadline(t *testing.T) {
+	r := New(Options{
+		MaxConcurrency: 1,
+		Logger:         discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	})
+	events, _ := r.Subscribe(10)
+	first, _ := r.Submit(context.Background(), TaskSpec{Type: "block"})
+	queued, _ := r.Submit(context.Background(), TaskSpec{Type: "block"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	for _, id := range []int{first, queued} {
+		rec, _ := r.Wait(id)
+		if rec.Status != "failed" {
+			t.Errorf("task %d: %+v", id, rec)
+		}
+	}
+
+	var types []string
+	for ev := range events {
+		types = append(types, ev.Type)
+	}
+	// Each failed task is also dead-lettered.
+	if len(types) != 5 || types[4] != EventRunFinished {
+		t.Errorf("events = %v", types)
+	}
+}
+
+func TestRunnerSubmitContext(t *testing.T) {
+	r := New(Options{
+		Logger: discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	id, _ := r.Submit(ctx, TaskSpec{Type: "block"})
+	cancel()
+	rec, _ := r.Wait(id)
+	if rec.Status != "failed" || rec.Error != context.Canceled.Error() {
+		t.Fatalf("record = %+v", rec)
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunnerShutdownDuringBackoff(t *testing.T) {
+	r := New(Options{
+		MaxRetries: 5,
+		Logger:     discard,
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			return nil, errors.New("flaky")
+		}),
+	})
+	id, _ := r.Submit(context.Background(), TaskSpec{Type: "flaky"})
+	time.Sleep(20 * time.Millisecond) // first attempt failed, now in its 500ms backoff
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("Shutdown took %v, waiting out the backoff", d)
+	}
+	if rec, _ := r.Wait(id); rec.Status != "failed" || rec.Attempts != 1 {
+		t.Errorf("record = %+v", rec)
+	}
+}
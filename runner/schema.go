@@ -0,0 +1,282 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema used to constrain AI output: type,
+// enum, const, properties, required, additionalProperties, items, min/max
+// bounds, pattern, and the allOf/anyOf/oneOf combinators.
+type Schema struct {
+	Type                 schemaTypes        `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schemaOrBool      `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("schema type must be a string or list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// schemaOrBool is additionalProperties: false forbids extra keys, a schema
+// constrains them.
+type schemaOrBool struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (s *schemaOrBool) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &s.Allowed); err == nil {
+		return nil
+	}
+	s.Allowed = true
+	return json.Unmarshal(b, &s.Schema)
+}
+
+// ParseSchema reads a schema document.
+func ParseSchema(raw []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	return &s, nil
+}
+
+// compile checks patterns up front so validation can't fail on the schema.
+func (s *Schema) compile() error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	children := append(append(append([]*Schema{s.Items}, s.AllOf...), s.AnyOf...), s.OneOf...)
+	for _, p := range s.Properties {
+		children = append(children, p)
+	}
+	if s.AdditionalProperties != nil {
+		children = append(children, s.AdditionalProperties.Schema)
+	}
+	for _, c := range children {
+		if err := c.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json into an
+// interface{}) and returns one message per violation, each prefixed with the
+// JSON pointer of the offending value.
+func (s *Schema) Validate(v interface{}) []string {
+	var errs []string
+	s.validate(v, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *[]string) {
+	if s == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		at := path
+		if at == "" {
+			at = "/"
+		}
+		*errs = append(*errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonType(v))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value %s is not one of %s", compactJSON(v), compactJSON(s.Enum))
+		}
+	}
+	if s.Const != nil && !jsonEqual(s.Const, v) {
+		fail("value must be %s", compactJSON(s.Const))
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("string shorter than %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("string longer than %d", *s.MaxLength)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(val) {
+			fail("string does not match pattern %q", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			fail("%v is less than minimum %v", val, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			fail("%v is greater than maximum %v", val, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && val <= *s.ExclusiveMinimum {
+			fail("%v must be greater than %v", val, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && val >= *s.ExclusiveMaximum {
+			fail("%v must be less than %v", val, *s.ExclusiveMaximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("array has fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("array has more than %d items", *s.MaxItems)
+		}
+		for i, item := range val {
+			s.Items.validate(item, fmt.Sprintf("%s/%d", path, i), errs)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "/" + pointerEscape(k)
+			if p, ok := s.Properties[k]; ok {
+				p.validate(val[k], child, errs)
+			} else if ap := s.AdditionalProperties; ap != nil {
+				if !ap.Allowed {
+					fail("unexpected property %q", k)
+				} else {
+					ap.Schema.validate(val[k], child, errs)
+				}
+			}
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(v, path, errs)
+	}
+	if len(s.AnyOf) > 0 && s.matching(s.AnyOf, v) == 0 {
+		fail("value matches none of anyOf")
+	}
+	if len(s.OneOf) > 0 {
+		if n := s.matching(s.OneOf, v); n != 1 {
+			fail("value matches %d of oneOf, want exactly 1", n)
+		}
+	}
+}
+
+func (s *Schema) matching(subs []*Schema, v interface{}) int {
+	n := 0
+	for _, sub := range subs {
+		if len(sub.Validate(v)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (t schemaTypes) match(v interface{}) bool {
+	actual := jsonType(v)
+	for _, want := range t {
+		if want == actual || (want == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	// Normalize through JSON so schema literals and decoded values compare
+	// the same way regardless of Go types.
+	var na, nb interface{}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	json.Unmarshal(ja, &na)
+	json.Unmarshal(jb, &nb)
+	return reflect.DeepEqual(na, nb)
+}
+
+func compactJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func pointerEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["title", "tags"],
+	"additionalProperties": false,
+	"properties": {
+		"title": {"type": "string", "minLength": 3},
+		"score": {"type": "integer", "minimum": 0, "maximum": 10},
+		"tags": {"type": "array", "items": {"enum": ["web4", "ai", "chain"]}, "minItems": 1}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		doc  string
+		want []string
+	}{
+		{`{"title": "Web4", "tags": ["web4"], "score": 7}`, nil},
+		{`{"title": "W", "tags": []}`, []string{"/tags: array has fewer than 1 items", "/title: string shorter than 3"}},
+		{`{"title": "Web4", "tags": ["nft"], "score": 7.5}`, []string{`/score: expected integer, got number`, `/tags/0: value "nft" is not one of ["web4","ai","chain"]`}},
+		{`{"tags": ["ai"], "extra": 1}`, []string{`/: missing required property "title"`, `/: unexpected property "extra"`}},
+		{`"just text"`, []string{"/: expected object, got string"}},
+	} {
+		var v interface{}
+		json.Unmarshal([]byte(c.doc), &v)
+		got := s.Validate(v)
+		if strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("Validate(%s) = %q, want %q", c.doc, got, c.want)
+		}
+	}
+
+	if _, err := ParseSchema([]byte(`{"pattern": "("}`)); err == nil {
+		t.Fatal("expected error for a bad pattern")
+	}
+}
+
+// scriptedLLM answers each request with the next canned reply.
+func scriptedLLM(t *testing.T, replies ...string) (*httptest.Server, *[]string) {
+	var prompts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []chatMessage `json:"messages"`
+			Format   string        `json:"format"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Format != "json" {
+			t.Errorf("format = %q, want json", req.Format)
+		}
+		prompts = append(prompts, req.Messages[len(req.Messages)-1].Content)
+		reply := replies[len(prompts)-1]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": "llama3", "message": chatMessage{Role: "assistant", Content: reply},
+			"done": true, "prompt_eval_count": 10, "eval_count": 5,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	p, _ := newLLMProvider(LLMConfig{Type: "local", BaseURL: srv.URL}, srv.Client())
+	old := defaultEnv.llmProviders
+	defaultEnv.llmProviders = map[string]LLMProvider{defaultLLM: p}
+	t.Cleanup(func() { defaultEnv.llmProviders = old })
+	return srv, &prompts
+}
+
+func TestTaskAIRepairsInvalidJSON(t *testing.T) {
+	_, prompts := scriptedLLM(t,
+		"Sure! Here is the summary.",
+		`{"title": "Web4", "tags": ["nft"]}`,
+		"```json\n{\"title\": \"Web4\", \"tags\": [\"web4\"]}\n```",
+	)
+	spec := TaskSpec{Type: "ai", Payload: "Summarize Web4", AI: &AIOptions{ResponseSchema: json.RawMessage(testSchema)}}
+	out, err := taskAI(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Attempts != 3 || out.Usage.TotalTokens != 45 {
+		t.Fatalf("attempts = %d usage = %+v", out.Attempts, out.Usage)
+	}
+	if m, ok := out.JSON.(map[string]interface{}); !ok || m["title"] != "Web4" {
+		t.Fatalf("JSON = %#v", out.JSON)
+	}
+	if p := (*prompts)[2]; !strings.Contains(p, `/tags/0: value "nft" is not one of`) || !strings.HasPrefix(p, "Summarize Web4") {
+		t.Fatalf("repair prompt = %q", p)
+	}
+}
+
+func TestTaskAISchemaViolation(t *testing.T) {
+	scriptedLLM(t, `{"title": "W"}`, `{"title": "W"}`)
+	one := 1
+	spec := TaskSpec{Type: "ai", Payload: "Summarize", AI: &AIOptions{ResponseSchema: json.RawMessage(testSchema), RepairAttempts: &one}}
+	out, err := taskAI(context.Background(), spec, nil)
+	var sv *SchemaViolationError
+	if !errors.As(err, &sv) || sv.Attempts != 2 || len(sv.Errors) != 2 || !errors.Is(err, ErrSchemaViolation) {
+		t.Fatalf("err = %v, want schema violation after 2 attempts", err)
+	}
+	if out == nil || out.Text != `{"title": "W"}` || out.JSON != nil {
+		t.Fatalf("output = %+v", out)
+	}
+}
@@ -0,0 +1,574 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- SECRETS ----------------
+
+// SecretsConfig lists where ${secret:name} references in task specs are
+// looked up, first provider first. Without it secrets come from env vars
+// with the default prefix.
+type SecretsConfig struct {
+	Providers []SecretProviderConfig `json:"providers"`
+}
+
+// SecretProviderConfig is one place secrets are kept.
+type SecretProviderConfig struct {
+	Type       string   `json:"type"`                 // env, dir or file
+	Prefix     string   `json:"prefix,omitempty"`     // env: prepended to the variable name, default WEB4_SECRET_
+	Path       string   `json:"path,omitempty"`       // dir: one file per secret, like mounted Kubernetes secrets; file: the encrypted file
+	KeyEnv     string   `json:"key_env,omitempty"`    // file: env var holding the base64 key, default WEB4_SECRETS_KEY
+	Namespaces []string `json:"namespaces,omitempty"` // namespaces whose tasks may read it, default all
+}
+
+const (
+	defaultSecretPrefix = "WEB4_SECRET_"
+	defaultSecretKeyEnv = "WEB4_SECRETS_KEY"
+)
+
+// SecretProvider looks up secrets by name. Names are slash-separated, like
+// openai/prod.
+type SecretProvider interface {
+	// Secret returns the named secret, or an error wrapping
+	// ErrSecretNotFound when the provider has none by that name.
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// ErrSecretNotFound is returned for a reference no provider can resolve.
+var ErrSecretNotFound = errors.New("secret not found")
+
+var (
+	secretRef  = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+	secretName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*(/[A-Za-z0-9_-][A-Za-z0-9_.-]*)*$`)
+)
+
+// minRedactLen is the shortest secret value that is redacted; shorter ones
+// would blank out unrelated text.
+const minRedactLen = 4
+
+const redacted = "[REDACTED]"
+
+// anyNamespace looks secrets up for the config rather than a task, in
+// every provider.
+const anyNamespace = "*"
+
+// Secrets resolves secret references and remembers the values it handed
+// out, so they can be redacted wherever task state is logged or served.
+type Secrets struct {
+	providers []scopedSecrets
+
+	mu       sync.Mutex
+	seen     map[string]bool
+	replacer *strings.Replacer
+}
+
+type scopedSecrets struct {
+	SecretProvider
+	namespaces []string
+}
+
+// redactions holds every secret value any environment of the process has
+// resolved. Logs, task records and errors are shared by the runners of a
+// process, so they are redacted with all of them.
+var redactions = NewSecrets()
+
+// NewSecrets returns a resolver that asks providers in order.
+func NewSecrets(providers ...SecretProvider) *Secrets {
+	s := &Secrets{seen: map[string]bool{}}
+	for _, p := range providers {
+		s.providers = append(s.providers, scopedSecrets{SecretProvider: p})
+	}
+	return s
+}
+
+// loadSecrets builds the providers of cfg.
+func loadSecrets(cfg *SecretsConfig) (*Secrets, error) {
+	if cfg == nil {
+		return NewSecrets(EnvSecrets(defaultSecretPrefix)), nil
+	}
+	s := NewSecrets()
+	for i, c := range cfg.Providers {
+		var p SecretProvider
+		switch c.Type {
+		case "env":
+			prefix := c.Prefix
+			if prefix == "" {
+				prefix = defaultSecretPrefix
+			}
+			p = EnvSecrets(prefix)
+		case "dir":
+			if c.Path == "" {
+				return nil, fmt.Errorf("secrets provider %d: dir needs a path", i)
+			}
+			p = DirSecrets(c.Path)
+		case "file":
+			f, err := openSecretFile(c)
+			if err != nil {
+				return nil, fmt.Errorf("secrets provider %d: %w", i, err)
+			}
+			p = f
+		default:
+			return nil, fmt.Errorf("secrets provider %d: unknown type %q", i, c.Type)
+		}
+		s.providers = append(s.providers, scopedSecrets{SecretProvider: p, namespaces: c.Namespaces})
+	}
+	return s, nil
+}
+
+// lookup resolves one name for a task of namespace ns. Providers the
+// namespace may not read are skipped as if they lacked the name.
+func (s *Secrets) lookup(ctx context.Context, name, ns string) (string, error) {
+	if !secretName.MatchString(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	for _, p := range s.providers {
+		if ns != anyNamespace && !allowsNamespace(p.namespaces, ns) {
+			continue
+		}
+		v, err := p.Secret(ctx, name)
+		if errors.Is(err, ErrSecretNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", name, err)
+		}
+		s.remember(v)
+		return v, nil
+	}
+	return "", fmt.Errorf("secret %s: %w", name, ErrSecretNotFound)
+}
+
+// Resolve replaces the secret references in text for a task of namespace
+// ns.
+func (s *Secrets) Resolve(ctx context.Context, text, ns string) (string, error) {
+	if !strings.Contains(text, "${secret:") {
+		return text, nil
+	}
+	var errs []error
+	out := secretRef.ReplaceAllStringFunc(text, func(ref string) string {
+		v, err := s.lookup(ctx, secretRef.FindStringSubmatch(ref)[1], ns)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return v
+	})
+	return out, errors.Join(errs...)
+}
+
+// ResolveSpec returns spec with every secret reference in it resolved. The
+// spec kept in task history still holds the references.
+func (s *Secrets) ResolveSpec(ctx context.Context, spec TaskSpec) (TaskSpec, error) {
+	b, err := json.Marshal(spec)
+	if err != nil || !strings.Contains(string(b), "${secret:") {
+		return spec, err
+	}
+	doc, err := decodeJSON(b)
+	if err != nil {
+		return spec, err
+	}
+	var errs []error
+	doc = mapStrings(doc, func(v string) string {
+		out, err := s.Resolve(ctx, v, spec.Namespace)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return out
+	})
+	if err := errors.Join(errs...); err != nil {
+		return spec, err
+	}
+	var out TaskSpec
+	b, _ = json.Marshal(doc)
+	if err := json.Unmarshal(b, &out); err != nil {
+		return spec, err
+	}
+	return out, nil
+}
+
+// decodeJSON decodes a document keeping numbers exact, so large values
+// like gas limits survive a round trip.
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc interface{}
+	err := dec.Decode(&doc)
+	return doc, err
+}
+
+// mapStrings applies fn to every string in a decoded JSON document.
+func mapStrings(doc interface{}, fn func(string) string) interface{} {
+	switch d := doc.(type) {
+	case string:
+		return fn(d)
+	case []interface{}:
+		for i := range d {
+			d[i] = mapStrings(d[i], fn)
+		}
+	case map[string]interface{}:
+		for k := range d {
+			d[k] = mapStrings(d[k], fn)
+		}
+	}
+	return doc
+}
+
+func (s *Secrets) remember(v string) {
+	if len(v) < minRedactLen {
+		return
+	}
+	if s != redactions {
+		redactions.remember(v)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[v] {
+		return
+	}
+	s.seen[v] = true
+	values := make([]string, 0, len(s.seen))
+	for v := range s.seen {
+		values = append(values, v)
+	}
+	// Longest first, so a secret containing another is replaced whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v, redacted)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact blanks out every secret value resolved so far.
+func (s *Secrets) Redact(text string) string {
+	s.mu.Lock()
+	r := s.replacer
+	s.mu.Unlock()
+	if r == nil {
+		return text
+	}
+	return r.Replace(text)
+}
+
+// RedactValue returns v, or when it holds a secret a copy of its JSON form
+// with the secrets blanked out.
+func (s *Secrets) RedactValue(v interface{}) interface{} {
+	s.mu.Lock()
+	none := s.replacer == nil
+	s.mu.Unlock()
+	if v == nil || none {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	doc, err := decodeJSON(b)
+	if err != nil {
+		return v
+	}
+	changed := false
+	doc = mapStrings(doc, func(str string) string {
+		out := s.Redact(str)
+		changed = changed || out != str
+		return out
+	})
+	if !changed {
+		return v
+	}
+	return doc
+}
+
+// RedactError returns err, or when its message holds a secret an error
+// with the secret blanked out that still wraps err.
+func (s *Secrets) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := s.Redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+	return err
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Format prints the spec like a struct without methods would be, with the
+// secret values resolved so far redacted.
+func (s TaskSpec) Format(f fmt.State, verb rune) {
+	type plain TaskSpec
+	fmt.Fprint(f, redactions.Redact(fmt.Sprintf(fmt.FormatString(f, verb), plain(s))))
+}
+
+// checkSecretRefs reports malformed secret references in spec.
+func checkSecretRefs(spec TaskSpec) error {
+	b, _ := json.Marshal(spec)
+	text := string(b)
+	var errs []error
+	for _, m := range secretRef.FindAllStringSubmatch(text, -1) {
+		if !secretName.MatchString(m[1]) {
+			errs = append(errs, fmt.Errorf("invalid secret name %q", m[1]))
+		}
+	}
+	if strings.Count(text, "${secret:") != len(secretRef.FindAllString(text, -1)) {
+		errs = append(errs, errors.New("unterminated ${secret: reference"))
+	}
+	return errors.Join(errs...)
+}
+
+// ---------------- SECRET PROVIDERS ----------------
+
+// EnvSecrets reads secrets from env vars: openai/prod is the variable
+// prefix+OPENAI_PROD.
+type EnvSecrets string
+
+func (e EnvSecrets) Secret(_ context.Context, name string) (string, error) {
+	key := string(e) + strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(name))
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return v, nil
+}
+
+// DirSecrets reads secrets from files under a directory: openai/prod is
+// the file openai/prod, as Kubernetes mounts key prod of secret openai at
+// the secret's mount path. Files are read on every lookup, so rotated
+// mounts are picked up. A trailing newline is dropped.
+type DirSecrets string
+
+func (d DirSecrets) Secret(_ context.Context, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// FileSecrets keeps secrets in a local file encrypted with AES-256-GCM,
+// managed with web4-runner secret. Like TokenStore it rereads the file
+// when it changes.
+type FileSecrets struct {
+	path string
+	aead cipher.AEAD
+
+	mu     sync.Mutex
+	values map[string]string
+	mod    time.Time
+	size   int64
+}
+
+// secretFileAD binds the ciphertext to the file format.
+var secretFileAD = []byte("web4-secrets-v1")
+
+type secretFile struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewSecretKey returns a random key for FileSecrets, base64-encoded.
+func NewSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// OpenSecretFile opens the encrypted secrets file at path with a key from
+// NewSecretKey. A missing file holds no secrets.
+func OpenSecretFile(path, key string) (*FileSecrets, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("secrets key: want 32 bytes, base64-encoded")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileSecrets{path: path, aead: aead}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// openSecretFile opens the file of a provider config, with the key from
+// its env var.
+func openSecretFile(c SecretProviderConfig) (*FileSecrets, error) {
+	if c.Path == "" {
+		return nil, errors.New("file needs a path")
+	}
+	keyEnv := c.KeyEnv
+	if keyEnv == "" {
+		keyEnv = defaultSecretKeyEnv
+	}
+	key := os.Getenv(keyEnv)
+	if key == "" {
+		return nil, fmt.Errorf("%s is empty", keyEnv)
+	}
+	return OpenSecretFile(c.Path, key)
+}
+
+// SecretFileFor opens the encrypted file of the first file provider in
+// cfg, for the web4-runner secret command.
+func SecretFileFor(cfg *SecretsConfig) (*FileSecrets, error) {
+	if cfg != nil {
+		for _, c := range cfg.Providers {
+			if c.Type == "file" {
+				return openSecretFile(c)
+			}
+		}
+	}
+	return nil, errors.New("secrets: no file provider configured")
+}
+
+func (f *FileSecrets) reload() error {
+	fi, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.values, f.mod, f.size = map[string]string{}, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if f.values != nil && fi.ModTime().Equal(f.mod) && fi.Size() == f.size {
+		return nil
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var sf secretFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	plain, err := f.aead.Open(nil, sf.Nonce, sf.Ciphertext, secretFileAD)
+	if err != nil {
+		return fmt.Errorf("%s: wrong key or corrupt file", f.path)
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.values, f.mod, f.size = values, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// save encrypts the secrets under a fresh nonce and replaces the file
+// atomically; only its owner may read it.
+func (f *FileSecrets) save() error {
+	plain, err := json.Marshal(f.values)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(secretFile{Nonce: nonce, Ciphertext: f.aead.Seal(nil, nonce, plain, secretFileAD)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(f.path); err == nil {
+		f.mod, f.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+func (f *FileSecrets) Secret(_ context.Context, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return "", err
+	}
+	v, ok := f.values[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return v, nil
+}
+
+// Names returns the names of the stored secrets, sorted.
+func (f *FileSecrets) Names() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(f.values))
+	for name := range f.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Set stores a secret, replacing any of the same name.
+func (f *FileSecrets) Set(name, value string) error {
+	if !secretName.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return err
+	}
+	f.values[name] = value
+	return f.save()
+}
+
+// Delete removes a secret.
+func (f *FileSecrets) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return err
+	}
+	if _, ok := f.values[name]; !ok {
+		return fmt.Errorf("secret %s: %w", name, ErrSecretNotFound)
+	}
+	delete(f.values, name)
+	return f.save()
+}
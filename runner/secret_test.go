@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func useSecrets(t *testing.T, s *Secrets) *Secrets {
+	t.Helper()
+	old := defaultEnv.secrets
+	t.Cleanup(func() { defaultEnv.secrets = old })
+	defaultEnv.secrets = s
+	return s
+}
+
+func TestSecretProviders(t *testing.T) {
+	t.Setenv("WEB4_SECRET_OPENAI_PROD", "sk-env-value")
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "db"), 0o700)
+	os.WriteFile(filepath.Join(dir, "db", "password"), []byte("hunter22\n"), 0o600)
+	os.WriteFile(filepath.Join(dir, "team-b-only"), []byte("b-secret"), 0o600)
+
+	s, err := loadSecrets(&SecretsConfig{Providers: []SecretProviderConfig{
+		{Type: "env"},
+		{Type: "dir", Path: dir, Namespaces: []string{"team-a"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	got, err := s.Resolve(ctx, "Bearer ${secret:openai/prod}", "")
+	if err != nil || got != "Bearer sk-env-value" {
+		t.Errorf("env: %q, %v", got, err)
+	}
+	if got, err := s.Resolve(ctx, "${secret:db/password}@host", "team-a"); err != nil || got != "hunter22@host" {
+		t.Errorf("dir: %q, %v", got, err)
+	}
+	// team-b may not read the directory.
+	if _, err := s.Resolve(ctx, "${secret:db/password}", "team-b"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("other namespace: %v", err)
+	}
+	if _, err := s.Resolve(ctx, "${secret:../etc/passwd}", "team-a"); err == nil || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("path traversal: %v", err)
+	}
+	if got, err := s.Resolve(ctx, "no references", ""); err != nil || got != "no references" {
+		t.Errorf("plain text: %q, %v", got, err)
+	}
+
+	for _, cfg := range []SecretProviderConfig{{Type: "vault"}, {Type: "dir"}, {Type: "file", Path: filepath.Join(dir, "s.enc"), KeyEnv: "UNSET_KEY"}} {
+		if _, err := loadSecrets(&SecretsConfig{Providers: []SecretProviderConfig{cfg}}); err == nil {
+			t.Errorf("loaded %+v", cfg)
+		}
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	key, err := NewSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	f, err := OpenSecretFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := errors.Join(f.Set("openai/prod", "sk-file-value"), f.Set("rpc", "https://rpc.example/key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("bad name", "x"); err == nil {
+		t.Error("stored a secret with an invalid name")
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "sk-file-value") {
+		t.Error("the file holds the secret in plain text")
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Errorf("file mode %v", fi.Mode())
+	}
+
+	// A second handle, like a running server, sees changes.
+	t.Setenv("TEST_SECRETS_KEY", key)
+	s, err := loadSecrets(&SecretsConfig{Providers: []SecretProviderConfig{{Type: "file", Path: path, KeyEnv: "TEST_SECRETS_KEY"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Resolve(t.Context(), "${secret:openai/prod}", "any"); err != nil || got != "sk-file-value" {
+		t.Errorf("resolved %q, %v", got, err)
+	}
+	if err := f.Delete("openai/prod"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve(t.Context(), "${secret:openai/prod}", "any"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("after delete: %v", err)
+	}
+	if names, err := f.Names(); err != nil || len(names) != 1 || names[0] != "rpc" {
+		t.Errorf("Names = %v, %v", names, err)
+	}
+	if err := f.Delete("openai/prod"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("deleted twice: %v", err)
+	}
+
+	other, _ := NewSecretKey()
+	if _, err := OpenSecretFile(path, other); err == nil {
+		t.Error("opened the file with the wrong key")
+	}
+}
+
+func TestResolveSpec(t *testing.T) {
+	t.Setenv("WEB4_SECRET_API_TOKEN", "tok-123456")
+	t.Setenv("WEB4_SECRET_RPC", "https://rpc.example/v3/abcdef")
+	s := NewSecrets(EnvSecrets("WEB4_SECRET_"))
+	spec := TaskSpec{
+		Type:    "http",
+		Payload: "https://api.example/items",
+		Request: &HTTPTaskOptions{
+			Headers: map[string]string{"X-Api-Key": "${secret:api/token}"},
+			Auth:    &HTTPAuth{Type: "bearer", Token: "${secret:api/token}"},
+		},
+		Blockchain: &BlockchainOptions{RPCURL: "${secret:rpc}", GasLimit: 1<<63 + 1},
+	}
+	got, err := s.ResolveSpec(t.Context(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Request.Headers["X-Api-Key"] != "tok-123456" || got.Request.Auth.Token != "tok-123456" || got.Blockchain.RPCURL != "https://rpc.example/v3/abcdef" {
+		t.Errorf("resolved %+v %+v", got.Request, got.Blockchain)
+	}
+	if got.Blockchain.GasLimit != spec.Blockchain.GasLimit {
+		t.Errorf("gas limit changed to %d", got.Blockchain.GasLimit)
+	}
+	if spec.Request.Headers["X-Api-Key"] != "${secret:api/token}" {
+		t.Error("resolving changed the original spec")
+	}
+
+	spec.Payload = "${secret:missing}"
+	if _, err := s.ResolveSpec(t.Context(), spec); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("missing secret: %v", err)
+	}
+	for ref, ok := range map[string]bool{"${secret:a/b}": true, "${secret:a b}": false, "${secret:x": false} {
+		if err := checkSecretRefs(TaskSpec{Payload: ref}); (err == nil) != ok {
+			t.Errorf("checkSecretRefs(%q) = %v", ref, err)
+		}
+	}
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	t.Setenv("WEB4_SECRET_KEY", "sk-live-abcdef")
+	s := useSecrets(t, NewSecrets(EnvSecrets("WEB4_SECRET_")))
+	reg := NewTaskRegistry()
+	var mu sync.Mutex
+	var logged []string
+	r := New(Options{Store: reg, Env: defaultEnv, MaxRetries: 1,
+		Logger: LoggerFunc(func(_, _ int, msg string) { mu.Lock(); logged = append(logged, msg); mu.Unlock() }),
+		Executor: ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+			if spec.Payload == "${secret:key}" {
+				return nil, errors.New("unresolved")
+			}
+			reg.Log(id, "calling with "+spec.Payload)
+			if spec.Type == "fail" {
+				return nil, fmt.Errorf("401 for key %s", spec.Payload)
+			}
+			return map[string]string{"echo": spec.Payload}, nil
+		})})
+
+	id, _ := r.Submit(t.Context(), TaskSpec{Type: "echo", Payload: "${secret:key}"})
+	rec, _ := r.Wait(id)
+	if rec.Status != "success" || fmt.Sprint(rec.Output) != "map[echo:[REDACTED]]" || rec.Log[1] != "calling with [REDACTED]" {
+		t.Errorf("record = %+v", rec)
+	}
+	if rec.Spec != nil && rec.Spec.Payload != "${secret:key}" {
+		t.Errorf("history holds %q", rec.Spec.Payload)
+	}
+
+	id, _ = r.Submit(t.Context(), TaskSpec{Type: "fail", Payload: "${secret:key}"})
+	if rec, _ := r.Wait(id); rec.Status != "failed" || rec.Error != "401 for key [REDACTED]" {
+		t.Errorf("failed record = %+v", rec)
+	}
+	if err := s.RedactError(errors.Join(ErrSecretNotFound, errors.New("sk-live-abcdef"))); !errors.Is(err, ErrSecretNotFound) || strings.Contains(err.Error(), "sk-live") {
+		t.Errorf("RedactError = %v", err)
+	}
+	if dump := fmt.Sprintf("%+v", TaskSpec{Type: "http", Payload: "sk-live-abcdef"}); strings.Contains(dump, "sk-live") || !strings.Contains(dump, "Payload:[REDACTED]") {
+		t.Errorf("dump = %s", dump)
+	}
+	// A missing secret fails the task without retries.
+	id, _ = r.Submit(t.Context(), TaskSpec{Type: "echo", Payload: "${secret:nope}"})
+	if rec, _ := r.Wait(id); rec.Status != "failed" || rec.Attempts != 1 || !strings.Contains(rec.Error, "secret not found") {
+		t.Errorf("missing secret: %+v", rec)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, msg := range logged {
+		if strings.Contains(msg, "sk-live") {
+			t.Errorf("logged %q", msg)
+		}
+	}
+}
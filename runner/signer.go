@@ -0,0 +1,286 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs transactions for one account. Implementations never expose
+// the key material, including through fmt verbs.
+type Signer interface {
+	Address() common.Address
+	SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// SignerConfig describes a signer in CONFIG_JSON. It only ever holds
+// references to key material, never the key itself.
+type SignerConfig struct {
+	Type         string `json:"type"`                    // "keystore" or "remote"
+	Path         string `json:"path,omitempty"`          // keystore: encrypted key file
+	PasswordFile string `json:"password_file,omitempty"` // keystore: file holding the password
+	PasswordEnv  string `json:"password_env,omitempty"`  // keystore: env var holding the password
+	URL          string `json:"url,omitempty"`           // remote: eth_signTransaction endpoint
+	Address      string `json:"address,omitempty"`       // remote: account to sign for
+
+	Namespaces []string `json:"namespaces,omitempty"` // namespaces whose tasks may sign with it, default all
+}
+
+func (c SignerConfig) namespaces() []string { return c.Namespaces }
+
+// String hides credentials a remote URL may carry.
+func (c SignerConfig) String() string {
+	switch c.Type {
+	case "keystore":
+		return fmt.Sprintf("{Type:keystore Path:%s}", c.Path)
+	case "remote":
+		return fmt.Sprintf("{Type:remote URL:%s Address:%s}", redactURL(c.URL), c.Address)
+	default:
+		return fmt.Sprintf("{Type:%s}", c.Type)
+	}
+}
+
+func (c SignerConfig) GoString() string { return c.String() }
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return u.Redacted()
+}
+
+// defaultSigner is the alias used by blockchain tasks that don't pick one.
+const defaultSigner = "default"
+
+// loadSigners builds every configured signer. Keystores are decrypted once
+// here so a wrong password fails the run at startup. Remote signers are
+// called with client.
+func loadSigners(cfgs map[string]SignerConfig, client *http.Client) (map[string]Signer, error) {
+	out := make(map[string]Signer, len(cfgs))
+	for alias, c := range cfgs {
+		s, err := newSigner(c, client)
+		if err != nil {
+			return nil, fmt.Errorf("signer %q: %w", alias, err)
+		}
+		out[alias] = s
+	}
+	return out, nil
+}
+
+func newSigner(c SignerConfig, client *http.Client) (Signer, error) {
+	switch c.Type {
+	case "keystore":
+		password, err := readPassword(c)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeystoreSigner(c.Path, password)
+	case "remote":
+		if !common.IsHexAddress(c.Address) {
+			return nil, fmt.Errorf("remote signer needs a valid address")
+		}
+		return NewRemoteSigner(c.URL, common.HexToAddress(c.Address), client), nil
+	default:
+		return nil, fmt.Errorf("unknown signer type %q", c.Type)
+	}
+}
+
+func readPassword(c SignerConfig) (string, error) {
+	switch {
+	case c.PasswordFile != "":
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case c.PasswordEnv != "":
+		v, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password env %s not set", c.PasswordEnv)
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("keystore signer needs password_file or password_env")
+	}
+}
+
+// signer resolves the signer alias of a task in namespace ns.
+func (e *Env) signer(alias, ns string) (Signer, error) {
+	if alias == "" {
+		alias = defaultSigner
+	}
+	s, ok := e.signers[alias]
+	if ok && !allowsNamespace(e.signerNamespaces[alias], ns) {
+		return nil, fmt.Errorf("signer %q is not available in namespace %s", alias, namespaceOr(ns))
+	}
+	if !ok {
+		return nil, fmt.Errorf("no signer configured for alias %q", alias)
+	}
+	return s, nil
+}
+
+// ---------------- LOCAL KEYS ----------------
+
+// localSigner signs with a private key held in memory.
+type localSigner struct {
+	key  *ecdsa.PrivateKey
+	addr common.Address
+}
+
+// NewMemorySigner wraps an in-memory key. It is meant for tests and local
+// development chains.
+func NewMemorySigner(key *ecdsa.PrivateKey) Signer {
+	return &localSigner{key: key, addr: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// NewKeystoreSigner decrypts a Web3 Secret Storage key file.
+func NewKeystoreSigner(path, password string) (Signer, error) {
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyJSON, password)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt keystore %s: %w", path, err)
+	}
+	return &localSigner{key: key.PrivateKey, addr: key.Address}, nil
+}
+
+func (s *localSigner) Address() common.Address { return s.addr }
+
+func (s *localSigner) SignTx(_ context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+func (s *localSigner) String() string   { return fmt.Sprintf("signer(%s)", s.addr.Hex()) }
+func (s *localSigner) GoString() string { return s.String() }
+
+// ---------------- REMOTE SIGNING ----------------
+
+// remoteSigner asks a JSON-RPC endpoint (clef, web3signer, ...) to sign via
+// eth_signTransaction.
+type remoteSigner struct {
+	url    string
+	addr   common.Address
+	client *http.Client
+}
+
+func NewRemoteSigner(endpoint string, addr common.Address, client *http.Client) Signer {
+	return &remoteSigner{url: endpoint, addr: addr, client: client}
+}
+
+func (s *remoteSigner) Address() common.Address { return s.addr }
+
+func (s *remoteSigner) String() string {
+	return fmt.Sprintf("remoteSigner(%s via %s)", s.addr.Hex(), redactURL(s.url))
+}
+func (s *remoteSigner) GoString() string { return s.String() }
+
+type rpcTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"` // legacy transactions
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+func (s *remoteSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := rpcTxArgs{
+		From:    s.addr,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	// The signer picks the transaction type from the fee fields it gets.
+	if tx.Type() == types.LegacyTxType {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	} else {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_signTransaction",
+		"params":  []interface{}{args},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("remote signer returned status %d", resp.StatusCode)
+	}
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	if rpcResp.Error != nil {
+		return nil, fmt.Errorf("remote signer: %s (code %d)", rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+
+	// clef answers {"raw": "0x..", "tx": {..}}, web3signer a bare "0x.." string.
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(rpcResp.Result, &raw); err != nil {
+		var wrapped struct {
+			Raw hexutil.Bytes `json:"raw"`
+		}
+		if err := json.Unmarshal(rpcResp.Result, &wrapped); err != nil {
+			return nil, fmt.Errorf("remote signer: unexpected result %s", rpcResp.Result)
+		}
+		raw = wrapped.Raw
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	if from != s.addr || signed.Nonce() != tx.Nonce() {
+		return nil, fmt.Errorf("remote signer returned a transaction for %s nonce %d, want %s nonce %d", from.Hex(), signed.Nonce(), s.addr.Hex(), tx.Nonce())
+	}
+	if signed.Type() != tx.Type() {
+		return nil, fmt.Errorf("remote signer returned a type %d transaction, want type %d", signed.Type(), tx.Type())
+	}
+	return signed, nil
+}
@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// --------------------- TASK ---------------------
+type Task struct {
+	ID         int
+	Spec       TaskSpec
+	MaxRetries int
+}
+
+// Run executes the task with retries and returns the output and error of
+// the last attempt. It runs in the command's environment and reports to its
+// registry, log and webhooks; see Runner for the embeddable equivalent.
+func (t Task) Run(ctx context.Context) (interface{}, error) {
+	return defaultRunner().runTask(ctx, t)
+}
+
+// defaultRunner runs in the command's environment and reports to its
+// registry.
+func defaultRunner() *Runner {
+	return registryRunner(defaultEnv.tasks, defaultEnv)
+}
+
+// registryRunner runs the builtin task types in env, reporting to reg, the
+// log and env's webhooks.
+func registryRunner(reg *TaskRegistry, env *Env) *Runner {
+	return &Runner{store: reg, exec: BuiltinExecutor(reg), logger: runLogger{env.notifier.RunID}, notifier: env.notifier, env: env}
+}
+
+// newAPIRunner runs the tasks submitted through the API in env, reporting
+// to reg under the IDs after those in use.
+func newAPIRunner(reg *TaskRegistry, env *Env, concurrency, maxRetries int) *Runner {
+	r := New(Options{Store: reg, Env: env, MaxConcurrency: concurrency, MaxRetries: maxRetries})
+	r.addNext = reg.AddNext
+	return r
+}
+
+// BuiltinExecutor runs the task types of this package: download, ai,
+// blockchain, storage, http and exec. Streamed AI text and exec output go
+// to store as the task runs.
+func BuiltinExecutor(store Store) Executor {
+	return ExecutorFunc(func(ctx context.Context, id int, spec TaskSpec) (interface{}, error) {
+		return dispatch(ctx, id, spec, store)
+	})
+}
+
+func dispatch(ctx context.Context, id int, spec TaskSpec, store Store) (interface{}, error) {
+	switch spec.Type {
+	case "download":
+		return output(taskDownload(ctx, id, spec))
+	case "ai":
+		return output(taskAI(ctx, spec, func(chunk string) { store.Partial(id, chunk) }))
+	case "blockchain":
+		return output(taskBlockchain(ctx, spec))
+	case "storage":
+		return output(taskStorage(ctx, spec.Payload, spec.Namespace))
+	case "http":
+		return output(taskHTTP(ctx, spec))
+	case "exec":
+		return output(taskExec(ctx, spec, func(stream, line string) { store.Log(id, "["+stream+"] "+line) }))
+	default:
+		return nil, fmt.Errorf("%w %s", errUnknownTaskType, spec.Type)
+	}
+}
+
+// errUnknownTaskType fails a task without retries.
+var errUnknownTaskType = errors.New("unknown task type")
+
+// output turns a task function's typed result into the task output, so a nil
+// result stays a nil interface.
+func output[T any](v *T, err error) (interface{}, error) {
+	if v == nil {
+		return nil, err
+	}
+	return v, err
+}
+
+// --------------------- TASK TYPES ---------------------
+
+// StorageOutput is the output of a storage task.
+type StorageOutput struct {
+	Path string `json:"path"`
+	CID  string `json:"cid,omitempty"`
+}
+
+// Storage task: the payload is a file path or an artifact reference
+// "sha256:<digest>" from an earlier task of namespace ns. The file is added
+// to IPFS when an API is configured.
+func taskStorage(ctx context.Context, ref, ns string) (*StorageOutput, error) {
+	env := envOf(ctx)
+	path, err := env.artifacts.ResolveIn(ref, ns)
+	if err != nil {
+		return nil, err
+	}
+	if env.ipfsAPI == "" {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Storage task canceled")
+		case <-time.After(200 * time.Millisecond):
+		}
+		log.Printf("[Storage] Uploaded file: %s", path)
+		return &StorageOutput{Path: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sh := shell.NewShellWithClient(env.ipfsAPI, env.httpClient)
+	cid, err := sh.Add(file)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs add: %w", err)
+	}
+	return &StorageOutput{Path: path, CID: cid}, nil
+}
+
+// --------------------- LOGGING ---------------------
+func logTask(taskID, attempt int, msg string) {
+	defaultRunner().log(taskID, attempt, msg)
+}
+
+// runLogger writes one JSON line per message to the standard logger.
+type runLogger struct{ runID string }
+
+func (l runLogger) Log(taskID, attempt int, msg string) {
+	entry := map[string]interface{}{
+		"timestamp": time.Now().Format(time.RFC3339),
+		"runID":     l.runID,
+		"taskID":    taskID,
+		"attempt":   attempt,
+		"message":   redactions.Redact(msg),
+	}
+	data, _ := json.Marshal(entry)
+	log.Println(string(data))
+}
@@ -0,0 +1,335 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types sent to webhooks.
+const (
+	EventTaskSucceeded    = "task.succeeded"
+	EventTaskFailed       = "task.failed"        // failed after its last retry
+	EventTaskDeadLettered = "task.dead_lettered" // joined GET /dlq, also when the shared queue gives up on an expired lease
+	EventPipelineFinished = "pipeline.finished"  // a task tree of a pipeline run is done
+	EventRunFinished      = "run.finished"
+
+	EventBreakerChanged = "breaker.state_changed"
+)
+
+// WebhookConfig is one receiver in CONFIG_JSON.
+type WebhookConfig struct {
+	URL         string   `json:"url"`
+	SecretEnv   string   `json:"secret_env,omitempty"`   // env var holding the HMAC secret
+	Events      []string `json:"events,omitempty"`       // event types to send, default all
+	MaxAttempts int      `json:"max_attempts,omitempty"` // default 5
+}
+
+func (c WebhookConfig) String() string {
+	return fmt.Sprintf("{URL:%s SecretEnv:%s Events:%v MaxAttempts:%d}", redactURL(c.URL), c.SecretEnv, c.Events, c.MaxAttempts)
+}
+
+// Event is the JSON body of a webhook call. ID is stable for a given run,
+// type and task, so receivers can drop duplicates.
+type Event struct {
+	ID    string      `json:"id"`
+	Type  string      `json:"type"`
+	RunID string      `json:"run_id"`
+	Time  time.Time   `json:"time"`
+	Task  *TaskRecord `json:"task,omitempty"`
+	Run   *RunSummary `json:"run,omitempty"`
+
+	Pipeline *PipelineSummary `json:"pipeline,omitempty"`
+
+	Breaker *BreakerState `json:"breaker,omitempty"`
+}
+
+// RunSummary is the payload of run.finished.
+type RunSummary struct {
+	Tasks     int `json:"tasks"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// PipelineSummary is the payload of pipeline.finished: the tasks of the
+// tree grown from Root, which ran in Namespace.
+type PipelineSummary struct {
+	Root      int    `json:"root"`
+	Namespace string `json:"namespace"`
+	RunSummary
+}
+
+// Delivery is one event sent to one webhook, as kept in the delivery log.
+type Delivery struct {
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Namespace  string    `json:"namespace,omitempty"` // of the task, for task and pipeline events
+	URL        string    `json:"url"`
+	Status     string    `json:"status"` // pending, delivered, failed
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+type webhook struct {
+	url         string
+	secret      []byte
+	events      []string
+	maxAttempts int
+}
+
+// Notifier delivers events to the configured webhooks in the background,
+// retrying failed calls with exponential backoff.
+type Notifier struct {
+	RunID   string
+	client  *http.Client
+	hooks   []webhook
+	backoff time.Duration // first retry delay, doubled per attempt
+
+	maxSeen int // event IDs remembered for deduplication
+	maxLog  int // deliveries kept in the log
+
+	mu         sync.Mutex
+	seen       map[string]bool
+	seenOrder  []string // oldest first, for eviction
+	deliveries []*Delivery
+	wg         sync.WaitGroup
+}
+
+// Bounds of the notifier's memory in long-running processes. Duplicates of
+// an event older than the last defaultMaxSeen are sent again.
+const (
+	defaultMaxSeen = 10000
+	defaultMaxLog  = 1000
+)
+
+func NewNotifier(cfgs []WebhookConfig, client *http.Client) (*Notifier, error) {
+	n := &Notifier{RunID: uuid.NewString(), client: client, backoff: time.Second, seen: map[string]bool{},
+		maxSeen: defaultMaxSeen, maxLog: defaultMaxLog}
+	for i, c := range cfgs {
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook %d: url missing", i)
+		}
+		h := webhook{url: c.URL, events: c.Events, maxAttempts: c.MaxAttempts}
+		if h.maxAttempts <= 0 {
+			h.maxAttempts = 5
+		}
+		if c.SecretEnv != "" {
+			secret := os.Getenv(c.SecretEnv)
+			if secret == "" {
+				return nil, fmt.Errorf("webhook %s: %s is empty", redactURL(c.URL), c.SecretEnv)
+			}
+			h.secret = []byte(secret)
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+// TaskEvent builds the event for a task state change. A task requeued under
+// its ID can end more than once, so the finish time is part of the ID.
+func (n *Notifier) TaskEvent(eventType string, rec TaskRecord) Event {
+	id := fmt.Sprintf("%s:%s:%d", n.RunID, eventType, rec.ID)
+	if !rec.Finished.IsZero() {
+		id += fmt.Sprintf(":%d", rec.Finished.UnixNano())
+	}
+	return Event{
+		ID:    id,
+		Type:  eventType,
+		RunID: n.RunID,
+		Time:  time.Now().UTC(),
+		Task:  &rec,
+	}
+}
+
+// RunFinishedEvent builds the event sent once all tasks are done.
+func (n *Notifier) RunFinishedEvent(r Store) Event {
+	s := &RunSummary{}
+	for _, rec := range r.List() {
+		s.Tasks++
+		switch rec.Status {
+		case "success":
+			s.Succeeded++
+		case "failed":
+			s.Failed++
+		}
+	}
+	return Event{
+		ID:    n.RunID + ":" + EventRunFinished,
+		Type:  EventRunFinished,
+		RunID: n.RunID,
+		Time:  time.Now().UTC(),
+		Run:   s,
+	}
+}
+
+// PipelineEvent builds the event sent once a task tree is done.
+func (n *Notifier) PipelineEvent(s PipelineSummary) Event {
+	return Event{
+		ID:       fmt.Sprintf("%s:%s:%d", n.RunID, EventPipelineFinished, s.Root),
+		Type:     EventPipelineFinished,
+		RunID:    n.RunID,
+		Time:     time.Now().UTC(),
+		Pipeline: &s,
+	}
+}
+
+// BreakerEvent builds the event for a circuit breaker state change.
+func (n *Notifier) BreakerEvent(st BreakerState) Event {
+	return Event{
+		ID:      fmt.Sprintf("%s:%s:%s:%d", n.RunID, EventBreakerChanged, st.Dependency, st.Transitions),
+		Type:    EventBreakerChanged,
+		RunID:   n.RunID,
+		Time:    time.Now().UTC(),
+		Breaker: &st,
+	}
+}
+
+// Notify queues ev for every webhook subscribed to its type. An event ID
+// that was recently queued is ignored.
+func (n *Notifier) Notify(ev Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen[ev.ID] {
+		return
+	}
+	n.seen[ev.ID] = true
+	n.seenOrder = append(n.seenOrder, ev.ID)
+	if len(n.seenOrder) > n.maxSeen {
+		delete(n.seen, n.seenOrder[0])
+		n.seenOrder = n.seenOrder[1:]
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	for _, h := range n.hooks {
+		if len(h.events) > 0 && !slices.Contains(h.events, ev.Type) {
+			continue
+		}
+		d := &Delivery{EventID: ev.ID, EventType: ev.Type, URL: redactURL(h.url), Status: "pending", Updated: time.Now()}
+		switch {
+		case ev.Task != nil:
+			d.Namespace = namespaceOr(ev.Task.Namespace)
+		case ev.Pipeline != nil:
+			d.Namespace = ev.Pipeline.Namespace
+		}
+		n.deliveries = append(n.deliveries, d)
+		if len(n.deliveries) > n.maxLog {
+			// The oldest entries go; their deliveries still finish.
+			n.deliveries = n.deliveries[len(n.deliveries)-n.maxLog:]
+		}
+		n.wg.Add(1)
+		go n.deliver(h, d, body)
+	}
+}
+
+// deliver posts body until the receiver accepts it. Network errors, 408,
+// 429 and 5xx are retried; other statuses fail the delivery at once.
+func (n *Notifier) deliver(h webhook, d *Delivery, body []byte) {
+	defer n.wg.Done()
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		code, err := n.post(h, d.EventType, d.EventID, body)
+		retry := err != nil || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+
+		n.mu.Lock()
+		d.Attempts, d.StatusCode, d.Updated, d.Error = attempt, code, time.Now(), ""
+		switch {
+		case err != nil:
+			d.Error = err.Error()
+		case code >= 300:
+			d.Error = "unexpected status " + strconv.Itoa(code)
+		}
+		if d.Error == "" {
+			d.Status = "delivered"
+		} else if !retry || attempt >= h.maxAttempts {
+			d.Status = "failed"
+		}
+		done := d.Status != "pending"
+		n.mu.Unlock()
+
+		if done {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *Notifier) post(h webhook, eventType, eventID string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Web4-Event", eventType)
+	req.Header.Set("X-Web4-Event-Id", eventID)
+	req.Header.Set("X-Web4-Timestamp", ts)
+	if h.secret != nil {
+		req.Header.Set("X-Web4-Signature", "sha256="+signPayload(h.secret, ts, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// signPayload is the HMAC-SHA256 of "<timestamp>.<body>", hex encoded.
+// Receivers recompute it from the X-Web4-Timestamp header and the raw body.
+func signPayload(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliveries returns the delivery log, optionally filtered by event ID and
+// status, for the namespaces keep accepts; events of no task have the
+// namespace "".
+func (n *Notifier) Deliveries(eventID, status string, keep func(ns string) bool) []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := []Delivery{}
+	for _, d := range n.deliveries {
+		if (eventID == "" || d.EventID == eventID) && (status == "" || d.Status == status) && keep(d.Namespace) {
+			out = append(out, *d)
+		}
+	}
+	return out
+}
+
+// Wait blocks until every queued delivery has finished or ctx is done.
+func (n *Notifier) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still pending: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,228 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedEvent struct {
+	Event
+	validSig bool
+}
+
+// webhookReceiver records events and fails the first failFirst calls.
+func webhookReceiver(t *testing.T, secret string, failFirst int) (*httptest.Server, func() []receivedEvent) {
+	var mu sync.Mutex
+	var got []receivedEvent
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls <= failFirst {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var ev Event
+		json.Unmarshal(body, &ev)
+		want := "sha256=" + signPayload([]byte(secret), r.Header.Get("X-Web4-Timestamp"), body)
+		got = append(got, receivedEvent{ev, r.Header.Get("X-Web4-Signature") == want && r.Header.Get("X-Web4-Event-Id") == ev.ID})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedEvent(nil), got...)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_SECRET", "whsec")
+	all, allEvents := webhookReceiver(t, "whsec", 2)
+	failures, failureEvents := webhookReceiver(t, "", 0)
+
+	n, err := NewNotifier([]WebhookConfig{
+		{URL: all.URL, SecretEnv: "TEST_WEBHOOK_SECRET"},
+		{URL: failures.URL, Events: []string{EventTaskFailed}},
+	}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.backoff = time.Millisecond
+
+	reg := NewTaskRegistry()
+	reg.Add(1, "ai")
+	reg.Finish(1, "done", nil, true)
+	reg.Add(2, "download")
+	reg.Finish(2, nil, errors.New("404"), true)
+	rec1, _ := reg.Get(1)
+	rec2, _ := reg.Get(2)
+	n.Notify(n.TaskEvent(EventTaskSucceeded, rec1))
+	n.Notify(n.TaskEvent(EventTaskSucceeded, rec1)) // duplicate
+	n.Notify(n.TaskEvent(EventTaskFailed, rec2))
+	n.Notify(n.RunFinishedEvent(reg))
+	if err := n.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	evs := allEvents()
+	if len(evs) != 3 {
+		t.Fatalf("got %d events, want 3", len(evs))
+	}
+	for _, ev := range evs {
+		if !ev.validSig || ev.RunID != n.RunID {
+			t.Fatalf("bad delivery %+v", ev)
+		}
+		if ev.Type == EventRunFinished && (ev.Run.Tasks != 2 || ev.Run.Succeeded != 1 || ev.Run.Failed != 1) {
+			t.Fatalf("run summary = %+v", ev.Run)
+		}
+	}
+	if evs := failureEvents(); len(evs) != 1 || evs[0].Type != EventTaskFailed || evs[0].Task.ID != 2 {
+		t.Fatalf("failure receiver got %+v", evs)
+	}
+
+	log := n.Deliveries("", "delivered", func(string) bool { return true })
+	if len(log) != 4 {
+		t.Fatalf("delivered = %d, want 4", len(log))
+	}
+	retried := 0
+	for _, d := range log {
+		retried += d.Attempts - 1
+	}
+	if retried != 2 {
+		t.Fatalf("retries = %d, want 2", retried)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer srv.Close()
+	n, _ := NewNotifier([]WebhookConfig{{URL: srv.URL, MaxAttempts: 3}}, http.DefaultClient)
+	n.backoff = time.Millisecond
+
+	ev := n.TaskEvent(EventTaskFailed, TaskRecord{ID: 7})
+	n.Notify(ev)
+	n.Wait(context.Background())
+
+	log := n.Deliveries(ev.ID, "", func(string) bool { return true })
+	if calls != 1 || len(log) != 1 || log[0].Status != "failed" || log[0].StatusCode != 410 {
+		t.Fatalf("calls = %d log = %+v", calls, log)
+	}
+	if _, err := NewNotifier([]WebhookConfig{{URL: srv.URL, SecretEnv: "UNSET_WEBHOOK_SECRET"}}, nil); err == nil {
+		t.Fatal("expected error for an empty secret")
+	}
+}
+
+func useWebhooks(t *testing.T, n *Notifier) {
+	t.Helper()
+	old := defaultEnv.notifier
+	t.Cleanup(func() { defaultEnv.notifier = old })
+	defaultEnv.notifier = n
+}
+
+func TestWebhookPipelineEvents(t *testing.T) {
+	useRegistry(t)
+	srv, events := webhookReceiver(t, "", 0)
+	n, err := NewNotifier([]WebhookConfig{{URL: srv.URL, Events: []string{EventPipelineFinished, EventTaskDeadLettered}}}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useWebhooks(t, n)
+
+	RunTree(context.Background(), []PipelineTask{
+		{ID: 1, TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/a.txt", Namespace: "team-a"}, Next: []PipelineTask{
+			{TaskSpec: TaskSpec{Type: "storage", Payload: "/tmp/b.txt"}, Next: []PipelineTask{
+				{TaskSpec: TaskSpec{Type: "bogus"}},
+			}},
+		}},
+		{ID: 7, TaskSpec: TaskSpec{Type: "bogus"}},
+	}, 2, 1, 0, StaticNext)
+	n.Wait(context.Background())
+
+	pipelines := map[int]PipelineSummary{}
+	var dead []int
+	for _, ev := range events() {
+		switch ev.Type {
+		case EventPipelineFinished:
+			pipelines[ev.Pipeline.Root] = *ev.Pipeline
+		case EventTaskDeadLettered:
+			dead = append(dead, ev.Task.ID)
+		}
+	}
+	want := map[int]PipelineSummary{
+		1: {Root: 1, Namespace: "team-a", RunSummary: RunSummary{Tasks: 3, Succeeded: 2, Failed: 1}},
+		7: {Root: 7, Namespace: DefaultNamespace, RunSummary: RunSummary{Tasks: 1, Failed: 1}},
+	}
+	if len(pipelines) != 2 || pipelines[1] != want[1] || pipelines[7] != want[7] {
+		t.Errorf("pipelines = %+v", pipelines)
+	}
+	if len(dead) != 2 {
+		t.Errorf("dead-lettered = %v", dead)
+	}
+	if log := n.Deliveries("", "", func(ns string) bool { return ns == "team-a" }); len(log) != 2 {
+		t.Errorf("team-a deliveries = %+v", log)
+	}
+}
+
+func TestNotifierBounds(t *testing.T) {
+	srv, events := webhookReceiver(t, "", 0)
+	n, _ := NewNotifier([]WebhookConfig{{URL: srv.URL}}, http.DefaultClient)
+	n.maxSeen, n.maxLog = 2, 3
+	for id := 1; id <= 5; id++ {
+		n.Notify(n.TaskEvent(EventTaskSucceeded, TaskRecord{ID: id}))
+	}
+	n.Notify(n.TaskEvent(EventTaskSucceeded, TaskRecord{ID: 5})) // still remembered
+	n.Notify(n.TaskEvent(EventTaskSucceeded, TaskRecord{ID: 1})) // forgotten, sent again
+	n.Wait(context.Background())
+
+	if len(n.seen) != 2 || len(n.seenOrder) != 2 {
+		t.Errorf("remembered %d event IDs, want 2", len(n.seen))
+	}
+	if got := len(events()); got != 6 {
+		t.Errorf("delivered %d events, want 6", got)
+	}
+	log := n.Deliveries("", "", func(string) bool { return true })
+	if len(log) != 3 || log[2].EventID != n.TaskEvent(EventTaskSucceeded, TaskRecord{ID: 1}).ID {
+		t.Errorf("delivery log = %+v", log)
+	}
+}
+
+func TestWebhookRequeuedTaskFailsAgain(t *testing.T) {
+	srv, events := webhookReceiver(t, "", 0)
+	n, _ := NewNotifier([]WebhookConfig{{URL: srv.URL}}, http.DefaultClient)
+	ctx := context.Background()
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.db"), time.Minute)
+	now := time.Unix(1700000000, 0)
+	q.now = func() time.Time { return now }
+
+	id, _, _ := q.Enqueue(ctx, "", TaskSpec{Type: "count"})
+	for range 2 {
+		if got, _ := q.Claim(ctx, "a", []string{"count"}, 1); len(got) != 1 {
+			t.Fatalf("claim = %+v", got)
+		}
+		now = now.Add(time.Second)
+		if err := q.Complete(ctx, id, "a", nil, errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+		rec, _ := q.Get(ctx, id)
+		n.Notify(n.TaskEvent(EventTaskFailed, rec.taskRecord()))
+		q.Requeue(ctx, id)
+	}
+	n.Wait(ctx)
+	if evs := events(); len(evs) != 2 || evs[0].ID == evs[1].ID {
+		t.Fatalf("events = %+v", evs)
+	}
+}
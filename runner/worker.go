@@ -0,0 +1,234 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ---------------- QUEUE WORKER ----------------
+
+// builtinTaskTypes are the task types BuiltinExecutor runs.
+var builtinTaskTypes = []string{"download", "ai", "blockchain", "storage", "http", "exec"}
+
+// Worker claims tasks from a shared Queue and runs them on a Runner. Its
+// leases are renewed by heartbeat while the tasks run and handed back when
+// the worker stops.
+type Worker struct {
+	ID           string
+	Capabilities []string
+	Capacity     int
+	Heartbeat    time.Duration
+	PollInterval time.Duration
+
+	queue  *Queue
+	runner *Runner
+
+	mu      sync.Mutex
+	running map[int]context.CancelCauseFunc
+	wg      sync.WaitGroup
+	wake    chan struct{}
+}
+
+// NewWorker creates a worker for q that runs tasks with r's executor,
+// store, logger and retries. Unset fields of cfg get their defaults.
+func NewWorker(q *Queue, r *Runner, cfg QueueConfig) *Worker {
+	w := &Worker{
+		ID:           workerID(),
+		Capabilities: cfg.Capabilities,
+		Capacity:     cfg.Capacity,
+		Heartbeat:    time.Duration(cfg.Heartbeat),
+		PollInterval: time.Duration(cfg.PollInterval),
+		queue:        q,
+		runner:       r,
+		running:      map[int]context.CancelCauseFunc{},
+		wake:         make(chan struct{}, 1),
+	}
+	if len(w.Capabilities) == 0 {
+		w.Capabilities = builtinTaskTypes
+	}
+	if w.Capacity <= 0 {
+		w.Capacity = max(cap(r.sem), 1)
+	}
+	if w.Heartbeat <= 0 {
+		w.Heartbeat = q.leaseTTL / 3
+	}
+	if w.PollInterval <= 0 {
+		w.PollInterval = time.Second
+	}
+	return w
+}
+
+// workerID names a worker after its host, which is the pod name on
+// Kubernetes.
+func workerID() string {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "worker"
+	}
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// Run registers the worker and runs queued tasks until ctx is canceled.
+// It then cancels the tasks still running, releases their leases for other
+// workers to pick up, and marks itself stopped.
+func (w *Worker) Run(ctx context.Context) error {
+	bg := context.WithoutCancel(ctx)
+	if err := w.queue.RegisterWorker(bg, WorkerInfo{ID: w.ID, Capabilities: w.Capabilities, Capacity: w.Capacity}); err != nil {
+		return err
+	}
+	log.Printf("[Worker %s] Started: capacity=%d capabilities=%v", w.ID, w.Capacity, w.Capabilities)
+
+	tasksCtx, stopTasks := context.WithCancelCause(bg)
+	heartbeat := time.NewTicker(w.Heartbeat)
+	defer heartbeat.Stop()
+	poll := time.NewTicker(w.PollInterval)
+	defer poll.Stop()
+
+	w.claim(tasksCtx)
+	for {
+		select {
+		case <-ctx.Done():
+			stopTasks(errTaskReleased)
+			w.wg.Wait()
+			log.Printf("[Worker %s] Stopped", w.ID)
+			return w.queue.DeregisterWorker(bg, w.ID)
+		case <-heartbeat.C:
+			w.heartbeat(bg)
+		case <-poll.C:
+			w.claim(tasksCtx)
+		case <-w.wake:
+			w.claim(tasksCtx)
+		}
+	}
+}
+
+// Load is the number of tasks the worker is running.
+func (w *Worker) Load() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.running)
+}
+
+func (w *Worker) claim(ctx context.Context) {
+	free := w.Capacity - w.Load()
+	if free <= 0 {
+		return
+	}
+	claimed, err := w.queue.Claim(ctx, w.ID, w.Capabilities, free)
+	if err != nil {
+		log.Printf("[Worker %s] %v", w.ID, err)
+		return
+	}
+	for _, t := range claimed {
+		taskCtx, cancel := context.WithCancelCause(ctx)
+		w.mu.Lock()
+		w.running[t.ID] = cancel
+		w.mu.Unlock()
+		w.wg.Add(1)
+		go w.run(taskCtx, t)
+	}
+}
+
+func (w *Worker) run(ctx context.Context, t QueuedTask) {
+	defer w.wg.Done()
+	defer func() {
+		w.mu.Lock()
+		w.running[t.ID](nil)
+		delete(w.running, t.ID)
+		w.mu.Unlock()
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	r := w.runner
+	r.store.Add(t.ID, t.Spec.Type)
+	if tr, ok := r.store.(tracker); ok {
+		tr.SetNamespace(t.ID, namespaceOf(t.Spec))
+	}
+	if t.Reclaimed {
+		r.log(t.ID, 0, fmt.Sprintf("Reclaimed after an expired lease, claim %d", t.Claims))
+	}
+	// The task holds its lease while it waits for a namespace slot; the
+	// queue itself knows nothing of namespace limits.
+	defer r.env.quotas.Acquire(ctx, t.Spec.Namespace)()
+	out, err := r.runTask(ctx, Task{ID: t.ID, Spec: t.Spec, MaxRetries: r.maxRetries})
+
+	bg := context.WithoutCancel(ctx)
+	if released(ctx) {
+		if err := w.queue.Release(bg, t.ID, w.ID); err != nil && !errors.Is(err, ErrLeaseLost) {
+			r.log(t.ID, 0, err.Error())
+		}
+		return
+	}
+	if err := w.queue.Complete(bg, t.ID, w.ID, redactions.RedactValue(out), redactions.RedactError(err)); err != nil {
+		r.log(t.ID, 0, fmt.Sprintf("Result dropped: %v", err))
+	}
+}
+
+// heartbeat renews the worker's leases and cancels the tasks it has lost.
+func (w *Worker) heartbeat(ctx context.Context) {
+	held, err := w.queue.Heartbeat(ctx, w.ID, w.Load())
+	if err != nil {
+		log.Printf("[Worker %s] %v", w.ID, err)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, cancel := range w.running {
+		if !held[id] {
+			cancel(fmt.Errorf("%w: %w", errTaskReleased, ErrLeaseLost))
+		}
+	}
+}
+
+// sharedQueue is the queue of the worker command, for the API.
+var sharedQueue atomic.Pointer[Queue]
+
+func serveWorkers(w http.ResponseWriter, req *http.Request) {
+	if !unrestricted(req) {
+		forbiddenShared(w, req)
+		return
+	}
+	q := sharedQueue.Load()
+	if q == nil {
+		http.Error(w, "no task queue configured", http.StatusNotFound)
+		return
+	}
+	workers, err := q.Workers(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, workers)
+}
+
+func serveQueue(w http.ResponseWriter, req *http.Request) {
+	q := sharedQueue.Load()
+	if q == nil {
+		http.Error(w, "no task queue configured", http.StatusNotFound)
+		return
+	}
+	recs, err := q.List(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := []QueueRecord{}
+	for _, rec := range recs {
+		if visible(req, rec.Namespace) {
+			out = append(out, rec)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}